@@ -0,0 +1,3 @@
+module gitlab.com/esr/loccount
+
+go 1.25
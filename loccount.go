@@ -4,1734 +4,1410 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	encjson "encoding/json"
 	"flag"
 	"fmt"
-	"go/ast"
-	"go/parser"
-	"go/token"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"math"
+	"net"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"gitlab.com/esr/loccount/pkg/counter"
 )
 
 const version string = "2.0"
 
-/*
-How to add support for a language to this program:
-
-All the language-specific information this program needs to know to do
-its job is the syntax of comments and string literals.  Generally,
-languages fall into one of the following groups:
-
-* C-like: C is the prototype. This code recognizes them by file
-  extension and verifier.  These languages have two kinds of comment.
-  One is a block comment delimited by two distinct strings and the
-  second is a winged comment introduced by a third string and
-  terminated by newline.You can add support simply by appending
-  an initializer to the genericLanguages table; any entry with a
-  nonempty block-comment leader invokes C-like parsing.  See tthe
-  list of syntax flags for more.
-
-* Generic languages have only winged comments, usually led with #.
-  This code recognizes them by file extension and verifier.  You can
-  append an initializer to the genericLanguages table specifying a
-  name, an extension, and the winged-comment leader.  Any entry with
-  empty commentleader and trailer strings gets generic parsing.
-
-* Scripting languages have only winged comments, always led with #.
-  This code recognizes them by file extension, or by looking for a
-  hashbang line identifying the interpreter.  You can append an
-  initializer to the scriptingLanguages table specifying a name, an
-  extension, and a matching string to look for in a hashbang line.
-
-* Pascal-likes use the (* *) block comment syntax.  This code
-  recognizes them by file extension and verifier.  You can append an
-  initializer to the PascalLikes table specifying a name, an
-  extension, and a boolean saying whether the language uses { } as
-  additional pair of block comments.
-
-* Fortran-likes use various start-of-line characters as comment
-  leaders.  This code recognizes them by file extension only.  You can
-  append an initializer to the fortranLikes table specifying a pair of
-  (compiled) regular expressions; comments are recognized by matching
-  the first and not the second.
-
-You may add multiple entries with the same language name, but extensions
-must be unique across all tables - *except* that entries with verifiers
-may share extensions with each other and with one trailing entry that has
-no verifier.
-
-Important last step in adding a language: Add an example to the test suite!
-*/
-
-// Following code swiped from Michael T. Jones's "walk" package.
-// It's a parallelized implementation of tree-walking that's
-// faster than the version in the system filepath library.
-// Note, however, it seems to have a limitation - does not like paths
-// containing "..".
-
-type visitData struct {
-	path string
-	info os.FileInfo
-}
-
-// WalkFunc is the type of the function called for each file or directory
-// visited by Walk. The path argument contains the argument to Walk as a
-// prefix; that is, if Walk is called with "dir", which is a directory
-// containing the file "a", the walk function will be called with argument
-// "dir/a". The info argument is the os.FileInfo for the named path.
-//
-// If there was a problem walking to the file or directory named by path, the
-// incoming error will describe the problem and the function can decide how
-// to handle that error (and Walk will not descend into that directory). If
-// an error is returned, processing stops. The sole exception is that if path
-// is a directory and the function returns the special value SkipDir, the
-// contents of the directory are skipped and processing continues as usual on
-// the next file.
-type WalkFunc func(path string, info os.FileInfo, err error) error
+// Pseudo-languages for lines that aren't code.  They get report rows
+// of their own but are left out of the totals.
+var notCode = map[string]bool{"disabled": true, "minified": true,
+	"comment-only": true, "blank-only": true}
 
-type walkState struct {
-	walkFn     WalkFunc
-	v          chan visitData // files to be processed
-	active     sync.WaitGroup // number of files to process
-	lock       sync.RWMutex
-	firstError error // accessed using lock
+// Minified files are left out of the totals unless countMinified asks
+// for them.
+var countMinified bool
+
+// isDirectory, isRegular - what is at a path, judging by the file system
+func isDirectory(path string) bool {
+	fileInfo, err := os.Stat(path)
+	return err == nil && fileInfo.Mode().IsDir()
 }
 
-func (ws *walkState) terminated() bool {
-	ws.lock.RLock()
-	done := ws.firstError != nil
-	ws.lock.RUnlock()
-	return done
+func isRegular(path string) bool {
+	fileInfo, err := os.Stat(path)
+	return err == nil && fileInfo.Mode().IsRegular()
 }
 
-func (ws *walkState) setTerminated(err error) {
-	ws.lock.Lock()
-	if ws.firstError == nil {
-		ws.firstError = err
-	}
-	ws.lock.Unlock()
-	return
+// reported - does a result get a line of its own in the report?
+func reported(st counter.SourceStat) bool {
+	return st.SLOC > 0 || notCode[st.Language]
 }
 
-func (ws *walkState) visitChannel() {
-	for file := range ws.v {
-		ws.visitFile(file)
-		ws.active.Add(-1)
-	}
+type forceList []string
+
+func (f *forceList) String() string {
+	return strings.Join(*f, ",")
 }
 
-// readDirNames reads the directory named by dirname and returns
-// a sorted list of directory entries.
-func readDirNames(dirname string) ([]string, error) {
-	f, err := os.Open(dirname)
-	if err != nil {
-		return nil, err
-	}
-	names, err := f.Readdirnames(-1)
-	f.Close()
-	if err != nil {
-		return nil, err
-	}
-	sort.Strings(names) // omit sort to save 1-2%
-	return names, nil
+func (f *forceList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
 }
 
-func (ws *walkState) visitFile(file visitData) {
-	if ws.terminated() {
+// Diagnostics about malformed source can be silenced and counted, or
+// collected for machine-readable output.
+
+var quiet bool
+var collectWarnings bool
+var warnLock sync.Mutex
+var suppressedWarnings uint
+var warnings []counter.Warning
+var warningCounts = map[string]map[string]uint{} // by language, then kind
+
+// noteWarning - take a problem the counters found in a file
+func noteWarning(w counter.Warning) {
+	warnLock.Lock()
+	defer warnLock.Unlock()
+	if warningCounts[w.Language] == nil {
+		warningCounts[w.Language] = map[string]uint{}
+	}
+	warningCounts[w.Language][w.Kind]++
+	if collectWarnings {
+		warnings = append(warnings, w)
+		return
+	}
+	if quiet {
+		suppressedWarnings++
 		return
 	}
+	fmt.Fprintln(os.Stderr, w.Message)
+}
 
-	err := ws.walkFn(file.path, file.info, nil)
-	if err != nil {
-		if !(file.info.IsDir() && err == filepath.SkipDir) {
-			ws.setTerminated(err)
+// warningSummary - how many warnings of each kind there were, most
+// frequent first
+func warningSummary() string {
+	kinds := map[string]uint{}
+	for _, counts := range warningCounts {
+		for kind, n := range counts {
+			kinds[kind] += n
 		}
-		return
 	}
+	var names []string
+	for kind := range kinds {
+		names = append(names, kind)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if kinds[names[i]] != kinds[names[j]] {
+			return kinds[names[i]] > kinds[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for i, kind := range names {
+		names[i] = fmt.Sprintf("%d %s", kinds[kind], kind)
+	}
+	return strings.Join(names, ", ")
+}
 
-	if !file.info.IsDir() {
+// The audit log records every path left out of the totals, and why,
+// as one JSON object per line.
+
+var auditLog *bufio.Writer
+var auditLock sync.Mutex
+
+// audit - record a path left out of the totals
+func audit(path string, reason string) {
+	if auditLog == nil {
 		return
 	}
+	record, _ := encjson.Marshal(struct {
+		Path   string `json:"path"`
+		Reason string `json:"reason"`
+	}{path, reason})
+	auditLock.Lock()
+	auditLog.Write(append(record, '\n'))
+	auditLock.Unlock()
+}
 
-	names, err := readDirNames(file.path)
-	if err != nil {
-		err = ws.walkFn(file.path, file.info, err)
-		if err != nil {
-			ws.setTerminated(err)
+// auditResults - record a counted file if none of it reached the totals
+func auditResults(results []counter.SourceStat) {
+	for _, st := range results {
+		if st.SLOC > 0 && !notCode[st.Language] {
+			return
 		}
+	}
+	if len(results) == 0 {
 		return
 	}
-
-	here := file.path
-	for _, name := range names {
-		file.path = filepath.Join(here, name)
-		file.info, err = os.Lstat(file.path)
-		if err != nil {
-			err = ws.walkFn(file.path, file.info, err)
-			if err != nil && (!file.info.IsDir() || err != filepath.SkipDir) {
-				ws.setTerminated(err)
-				return
-			}
-		} else {
-			switch file.info.IsDir() {
-			case true:
-				ws.active.Add(1) // presume channel send will succeed
-				select {
-				case ws.v <- file:
-					// push directory info to queue for concurrent traversal
-				default:
-					// undo increment when send fails and handle now
-					ws.active.Add(-1)
-					ws.visitFile(file)
-				}
-			case false:
-				err = ws.walkFn(file.path, file.info, nil)
-				if err != nil {
-					ws.setTerminated(err)
-					return
-				}
-			}
-		}
+	st := results[0]
+	switch {
+	case st.Reason != "":
+		audit(st.Path, st.Reason)
+	case st.SLOC > 0:
+		audit(st.Path, st.Language)
+	case st.Language != "":
+		audit(st.Path, "no-code")
+	default:
+		audit(st.Path, "unclassified")
 	}
 }
 
-// Walk walks the file tree rooted at root, calling walkFn for each file or
-// directory in the tree, including root. All errors that arise visiting files
-// and directories are filtered by walkFn. The files are walked in a random
-// order. walk does not follow symbolic links.
-
-func walk(root string, walkFn WalkFunc) error {
-	info, err := os.Lstat(root)
-	if err != nil {
-		return walkFn(root, nil, err)
+// noteSkipped - take a path the walk leaves out
+func noteSkipped(path string, reason string, dir bool) {
+	audit(path, reason)
+	if statistics != nil && !dir {
+		statistics.skip(reason)
 	}
+}
 
-	ws := &walkState{
-		walkFn: walkFn,
-		v:      make(chan visitData, 1024),
+// noteCounted - take a file the walk has counted
+func noteCounted(path string, info fs.FileInfo, results []counter.SourceStat, elapsed time.Duration) {
+	if auditLog != nil {
+		auditResults(results)
 	}
-	defer close(ws.v)
-
-	ws.active.Add(1)
-	ws.v <- visitData{root, info}
-
-	walkers := 16
-	for i := 0; i < walkers; i++ {
-		go ws.visitChannel()
+	if statistics != nil {
+		statistics.scanned(results, info, elapsed)
+	}
+	if progress != nil {
+		progress.note(path, info)
 	}
-	ws.active.Wait()
-
-	return ws.firstError
 }
 
-// Swiped code ends here
-
-// SourceStat - line count record for a specified path
-type SourceStat struct {
-	Path     string
-	Language string
-	SLOC     uint
-	LLOC     uint
-}
+// Run statistics for --stats.
 
-func (s SourceStat) nonEmpty() bool {
-	return s.SLOC > 0
+type runStatistics struct {
+	lock     sync.Mutex
+	began    time.Time
+	files    uint
+	bytes    int64
+	skipped  map[string]uint
+	scantime map[string]time.Duration
 }
 
-var debug int
-var exclusions *regexp.Regexp
-var pipeline chan SourceStat
-
-// Data tables driving the recognition and counting of classes of languages.
+var statistics *runStatistics
 
-type genericLanguage struct {
-	name           string
-	suffix         string
-	commentleader  string
-	commenttrailer string
-	eolcomment     string
-	multistring    string
-	flags          uint
-	terminator     string
-	verifier       func(*countContext, string) bool
-}
-
-func (g genericLanguage) property(v uint) bool {
-	return (v & g.flags) != 0
-}
-
-var genericLanguages []genericLanguage
-
-type scriptingLanguage struct {
-	name     string
-	suffix   string
-	hashbang string
-	verifier func(*countContext, string) bool
-}
-
-var scriptingLanguages []scriptingLanguage
-
-type pascalLike struct {
-	name            string
-	suffix          string
-	bracketcomments bool
-	terminator      string
-	verifier        func(*countContext, string) bool
-}
-
-var pascalLikes []pascalLike
-
-const dt = `"""`
-const st = `'''`
-
-var dtriple, striple, dtrailer, strailer, dlonely, slonely *regexp.Regexp
-
-var podheader *regexp.Regexp
-
-type fortranLike struct {
-	name      string
-	suffix    string
-	comment   *regexp.Regexp
-	nocomment *regexp.Regexp
-}
-
-var fortranLikes []fortranLike
-
-var neverInterestingByPrefix []string
-var neverInterestingByInfix []string
-var neverInterestingBySuffix map[string]bool
-var neverInterestingByBasename map[string]bool
-
-var cHeaderPriority []string
-var generated string
-
-// Syntax flags
-const nf = 0x00      // no flags
-const eolwarn = 0x01 // Warn on EOL in string
-const cbs = 0x02     // C-style backslash escapes
-const gotick = 0x04  // Strong backtick a la Go
-const cpp = 0x08     // Count C preprocessor directives or Objective C #import
-const asm = 0x10     // Assembler syntax: handle multiple winged-comment types
-const mstring = 0x20 // Triple-quote string literals (not implemented)
-const cnest = 0x80   // Comments nest (not implemented)
-
-const assemblerLeaders = ";#*"	// Intel, GAS, IBM
-
-func init() {
-	// For speed, try to put more common languages and extensions
-	// earlier in this list.
-	//
-	// Verifiers are expensive, so try to put extensions that need them
-	// after extensions that don't. But remember that you don't pay the
-	// overhead for a verifier unless the extension matches.
-	//
-	// If you have multiple entries for an extension, (a) all the entries
-	// with verifiers should go first, and (b) there should be at most one
-	// entry without a verifier (because any second and later ones will be
-	// pre-empted by it).  Run loccount -s to check this property; if it's
-	// violated you get a message on stderr.
-	//
-	// All entries for a given language should be in a contiguous span,
-	// otherwise the primitive duplicate director in listLanguages will
-	// be foiled.
-	//
-	// See https://en.wikipedia.org/wiki/Comparison_of_programming_languages_(syntax)
-	genericLanguages = []genericLanguage{
-		/* C family */
-		{"c", ".c", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", nil},
-		{"c-header", ".h", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", nil},
-		{"c-header", ".hpp", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", nil},
-		{"c-header", ".hxx", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", nil},
-		{"yacc", ".y", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", nil},
-		{"lex", ".l", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", reallyLex},
-		{"c++", ".cpp", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", nil},
-		{"c++", ".cxx", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", nil},
-		{"c++", ".cc", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", nil},
-		{"java", ".java", "/*", "*/", "//", "", eolwarn | cbs, ";", nil},
-		{"javascript", ".js", "/*", "*/", "//", "", eolwarn | cbs, "", nil},
-		{"objective-c", ".m", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", reallyObjectiveC},
-		{"objective-c", ".mm", "/*", "*/", "//", "", eolwarn | cbs | cpp, ";", reallyObjectiveC},
-		{"c#", ".cs", "/*", "*/", "//", "", eolwarn | cbs, ";", nil},
-		//{"html", ".html", "<!--", "-->", "", "", nf, "", nil},
-		//{"html", ".htm", "<!--", "-->", "", "", nf, "", nil},
-		//{"xml", ".xml", "<!--", "-->", "", "", nf, "", nil},
-		{"php", ".php", "/*", "*/", "//", "", eolwarn | cbs, ";", nil},
-		{"php3", ".php3", "/*", "*/", "//", "", eolwarn | cbs, ";", nil},
-		{"php4", ".php4", "/*", "*/", "//", "", eolwarn | cbs, ";", nil},
-		{"php5", ".php5", "/*", "*/", "//", "", eolwarn | cbs, ";", nil},
-		{"php6", ".php6", "/*", "*/", "//", "", eolwarn | cbs, ";", nil},
-		{"php7", ".php7", "/*", "*/", "//", "", eolwarn | cbs, ";", nil},
-		{"go", ".go", "/*", "*/", "//", "`", eolwarn | cbs | gotick, "", nil},
-		{"swift", ".swift", "/*", "*/", "//", "", eolwarn, "", nil},
-		{"sql", ".sql", "/*", "*/", "--", "", nf, "", nil},
-		{"haskell", ".hs", "{-", "-}", "--", "", eolwarn|cnest, "", nil},
-		{"pl/1", ".pl1", "/*", "*/", "", "", eolwarn, ";", nil},
-		/* everything else */
-		{"asm", ".asm", "/*", "*/", ";", "", eolwarn|asm, "\n", nil},
-		{"asm", ".s", "/*", "*/", ";", "", eolwarn|asm, "\n", nil},
-		{"asm", ".S", "/*", "*/", ";", "", eolwarn|asm, "\n", nil},
-		{"ada", ".ada", "", "", "--", "", eolwarn, ";", nil},
-		{"ada", ".adb", "", "", "--", "", eolwarn, ";", nil},
-		{"ada", ".ads", "", "", "--", "", eolwarn, ";", nil},
-		{"ada", ".pad", "", "", "--", "", eolwarn, "", nil}, // Oracle Ada preprocessoer.
-		{"css", ".css", "/*", "*/", "", "", eolwarn, "", nil},
-		{"makefile", ".mk", "", "", "#", "", eolwarn, "", nil},
-		{"makefile", "Makefile", "", "", "#", "", eolwarn, "", nil},
-		{"makefile", "makefile", "", "", "#", "", eolwarn, "", nil},
-		{"makefile", "Imakefile", "", "", "#", "", eolwarn, "", nil},
-		{"m4", ".m4", "", "", "#", "", eolwarn, "", nil},
-		{"lisp", ".lisp", "#|", "|#", ";", "", eolwarn, "", nil},
-		{"lisp", ".lsp", "#|", "|#", ";", "", eolwarn, "", nil}, // XLISP
-		{"lisp", ".cl", "#|", "|#", ";", "", eolwarn, "", nil},  // Common Lisp
-		{"lisp", ".l", "#|", "|#", ";", "", eolwarn, "", nil},
-		{"scheme", ".scm", "", "", ";", "", eolwarn, "", nil},
-		{"elisp", ".el", "", "", ";", "", eolwarn, "", nil},    // Emacs Lisp
-		{"clojure", ".clj", "", "", ";", "", eolwarn, "", nil}, // Clojure
-		{"clojure", ".cljc", "", "", ";", "", eolwarn, "", nil},
-		{"clojurescript", ".cljs", "", "", ";", "", eolwarn, "", nil},
-		{"cobol", ".CBL", "", "", "*", "", eolwarn, "", nil},
-		{"cobol", ".cbl", "", "", "*", "", eolwarn, "", nil},
-		{"cobol", ".COB", "", "", "*", "", eolwarn, "", nil},
-		{"cobol", ".cob", "", "", "*", "", eolwarn, "", nil},
-		{"eiffel", ".e", "", "", "--", "", eolwarn, "", nil},
-		{"sather", ".sa", "", "", "--", "", eolwarn, ";", reallySather},
-		{"lua", ".lua", "--[[", "]]", "--", "", eolwarn, "", nil},
-		{"clu", ".clu", "", "", "%", "", eolwarn, ";", nil},
-		{"rust", ".rs", "", "", "//", "", eolwarn|cnest, ";", nil},
-		{"rust", ".rlib", "", "", "//", "", eolwarn, ";", nil},
-		{"erlang", ".erl", "", "", "%", "", eolwarn, "", nil},
-		{"vhdl", ".vhdl", "", "", "--", "", nf, "", nil},
-		{"verilog", ".v", "/*", "*/", "//", "", eolwarn, ";", nil},
-		{"verilog", ".vh", "/*", "*/", "//", "", eolwarn, ";", nil},
-		//{"turing", ".t", "", "", "%", "", eolwarn, "", nil},
-		{"d", ".d", "/+", "+/", "//", "", eolwarn|cnest, ";", nil},
-		{"occam", ".f", "", "", "//", "", eolwarn, "", reallyOccam},
-		{"f#", ".fs", "", "", "//", "", eolwarn, "", nil},
-		{"f#", ".fsi", "", "", "//", "", eolwarn, "", nil},
-		{"f#", ".fsx", "", "", "//", "", eolwarn, "", nil},
-		{"f#", ".fscript", "", "", "//", "", eolwarn, "", nil},
-		{"kotlin", ".kt", "", "", "//", "", eolwarn, "", nil},
-		{"dart", ".dart", "", "", "//", "", eolwarn, ";", nil},
-		{"julia", ".jl", "#=", "=#", "#", "", eolwarn|cbs|mstring, "", nil},
-		{"nim", ".nim", "#[", "]#", "#", "", eolwarn|cbs|mstring, "", nil},
-		{"prolog", ".pl", "", "", "%", "", eolwarn, ".", reallyProlog},
-		{"matlab", ".m", "%{", "}%", "%", "", eolwarn|cnest, "", reallyMatlab},
-		//{"mumps", ".m", "", "", ";", "", eolwarn, "", nil},	// See obj-c
-		{"mumps", ".mps", "", "", ";", "", eolwarn, "", nil},
-		{"mumps", ".m", "", "", ";", "", eolwarn, "", nil},
-		{"pop11", ".p", "", "", ";", "", eolwarn, "", reallyPOP11},
-		{"rebol", ".r", "", "", "comment", "", nf, "", nil},
-		{"simula", ".sim", "", "", "comment", "", nf, ";", nil},
-		{"icon", ".icn", "", "", "#", "", nf, "", nil},
-		{"cobra", ".cobra", "/#", "#/", "#", "", eolwarn | cbs, "", nil},
-		{"algol60", ".alg", "", "", "COMMENT", `"""`, nf, ";", nil},
-		{"vrml", ".wrl", "", "", "#", "", eolwarn, "", nil},
-		// autoconf cruft
-		{"autotools", "config.h.in", "/*", "*/", "//", "", eolwarn, "", nil},
-		{"autotools", "autogen.sh", "", "", "#", "", eolwarn, "", nil},
-		{"autotools", "configure.in", "", "", "#", "", eolwarn, "", nil},
-		{"autotools", "Makefile.in", "", "", "#", "", eolwarn, "", nil},
-		{"autotools", ".am", "", "", "#", "", eolwarn, "", nil},
-		{"autotools", ".ac", "", "", "#", "", eolwarn, "", nil},
-		{"autotools", ".mf", "", "", "#", "", eolwarn, "", nil},
-		// Scons
-		{"scons", "SConstruct", "", "", "#", "", eolwarn, "", nil},
-	}
-
-	var err error
-	dtriple, err = regexp.Compile(dt + "." + dt)
-	if err != nil {
-		panic(err)
-	}
-	striple, err = regexp.Compile(st + "." + st)
-	if err != nil {
-		panic(err)
-	}
-	dlonely, err = regexp.Compile("^[ \t]*\"[^\"]+\"")
-	if err != nil {
-		panic(err)
-	}
-	slonely, err = regexp.Compile("^[ \t]*'[^']+'")
-	if err != nil {
-		panic(err)
-	}
-	strailer, err = regexp.Compile(".*" + st)
-	if err != nil {
-		panic(err)
-	}
-	dtrailer, err = regexp.Compile(".*" + dt)
-	if err != nil {
-		panic(err)
-	}
-
-	scriptingLanguages = []scriptingLanguage{
-		{"tcl", ".tcl", "tcl", nil}, /* before sh, because tclsh */
-		{"tcl", ".tcl", "wish", nil},
-		{"csh", ".csh", "csh", nil},
-		{"shell", ".sh", "sh", nil},
-		{"ruby", ".rb", "ruby", nil},
-		{"awk", ".awk", "awk", nil},
-		{"sed", ".sed", "sed", nil},
-		{"expect", ".exp", "expect", reallyExpect},
-	}
-	pascalLikes = []pascalLike{
-		{"pascal", ".pas", true, ";", nil},
-		{"pascal", ".p", true, ";", reallyPascal},
-		{"pascal", ".inc", true, ";", reallyPascal},
-		{"modula", ".mod", false, ";", nil},
-		{"modula2", ".i2", false, ";", nil},
-		{"modula2", ".m2", false, ";", nil},
-		{"modula3", ".i3", false, ";", nil},
-		{"modula3", ".m3", false, ";", nil},
-		{"modula3", ".ig", false, ";", nil},
-		{"modula3", ".mg", false, ";", nil},
-		{"ml", ".ml", false, "", nil}, // Could be CAML or OCAML
-		{"ml", ".mli", false, "", nil},
-		{"ml", ".mll", false, "", nil},
-		{"ml", ".mly", false, "", nil},
-		{"oberon", ".ob", false, ";", nil},
-		{"oberon2", ".ob2", false, ";", nil},
-	}
-
-	var ferr error
-	f90comment, ferr := regexp.Compile("^([ \t]*!|[ \t]*$)")
-	if ferr != nil {
-		panic("unexpected failure while building f90 comment analyzer")
-	}
-	f90nocomment, ferr := regexp.Compile("^[ \t]*!(hpf|omp)[$]")
-	if ferr != nil {
-		panic("unexpected failure while building f90 no-comment analyzer")
-	}
-	f77comment, ferr := regexp.Compile("^([c*!]|[ \t]+!|[ \t]*$)")
-	if ferr != nil {
-		panic("unexpected failure while building f77 comment analyzer")
-	}
-	f77nocomment, ferr := regexp.Compile("^[c*!](hpf|omp)[$]")
-	if ferr != nil {
-		panic("unexpected failure while building f77 nocomment analyzer")
-	}
-	fortranLikes = []fortranLike{
-		{"fortran90", ".f90", f90comment, f90nocomment},
-		{"fortran95", ".f95", f90comment, f90nocomment},
-		{"fortran03", ".f03", f90comment, f90nocomment},
-		{"fortran", ".f77", f77comment, f77nocomment},
-		{"fortran", ".f", f77comment, f77nocomment},
-	}
-
-	var perr error
-	podheader, perr = regexp.Compile("^=[a-zA-Z]")
-	if perr != nil {
-		panic(perr)
-	}
-
-	neverInterestingByPrefix = []string{"."}
-	neverInterestingByInfix = []string{".so.", "/."}
-	ignoreSuffixes := []string{"~",
-		".a", ".la", ".o", ".so", ".ko",
-		".gif", ".jpg", ".jpeg", ".ico", ".xpm", ".xbm", ".bmp",
-		".ps", ".pdf", ".eps",
-		".tfm", ".ttf", ".bdf", ".afm",
-		".fig", ".pic",
-		".pyc", ".pyo", ".elc",
-		".1", ".2", ".3", ".4", ".5", ".6", ".7", ".8", ".n", ".man",
-		".html", ".htm", ".sgml", ".xml",
-		".adoc", "md", ".txt", ".tex", ".texi",
-		".po",
-		".gz", ".bz2", ".Z", ".tgz", ".zip",
-		".au", ".wav", ".ogg",
-	}
-	neverInterestingBySuffix = make(map[string]bool)
-	for i := range ignoreSuffixes {
-		neverInterestingBySuffix[ignoreSuffixes[i]] = true
-	}
-	neverInterestingByBasename = map[string]bool{
-		"readme": true, "readme.tk": true, "readme.md": true,
-		"changelog": true, "repository": true, "changes": true,
-		"bugs": true, "todo": true, "copying": true, "maintainers": true,
-		"news":      true,
-		"configure": true, "autom4te.cache": true, "config.log": true,
-		"config.status": true,
-		"lex.yy.c":      true, "lex.yy.cc": true,
-		"y.code.c": true, "y.tab.c": true, "y.tab.h": true,
-	}
-	cHeaderPriority = []string{"c", "c++", "obj-c"}
-
-	generated = "automatically generated|generated automatically|generated by|a lexical scanner generated by flex|this is a generated file|generated with the.*utility|do not edit|do not hand-hack"
-
-}
-
-// Generic machinery for walking source text to count lines
-
-const stateNORMAL = 0        // in running text
-const stateINSTRING = 1      // in single-line string
-const stateINMULTISTRING = 2 // in multi-line string
-const stateINCOMMENT = 3     // in comment
-
-// countContext is state corresoding to a single source file
-type countContext struct {
-	line             []byte
-	lineNumber       uint
-	nonblank         bool // Is current line nonblank?
-	lexfile          bool // Do we see lex directives?
-	wasNewline       bool // Was the last character seen a newline?
-	underlyingStream *os.File
-	rc               *bufio.Reader
-}
-
-func (ctx *countContext) setup(path string) bool {
-	var err error
-	ctx.underlyingStream, err = os.Open(path)
-	if err != nil {
-		log.Println(err)
-		return false
+func newRunStatistics() *runStatistics {
+	return &runStatistics{
+		began:    time.Now(),
+		skipped:  make(map[string]uint),
+		scantime: make(map[string]time.Duration),
 	}
-	ctx.rc = bufio.NewReader(ctx.underlyingStream)
-	ctx.lineNumber = 1
-	return true
 }
 
-func (ctx *countContext) teardown() {
-	ctx.underlyingStream.Close()
+func (r *runStatistics) skip(reason string) {
+	r.lock.Lock()
+	r.skipped[reason]++
+	r.lock.Unlock()
 }
 
-// consume - conditionally consume an expected byte sequence
-func (ctx *countContext) consume(expect []byte) bool {
-	if debug > 1 {
-		fmt.Fprintf(os.Stderr, "consume: %q\n", expect)
-	}
-	if len(expect) == 0 {
-		return true
-	}
-	s, err := ctx.rc.Peek(len(expect))
-	if debug > 1 {
-		fmt.Fprintf(os.Stderr, "consume saw: %q\n", s)
+func (r *runStatistics) scanned(results []counter.SourceStat, info fs.FileInfo, elapsed time.Duration) {
+	language := "unclassified"
+	for _, st := range results {
+		if st.SLOC > 0 {
+			language = st.Language
+		}
 	}
-	if err == nil && bytes.Equal(s, expect) {
-		ctx.rc.Discard(len(expect))
-		return true
+	r.lock.Lock()
+	r.files++
+	if info != nil {
+		r.bytes += info.Size()
 	}
-	return false
+	r.scantime[language] += elapsed
+	r.lock.Unlock()
 }
 
-func (ctx *countContext) ispeek(c byte) bool {
-	if s, err := ctx.rc.Peek(1); err == nil && s[0] == c {
-		return true
+// report - ship the statistics to stderr
+func (r *runStatistics) report() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	elapsed := time.Since(r.began)
+	fmt.Fprintf(os.Stderr, "Elapsed time: %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(os.Stderr, "Files scanned: %d (%.1f per second)\n",
+		r.files, float64(r.files)/elapsed.Seconds())
+	fmt.Fprintf(os.Stderr, "Bytes read: %d\n", r.bytes)
+	var reasons []string
+	var total uint
+	for reason, n := range r.skipped {
+		reasons = append(reasons, reason)
+		total += n
+	}
+	sort.Strings(reasons)
+	fmt.Fprintf(os.Stderr, "Files skipped: %d\n", total)
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "  %-20s %d\n", reason, r.skipped[reason])
+	}
+	var languages []string
+	for language := range r.scantime {
+		languages = append(languages, language)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		return r.scantime[languages[i]] > r.scantime[languages[j]]
+	})
+	fmt.Fprintf(os.Stderr, "Scan time by language:\n")
+	for _, language := range languages {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", language, r.scantime[language].Round(time.Microsecond))
 	}
-	return false
 }
 
-// getachar - Get one character, tracking line number
-func (ctx *countContext) getachar() (byte, error) {
-	c, err := ctx.rc.ReadByte()
-	if err != nil && err != io.EOF {
-		panic("error while reading a character")
-	}
-	if ctx.wasNewline {
-		ctx.lineNumber++
-	}
-	if c == '\n' {
-		ctx.wasNewline = true
-	} else {
-		ctx.wasNewline = false
-	}
-	return c, err
+// Progress reporting for long runs.  Counters are bumped by the walker
+// goroutines and a ticker redraws a status line on stderr.
+
+type progressMeter struct {
+	lock  sync.Mutex
+	files uint
+	bytes int64
+	dir   string
+	done  chan bool
 }
 
-// Consume the remainder of a line, updating the line counter
-func (ctx *countContext) munchline() bool {
-	line, err := ctx.rc.ReadBytes('\n')
-	if err == nil {
-		ctx.lineNumber++
-		ctx.line = line
-		return true
-	} else if err == io.EOF {
-		return false
-	} else {
-		panic(err)
+var progress *progressMeter
+
+func (p *progressMeter) note(path string, info fs.FileInfo) {
+	p.lock.Lock()
+	p.files++
+	if info != nil {
+		p.bytes += info.Size()
 	}
+	p.dir = filepath.Dir(path)
+	p.lock.Unlock()
 }
 
-// Consume the remainder of a line, updating the line counter
-func (ctx *countContext) drop(excise string) bool {
-	cre, err := regexp.Compile(excise)
-	if err != nil {
-		panic(fmt.Sprintf("unexpected failure %s while compiling %s", err, excise))
+func (p *progressMeter) show() {
+	p.lock.Lock()
+	line := fmt.Sprintf("%d files, %.1f MB, in %s", p.files, float64(p.bytes)/(1<<20), p.dir)
+	p.lock.Unlock()
+	if len(line) > 78 {
+		line = line[:75] + "..."
 	}
-	return cre.ReplaceAllLiteral(ctx.line, []byte("")) != nil
+	fmt.Fprintf(os.Stderr, "\r%-78s", line)
 }
 
-// matchline - does a given regexp match the last line read?
-func (ctx *countContext) matchline(re string) bool {
-	cre, err := regexp.Compile(re)
-	if err != nil {
-		panic(fmt.Sprintf("unexpected failure %s while compiling %s", err, re))
-	}
-	return cre.Find(ctx.line) != nil
+// start - redraw the status line every half second until stopped
+func (p *progressMeter) start() {
+	p.done = make(chan bool)
+	go func() {
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.show()
+			case <-p.done:
+				p.show()
+				fmt.Fprintln(os.Stderr)
+				p.done <- true
+				return
+			}
+		}
+	}()
 }
 
-func isspace(c byte) bool {
-	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f'
+func (p *progressMeter) stop() {
+	p.done <- true
+	<-p.done
 }
 
-// Verifier functions for checking that files with disputed extensions
-// are actually of the types we think they are.
+// optionSalt - fingerprint the version and the options that change how
+// files count, including the contents of a --langdefs file
+func optionSalt() string {
+	harmless := map[string]bool{
+		"cache": true, "cache-dir": true, "d": true, "jobs": true,
+		"max-open": true, "progress": true, "stats": true, "j": true,
+		"json": true, "json-lines": true, "q": true, "pretty": true, "si": true, "timeout": true,
+		"cpuprofile": true, "memprofile": true, "audit": true,
+		"review": true, "github-summary": true, "min-sloc": true,
+		"min-percent": true, "linguist-names": true, "count-hardlinks": true,
+	}
+	salt := version
+	flag.Visit(func(f *flag.Flag) {
+		if !harmless[f.Name] {
+			salt += "\x00" + f.Name + "=" + f.Value.String()
+		}
+	})
+	if f := flag.Lookup("langdefs"); f != nil && f.Value.String() != "" {
+		if text, err := ioutil.ReadFile(f.Value.String()); err == nil {
+			salt += fmt.Sprintf("\x00langdefs:%x", sha256.Sum256(text))
+		}
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(salt)))
+}
 
-// reallyObjectiveC - returns true if filename contents really are objective-C.
-func reallyObjectiveC(ctx *countContext, path string) bool {
-	special := false // Did we find a special Objective-C pattern?
-	isObjC := false  // Value to determine.
-	braceLines := 0  // Lines that begin/end with curly braces.
-	plusMinus := 0   // Lines that begin with + or -.
-	wordMain := 0    // Did we find "main("?
+// Human-friendly number formatting for --pretty and --si.  The
+// thousands separator follows the LC_ALL, LC_NUMERIC, or LANG locale.
 
-	ctx.setup(path)
-	defer ctx.teardown()
+var pretty bool
+var siSuffixes bool
 
-	for ctx.munchline() {
-		if ctx.matchline("^\\s*[{}]") || ctx.matchline("[{}];?\\s*") {
-			braceLines++
-		}
-		if ctx.matchline("^\\s*[+-]") {
-			plusMinus++
-		}
-		if ctx.matchline("\\bmain\\s*\\(") { // "main" followed by "("?
-			wordMain++
-		}
-		// Handle /usr/src/redhat/BUILD/egcs-1.1.2/gcc/objc/linking.m:
-		if ctx.matchline("(?i)^\\s*\\[object name\\];\\s*") {
-			special = true
-		}
+var localeSeparators = map[string]string{
+	"de": ".", "es": ".", "it": ".", "nl": ".", "pt": ".", "da": ".",
+	"id": ".", "tr": ".", "el": ".",
+	"fr": " ", "ru": " ", "pl": " ", "cs": " ", "sk": " ", "sv": " ",
+	"fi": " ", "nb": " ", "uk": " ", "hu": " ",
+	"de_CH": "'",
+}
 
-		if (braceLines > 1) && ((plusMinus > 1) || wordMain > 0 || special) {
-			isObjC = true
+// thousandsSeparator - choose a digit-group separator for the locale
+func thousandsSeparator() string {
+	locale := ""
+	for _, name := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if locale = os.Getenv(name); locale != "" {
+			break
 		}
-
 	}
-
-	if debug > 0 {
-		fmt.Fprintf(os.Stderr, "objc verifier returned %t on %s\n", isObjC, path)
+	if i := strings.IndexAny(locale, ".@"); i > -1 {
+		locale = locale[:i]
 	}
-
-	return isObjC
+	if sep, ok := localeSeparators[locale]; ok {
+		return sep
+	}
+	if len(locale) >= 2 {
+		if sep, ok := localeSeparators[locale[:2]]; ok {
+			return sep
+		}
+	}
+	return ","
 }
 
-func hasKeywords(ctx *countContext, path string, lang string, tells []string) bool {
-	matching := false // Value to determine.
-
-	ctx.setup(path)
-	defer ctx.teardown()
-
-	for ctx.munchline() {
-		for i := range tells {
-			if ctx.matchline(tells[i]) {
-				matching = true
+// formatCount - render a count with digit grouping or an SI suffix
+func formatCount(n uint) string {
+	if siSuffixes && n >= 1000 {
+		value := float64(n)
+		suffix := ""
+		for _, s := range []string{"k", "M", "G", "T"} {
+			if value < 1000 {
 				break
 			}
+			value /= 1000
+			suffix = s
 		}
+		return strconv.FormatFloat(value, 'f', 1, 64) + suffix
 	}
-
-	if debug > 0 {
-		fmt.Fprintf(os.Stderr, "%s verifier returned %t on %s\n",
-			lang, matching, path)
+	digits := strconv.FormatUint(uint64(n), 10)
+	if !pretty || len(digits) <= 3 {
+		return digits
 	}
-
-	return matching
-}
-
-// reallyOccam - returns TRUE if filename contents really are occam.
-func reallyOccam(ctx *countContext, path string) bool {
-	return hasKeywords(ctx, path, "occam", []string{"--", "PROC"})
+	sep := thousandsSeparator()
+	var out strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			out.WriteString(sep)
+		}
+		out.WriteRune(c)
+	}
+	return out.String()
 }
 
-// reallyLex - returns TRUE if filename contents really are lex.
-func reallyLex(ctx *countContext, path string) bool {
-	return hasKeywords(ctx, path, "lex", []string{"%{", "%%", "%}"})
+type countRecord struct {
+	language   string
+	slinecount uint
+	llinecount uint
+	filecount  uint
 }
 
-// reallyPOP11 - returns TRUE if filename contents really are pop11.
-func reallyPOP11(ctx *countContext, path string) bool {
-	return hasKeywords(ctx, path, "pop11", []string{"define", "printf"})
-}
+// CI thresholds.  A --fail-if condition compares a metric of the run
+// against a number; delta_ metrics are measured against the totals in
+// a JSON report from an earlier run named with --compare.
 
-// reallySather - returns TRUE if filename contents really are sather.
-func reallySather(ctx *countContext, path string) bool {
-	return hasKeywords(ctx, path, "sather", []string{"class"})
+type condition struct {
+	text   string
+	metric string
+	op     string
+	value  float64
 }
 
-// reallyMatlab - returns TRUE if filename contents really are MATLAB.
-// We have to disambiguate against MUMPS and Objective-C
-func reallyMatlab(ctx *countContext, path string) bool {
-	return hasKeywords(ctx, path, "matlab", []string{"end"})
+var conditionMetrics = map[string]bool{
+	"sloc": true, "lloc": true, "files": true,
+	"delta_sloc": true, "delta_lloc": true, "delta_files": true,
 }
 
-// reallyProlog - returns TRUE if filename contents really are prolog.
-// Without this check, Perl files will be falsely identified.
-func reallyProlog(ctx *countContext, path string) bool {
-	ctx.setup(path)
-	defer ctx.teardown()
-
-	for ctx.munchline() {
-		if bytes.HasPrefix(ctx.line, []byte("#")) {
-			return false
-		} else if ctx.matchline("\\$[[:alpha]]") {
-			return false
+// parseCondition - parse a "metric op number" expression
+func parseCondition(text string) (condition, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 1 {
+		// Allow the condition to be written without spaces
+		re := regexp.MustCompile(`^([a-z_]+)(<=|>=|==|!=|<|>)(-?[0-9.]+)$`)
+		if m := re.FindStringSubmatch(text); m != nil {
+			fields = m[1:]
 		}
 	}
-
-	return true
-}
-
-// reallyExpect - filename, returns true if its contents really are Expect.
-//
-// dwheeler had this to say:
-//
-// Many "exp" files (such as in Apache and Mesa) are just "export" data,
-// summarizing something else (e.g., its interface).
-// Sometimes (like in RPM) it's just misc. data.
-// Thus, we need to look at the file to determine
-// if it's really an "expect" file.
-// The heuristic is as follows: it's Expect _IF_ it:
-// 1. has "load_lib" command and either "#" comments or {}.
-// 2. {, }, and one of: proc, if, [...], expect
-func reallyExpect(ctx *countContext, path string) bool {
-	var isExpect = false // Value to determine.
-
-	var beginBrace bool // Lines that begin with curly braces.
-	var endBrace bool   // Lines that begin with curly braces.
-	var loadLib bool    // Lines with the LoadLib command.
-	var foundProc bool
-	var foundIf bool
-	var foundBrackets bool
-	var foundExpect bool
-	var foundPound bool
-
-	ctx.setup(path)
-	defer ctx.teardown()
-
-	for ctx.munchline() {
-		if ctx.matchline("#") {
-			foundPound = true
-			// Delete trailing comments
-			i := bytes.Index(ctx.line, []byte("#"))
-			if i > -1 {
-				ctx.line = ctx.line[:i]
-			}
-		}
-
-		if ctx.matchline("^\\s*\\{") {
-			beginBrace = true
-		}
-		if ctx.matchline("\\{\\s*$") {
-			beginBrace = true
-		}
-		if ctx.matchline("^\\s*}") {
-			endBrace = true
-		}
-		if ctx.matchline("};?\\s*$") {
-			endBrace = true
-		}
-		if ctx.matchline("^\\s*loadLib\\s+\\S") {
-			loadLib = true
-		}
-		if ctx.matchline("^\\s*proc\\s") {
-			foundProc = true
-		}
-		if ctx.matchline("^\\s*if\\s") {
-			foundIf = true
-		}
-		if ctx.matchline("\\[.*\\]") {
-			foundBrackets = true
-		}
-		if ctx.matchline("^\\s*expect\\s") {
-			foundExpect = true
-		}
-	}
-
-	if loadLib && (foundPound || (beginBrace && endBrace)) {
-		isExpect = true
-	}
-	if beginBrace && endBrace &&
-		(foundProc || foundIf || foundBrackets || foundExpect) {
-		isExpect = true
-	}
-
-	if debug > 0 {
-		fmt.Fprintf(os.Stderr, "expect verifier returned %t on %s\n", isExpect, path)
-	}
-
-	return isExpect
-}
-
-// reallyPascal - returns  true if filename contents really are Pascal.
-func reallyPascal(ctx *countContext, path string) bool {
-	//
-	// dwheeler had this to say:
-	//
-	// This isn't as obvious as it seems.
-	// Many ".p" files are Perl files
-	// (such as /usr/src/redhat/BUILD/ispell-3.1/dicts/czech/glob.p),
-	// others are C extractions
-	// (such as /usr/src/redhat/BUILD/linux/include/linux/umsdos_fs.p
-	// and some files in linuxconf).
-	// However, test files in "p2c" really are Pascal, for example.
-	//
-	// Note that /usr/src/redhat/BUILD/ucd-snmp-4.1.1/ov/bitmaps/UCD.20.p
-	// is actually C code.  The heuristics determine that they're not Pascal,
-	// but because it ends in ".p" it's not counted as C code either.
-	// I believe this is actually correct behavior, because frankly it
-	// looks like it's automatically generated (it's a bitmap expressed as code).
-	// Rather than guess otherwise, we don't include it in a list of
-	// source files.  Let's face it, someone who creates C files ending in ".p"
-	// and expects them to be counted by default as C files in SLOCCount needs
-	// their head examined.  I suggest examining their head
-	// with a sucker rod (see syslogd(8) for more on sucker rods).
-	//
-	// This heuristic counts as Pascal such files such as:
-	//  /usr/src/redhat/BUILD/teTeX-1.0/texk/web2c/tangleboot.p
-	// Which is hand-generated.  We don't count woven documents now anyway,
-	// so this is justifiable.
-
-	// The heuristic is as follows: it's Pascal _IF_ it has all of the following
-	// (ignoring {...} and (*...*) comments):
-	// 1. "^..program NAME" or "^..unit NAME",
-	// 2. "procedure", "function", "^..interface", or "^..implementation",
-	// 3. a "begin", and
-	// 4. it ends with "end.",
-	//
-	// Or it has all of the following:
-	// 1. "^..module NAME" and
-	// 2. it ends with "end.".
-	//
-	// Or it has all of the following:
-	// 1. "^..program NAME",
-	// 2. a "begin", and
-	// 3. it ends with "end.".
-	//
-	// The "end." requirements in particular filter out non-Pascal.
-	//
-	// Note (jgb): this does not detect Pascal main files in fpc, like
-	// fpc-1.0.4/api/test/testterminfo.pas, which does not have "program" in
-	// it
-	var isPascal bool // Value to determine.
-
-	var hasProgram bool
-	var hasUnit bool
-	var hasModule bool
-	var hasProcedureOrFunction bool
-	var hasBegin bool
-	var foundTerminatingEnd bool
-
-	ctx.setup(path)
-	defer ctx.teardown()
-
-	for ctx.munchline() {
-		// Ignore {...} comments on this line; imperfect, but effective.
-		ctx.drop("\\{.*?\\}")
-		// Ignore (*...*) comments on this line; imperfect but effective.
-		ctx.drop("\\(\\*.*\\*\\)")
-
-		if ctx.matchline("(?i)\\bprogram\\s+[A-Za-z]") {
-			hasProgram = true
-		}
-		if ctx.matchline("(?i)\\bunit\\s+[A-Za-z]") {
-			hasUnit = true
-		}
-		if ctx.matchline("(?i)\\bmodule\\s+[A-Za-z]") {
-			hasModule = true
-		}
-		if ctx.matchline("(?i)\\bprocedure\\b") {
-			hasProcedureOrFunction = true
-		}
-		if ctx.matchline("(?i)\\bfunction\\b") {
-			hasProcedureOrFunction = true
-		}
-		if ctx.matchline("(?i)^\\s*interface\\s+") {
-			hasProcedureOrFunction = true
-		}
-		if ctx.matchline("(?i)^\\s*implementation\\s+") {
-			hasProcedureOrFunction = true
-		}
-		if ctx.matchline("(?i)\\bbegin\\b") {
-			hasBegin = true
-		}
-		// Originally dw said: "This heuristic fails if there
-		// are multi-line comments after "end."; I haven't
-		// seen that in real Pascal programs:"
-		// But jgb found there are a good quantity of them in
-		// Debian, specially in fpc (at the end of a lot of
-		// files there is a multiline comment with the
-		// changelog for the file).  Therefore, assume Pascal
-		// if "end." appears anywhere in the file.
-		if ctx.matchline("(?i)end\\.\\s*$") {
-			foundTerminatingEnd = true
-		}
-	}
-
-	// Okay, we've examined the entire file looking for clues;
-	// let's use those clues to determine if it's really Pascal:
-	isPascal = (((hasUnit || hasProgram) && hasProcedureOrFunction &&
-		hasBegin && foundTerminatingEnd) ||
-		(hasModule && foundTerminatingEnd) ||
-		(hasProgram && hasBegin && foundTerminatingEnd))
-
-	if debug > 0 {
-		fmt.Fprintf(os.Stderr, "pascal verifier returned %t on %s\n", isPascal, path)
-	}
-
-	return isPascal
-}
-
-func wasGeneratedAutomatically(ctx *countContext, path string, eolcomment string) bool {
-	// Determine if the file was generated automatically.
-	// Use a simple heuristic: check if first few lines have phrases like
-	// "generated automatically", "automatically generated", "Generated by",
-	// or "do not edit" as the first
-	// words in the line (after possible comment markers and spaces).
-	i := 15 // Look at first 15 lines.
-	ctx.setup(path)
-	defer ctx.teardown()
-
-	// Avoid blowing up if the comment leader is "*" (as in COBOL).
-	if eolcomment == "*" {
-		eolcomment = ""
-	} else {
-		eolcomment = "|" + eolcomment
+	if len(fields) != 3 {
+		return condition{}, fmt.Errorf("--fail-if wants 'metric op number', not %q", text)
 	}
-	re := "(\\*" + eolcomment + ").*(?i:" + generated + ")"
-	cre, err := regexp.Compile(re)
-	if err != nil {
-		panic(fmt.Sprintf("unexpected failure while building %s", re))
+	cond := condition{text: text, metric: fields[0], op: fields[1]}
+	if !conditionMetrics[cond.metric] {
+		return cond, fmt.Errorf("--fail-if: unknown metric %s", cond.metric)
 	}
-
-	for ctx.munchline() && i > 0 {
-		//fmt.Fprintf(os.Stderr, "Matching %s against %s", ctx.line, re)
-		if cre.Find(ctx.line) != nil {
-			if debug > 0 {
-				fmt.Fprintf(os.Stderr, "%s: is generated\n", path)
-			}
-			return true
-		}
-		i--
+	switch cond.op {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return cond, fmt.Errorf("--fail-if: unknown comparison %s", cond.op)
 	}
-
-	return false
-}
-
-// hashbang - hunt for a specified string in the first line of an executable
-func hashbang(ctx *countContext, path string, langname string) bool {
-	fi, err := os.Stat(path)
-	// If it's not executable by somebody, don't read for hashbang
-	if err != nil || (fi.Mode()&01111) == 0 {
-		return false
+	value, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return cond, fmt.Errorf("--fail-if: bad number %s", fields[2])
 	}
-	ctx.setup(path)
-	defer ctx.teardown()
-	s, err := ctx.rc.ReadString('\n')
-	return err == nil && strings.HasPrefix(s, "#!") && strings.Contains(s, langname)
+	cond.value = value
+	return cond, nil
 }
 
-// cFamilyCounter - Count the SLOC in a C-family source file
-//
-// C++ headers get counted as C. This can only be fixed in postprocessing
-// by noticing that there are no files with a C extension in the tree.
-//
-// Another minor issue is that it's possible for the antecedents in Lex rules
-// to look like C comment starts. In theory we could fix this by requiring Lex
-// files to contain %%.
-func cFamilyCounter(ctx *countContext, path string, syntax genericLanguage) []SourceStat {
-	/* Types of comments: */
-	const commentBLOCK = 0
-	const commentTRAILING = 1
+// holds - is the condition true of these totals?
+func (cond condition) holds(totals countRecord, baseline *countRecord) bool {
+	var v float64
+	switch cond.metric {
+	case "sloc":
+		v = float64(totals.slinecount)
+	case "lloc":
+		v = float64(totals.llinecount)
+	case "files":
+		v = float64(totals.filecount)
+	case "delta_sloc":
+		v = float64(totals.slinecount) - float64(baseline.slinecount)
+	case "delta_lloc":
+		v = float64(totals.llinecount) - float64(baseline.llinecount)
+	case "delta_files":
+		v = float64(totals.filecount) - float64(baseline.filecount)
+	}
+	switch cond.op {
+	case "<":
+		return v < cond.value
+	case "<=":
+		return v <= cond.value
+	case ">":
+		return v > cond.value
+	case ">=":
+		return v >= cond.value
+	case "==":
+		return v == cond.value
+	}
+	return v != cond.value
+}
 
-	var stats SourceStat
-	mode := stateNORMAL /* stateNORMAL, stateINSTRING, stateINMULTISTRING, or stateINCOMMENT */
-	var commentType int /* commentBLOCK or commentTRAILING */
-	var startline uint
+type baselineRecord struct {
+	Language  *string `json:"language"`
+	SLOC      uint    `json:"sloc"`
+	LLOC      uint    `json:"lloc"`
+	Filecount uint    `json:"filecount"`
+}
 
-	if syntax.verifier != nil && !syntax.verifier(ctx, path) {
-		return []SourceStat{stats}
+// loadBaseline - total up the language records of a -j report, and
+// return them by language as well.  The report may be the document of
+// -j or the record per line of --json-lines.
+func loadBaseline(path string) (*countRecord, map[string]countRecord, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
 	}
+	defer fp.Close()
 
-	ctx.setup(path)
-	defer ctx.teardown()
-
-	stats.Path = path
-	stats.Language = syntax.name
-
-	// # at start of file - assume it's a cpp directive
-	if syntax.property(cpp) && ctx.consume([]byte("#")) {
-		stats.LLOC++
-	}
+	var baseline countRecord
+	languages := map[string]countRecord{}
+	decoder := encjson.NewDecoder(fp)
 	for {
-		c, err := ctx.getachar()
-		if err == io.EOF {
+		var value struct {
+			baselineRecord
+			Languages []baselineRecord `json:"languages"`
+			Sections  []baselineRecord `json:"sections"`
+		}
+		if err := decoder.Decode(&value); err == io.EOF {
 			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
 		}
+		records := append(append([]baselineRecord{value.baselineRecord}, value.Languages...), value.Sections...)
+		for _, record := range records {
+			if record.Language == nil || *record.Language == "all" || notCode[*record.Language] {
+				continue
+			}
+			baseline.slinecount += record.SLOC
+			baseline.llinecount += record.LLOC
+			baseline.filecount += record.Filecount
+			var tmp = languages[*record.Language]
+			tmp.language = *record.Language
+			tmp.slinecount += record.SLOC
+			tmp.llinecount += record.LLOC
+			tmp.filecount += record.Filecount
+			languages[*record.Language] = tmp
+		}
+	}
+	return &baseline, languages, nil
+}
 
-		if debug > 1 {
-			fmt.Fprintf(os.Stderr, "cFamilyCounter: top of loop %c\n", c)
-		}
+// Tree comparison.  "loccount diff A B" counts both trees and matches
+// their files by path within the tree.  A file that is only in one
+// tree, or whose language differs, counts as added or removed; one in
+// both as changed.
+
+type diffRecord struct {
+	language string
+	sloc     uint // SLOC, LLOC and files in the second tree
+	lloc     uint
+	files    uint
+	added    int // SLOC of files only in the second tree
+	removed  int // SLOC of files only in the first
+	changed  int // change in SLOC of files in both
+	dlloc    int
+	dfiles   int
+}
 
-		if mode == stateNORMAL {
-			if !ctx.lexfile && c == '"' {
-				ctx.nonblank = true
-				mode = stateINSTRING
-				startline = ctx.lineNumber
-			} else if syntax.property(cbs) && !ctx.lexfile && c == '\'' {
-				/* Consume single-character 'xxxx' values */
-				ctx.nonblank = true
-				c, err = ctx.getachar()
-				if c == '\\' {
-					c, err = ctx.getachar()
-				}
-				for {
-					c, err = ctx.getachar()
-					if (c == '\'') || (c == '\n') || (err == io.EOF) {
-						break
-					}
-				}
-			} else if (c == syntax.commentleader[0]) && (ctx.ispeek(syntax.commentleader[1])) {
-				c, err = ctx.getachar()
-				mode = stateINCOMMENT
-				commentType = commentBLOCK
-				startline = ctx.lineNumber
-			} else if ((syntax.eolcomment != "") && c == syntax.eolcomment[0] && (len(syntax.eolcomment) == 1 || ctx.consume([]byte(syntax.eolcomment[1:])))) ||(syntax.property(asm) && strings.IndexByte(assemblerLeaders, c) > -1) {
-				if debug > 1 {
-					fmt.Fprintf(os.Stderr, "cFamilyCounter: saw winged-comment leader %s\n", syntax.eolcomment)
-				}
-				c, _ = ctx.getachar()
-				mode = stateINCOMMENT
-				commentType = commentTRAILING
-				startline = ctx.lineNumber
-			} else if (syntax.multistring != "") && (c == syntax.multistring[0]) {
-				mode = stateINMULTISTRING
-				startline = ctx.lineNumber
-			} else if syntax.property(gotick) && c == '`' {
-				startLine := ctx.lineNumber
-				for {
-					c, err = ctx.getachar()
-					if err != nil {
-						fmt.Fprintf(os.Stderr, "WARNING - unterminated backtick, line %d, file %s\n", startLine, path)
-					}
-					if c == '`' {
-						break
-					}
-				}
-			} else if !isspace(c) {
-				ctx.nonblank = true
-			}
-		} else if mode == stateINSTRING {
-			// We only count string lines with non-whitespace --
-			// this is to gracefully handle syntactically invalid
-			// programs.  You could argue that multiline strings
-			// with whitespace are still executable and should be
-			// counted.
-			if !isspace(c) {
-				ctx.nonblank = true
-			}
-			if c == '"' {
-				mode = stateNORMAL
-			} else if syntax.property(cbs) && (c == '\\') && (ctx.ispeek('"') || ctx.ispeek('\\')) {
-				c, _ = ctx.getachar()
-			} else if syntax.property(cbs) && (c == '\\') && ctx.ispeek('\n') {
-				c, _ = ctx.getachar()
-			} else if c == '\n' {
-				// We found a bare newline in a string without
-				// preceding backslash.
-				if syntax.property(eolwarn) {
-					fmt.Fprintf(os.Stderr, "WARNING - newline in string, line %d, file %s\n", ctx.lineNumber, path)
-				}
+// diffJSONRecord is a diffRecord as -j writes it
+type diffJSONRecord struct {
+	Language    string `json:"language"`
+	SLOC        uint   `json:"sloc"`
+	LLOC        uint   `json:"lloc"`
+	Filecount   uint   `json:"filecount"`
+	DeltaSLOC   int    `json:"delta_sloc"`
+	AddedSLOC   int    `json:"added_sloc"`
+	RemovedSLOC int    `json:"removed_sloc"`
+	ChangedSLOC int    `json:"changed_sloc"`
+	DeltaLLOC   int    `json:"delta_lloc"`
+	DeltaFiles  int    `json:"delta_files"`
+}
 
-				// We COULD warn & reset mode to
-				// "Normal", but lots of code does this,
-				// so we'll just depend on the warning
-				// for ending the program in a string to
-				// catch syntactically erroneous
-				// programs.
-			}
-		} else if mode == stateINMULTISTRING {
-			// We only count multi-string lines with non-whitespace.
-			if !isspace(c) {
-				ctx.nonblank = true
-			}
-			if c == syntax.multistring[0] {
-				mode = stateNORMAL
-			}
-		} else { /* stateINCOMMENT mode */
-			if (c == '\n') && (commentType == commentTRAILING) {
-				mode = stateNORMAL
-			}
-			if (commentType == commentBLOCK) && (c == syntax.commenttrailer[0]) && ctx.ispeek(syntax.commenttrailer[1]) {
-				c, _ = ctx.getachar()
-				mode = stateNORMAL
-			}
-		}
-		if c == '\n' {
-			if ctx.nonblank {
-				stats.SLOC++
-			}
-			ctx.nonblank = false
-			if ctx.consume([]byte("%")) {
-				ctx.lexfile = true
-				ctx.nonblank = true
-			}
-			// # at start of line - assume it's a cpp directive
-			if syntax.property(cpp) && ctx.consume([]byte("#")) {
-				stats.LLOC++
-				if debug > 1 {
-					fmt.Fprintf(os.Stderr, "cFamilyCounter: cpp lloc++\n")
-				}
-			}
-		}
-		if mode == stateNORMAL && len(syntax.terminator) > 0 && c == syntax.terminator[0] {
-			stats.LLOC++
-			if debug > 1 {
-				fmt.Fprintf(os.Stderr, "cFamilyCounter: eol lloc++\n")
-			}
+// countTreeFiles - count a tree, read from source or, if that is nil,
+// from the file system, keying results by path and language
+func countTreeFiles(ctx context.Context, root string, source fs.FS) map[string]counter.SourceStat {
+	files := make(map[string]counter.SourceStat)
+	o := opts
+	o.Source = source
+	for st := range counter.StreamPaths(ctx, []string{root}, o.Jobs, o) {
+		if st.SLOC > 0 {
+			files[st.Path+"\x00"+st.Language] = st
 		}
 	}
-	/* We're done with the file.  Handle EOF-without-EOL. */
-	if ctx.nonblank {
-		stats.SLOC++
-	}
-	ctx.nonblank = false
-	if (mode == stateINCOMMENT) && (commentType == commentTRAILING) {
-		mode = stateNORMAL
-	}
-
-	if mode == stateINCOMMENT {
-		fmt.Fprintf(os.Stderr, "%q, line %d: ERROR - terminated in comment beginning here\n",
-			path, startline)
-	} else if mode == stateINSTRING {
-		fmt.Fprintf(os.Stderr, "%q, line %d: ERROR - terminated in string beginning here\n",
-			path, startline)
-	}
-
-	return []SourceStat{stats}
+	return files
 }
 
-// genericCounter - count SLOC in a generic language.
-func genericCounter(ctx *countContext, path string,
-	syntax genericLanguage) SourceStat {
-	var stats SourceStat
-	
-	if syntax.verifier != nil && !syntax.verifier(ctx, path) {
-		return stats
+// revisionRange - split a git revision range rev1..rev2; rev2 may be
+// left out for HEAD
+func revisionRange(option string, text string) ([]string, error) {
+	revs := strings.SplitN(text, "..", 2)
+	if len(revs) != 2 || revs[0] == "" {
+		return nil, fmt.Errorf("%s needs a range rev1..rev2", option)
 	}
+	if revs[1] == "" {
+		revs[1] = "HEAD"
+	}
+	return revs, nil
+}
 
-	ctx.setup(path)
-	defer ctx.teardown()
-
-	stats.Path = path
-	stats.Language = syntax.name
-
-	for ctx.munchline() {
-		i := bytes.Index(ctx.line, []byte(syntax.eolcomment))
-		if i > -1 {
-			ctx.line = ctx.line[:i]
-		}
-		ctx.line = bytes.Trim(ctx.line, " \t\r\n")
-		if len(ctx.line) > 0 {
-			stats.SLOC++
-			if len(syntax.terminator) > 0 && strings.Contains(string(ctx.line), syntax.terminator) {
-				stats.LLOC++
-			}
-		}
+// gitTreeFiles - count a revision of a git repository
+func gitTreeFiles(ctx context.Context, repo string, rev string) (map[string]counter.SourceStat, error) {
+	src, err := counter.OpenRevision(repo, rev)
+	if err != nil {
+		return nil, err
 	}
+	defer src.Close()
+	return countTreeFiles(ctx, ".", src), nil
+}
 
-	return stats
+// fileDelta - how one file's counts changed between two trees
+type fileDelta struct {
+	st     counter.SourceStat
+	status string
+	dsloc  int
+	dlloc  int
 }
 
-func goCounter(path string) uint {
-	var lloc uint;
+// delta - net change in SLOC
+func (r *diffRecord) delta() int {
+	return r.added - r.removed + r.changed
+}
 
-	content, err1 := ioutil.ReadFile(path)
-	if err1 != nil {
-		return 0
+// diffCounts - report the differences in counts between two trees.
+// Conditions are judged on the second tree, their delta_ metrics
+// against the first; it returns true if any holds.
+func diffCounts(older map[string]counter.SourceStat, newer map[string]counter.SourceStat, individual bool, json bool, conditions []condition) bool {
+	var deltas []fileDelta
+	for key, st := range older {
+		if _, ok := newer[key]; !ok {
+			deltas = append(deltas, fileDelta{st, "removed", -int(st.SLOC), -int(st.LLOC)})
+		}
 	}
-
-	fset := token.NewFileSet() // positions are relative to fset
-	f, err2 := parser.ParseFile(fset, path, content, 0)
-	if err2 != nil {
-		return 0
+	for key, st := range newer {
+		if was, ok := older[key]; !ok {
+			deltas = append(deltas, fileDelta{st, "added", int(st.SLOC), int(st.LLOC)})
+		} else {
+			deltas = append(deltas, fileDelta{st, "changed", int(st.SLOC) - int(was.SLOC), int(st.LLOC) - int(was.LLOC)})
+		}
 	}
-
-	// Inspect the AST and print all identifiers and literals.
-	ast.Inspect(f, func(n ast.Node) bool {
-		switch n.(type) {
-		case *ast.AssignStmt:	// sssignment or short variable declaration
-			lloc++
-		case *ast.BranchStmt:	// break, continue, goto, or fallthrough
-			lloc++
-		case *ast.DeclStmt:	// declaration in a statement list.
-			lloc++
-		case *ast.DeferStmt:	// a defer statement.
-			lloc++
-		case *ast.ExprStmt:	// stand-alone expression in a statement list.
-			lloc++
-		case *ast.GenDecl:	// an import, constant, type or variable declaration
-			lloc++
-		case *ast.GoStmt:	// go xxxx 
-			lloc++
-		//case *ast.IfStmt:	// an if statement
-		//	lloc++
-		case *ast.ImportSpec:	// package import line
-			lloc++
-		case *ast.IncDecStmt:	// incement or decrement statement
-			lloc++
-		//case *ast.RangeStmt:	// for statement with a range clause.
-		//	lloc++
-		case *ast.ReturnStmt:	// a return statement.
-			lloc++
-		//case *ast.SelectStmt:	// a select statement.
-		//	lloc++
-		case *ast.SendStmt:	// a send statement.
-			lloc++
-		//case *ast.SwitchStmt:	// a switch statement.
-		//	lloc++
-		}
-		// Not counted: BlockStmt, FuncDecl
-		// Including IfStmt, RangeStmt, SelectStmt, SwitchStmt
-		// is probably a better complexity metric, but no longer
-		// strictly comparable with counting semis in C.
-		return true
+	sort.Slice(deltas, func(i, j int) bool {
+		if deltas[i].st.Path != deltas[j].st.Path {
+			return deltas[i].st.Path < deltas[j].st.Path
+		}
+		return deltas[i].st.Language < deltas[j].st.Language
 	})
-	return lloc
-}
-
-func pythonCounter(ctx *countContext, path string) SourceStat {
-	var isintriple bool  // A triple-quote is in effect.
-	var isincomment bool // We are in a multiline (triple-quoted) comment.
-	var stats SourceStat
-
-	ctx.setup(path)
-	stats.Path = path
-	defer ctx.teardown()
-
-	tripleBoundary := func(line []byte) bool { return bytes.Contains(line, []byte(dt)) || bytes.Contains(line, []byte(st)) }
-	for ctx.munchline() {
-		// Delete trailing comments
-		i := bytes.Index(ctx.line, []byte("#"))
-		if i > -1 {
-			ctx.line = ctx.line[:i]
-		}
-
-		if !isintriple { // Normal case:
-			// Ignore triple-quotes that begin & end on the ctx.line.
-			ctx.line = dtriple.ReplaceAllLiteral(ctx.line, []byte(""))
-			ctx.line = striple.ReplaceAllLiteral(ctx.line, []byte(""))
-			// Delete lonely strings starting on BOL.
-			ctx.line = dlonely.ReplaceAllLiteral(ctx.line, []byte(""))
-			ctx.line = slonely.ReplaceAllLiteral(ctx.line, []byte(""))
-			// Delete trailing comments
-			i := bytes.Index(ctx.line, []byte("#"))
-			if i > -1 {
-				ctx.line = ctx.line[:i]
-			}
-			// Does multi-line triple-quote begin here?
-			if tripleBoundary(ctx.line) {
-				isintriple = true
-				ctx.line = bytes.Trim(ctx.line, " \t\r\n")
-				// It's a comment if at BOL.
-				if bytes.HasPrefix(ctx.line, []byte(dt)) || bytes.HasPrefix(ctx.line, []byte(st)) {
-					isincomment = true
-				}
+
+	totals := diffRecord{language: "all"}
+	records := map[string]*diffRecord{}
+	for _, d := range deltas {
+		for _, r := range []*diffRecord{records[d.st.Language], &totals} {
+			if r == nil {
+				r = &diffRecord{language: d.st.Language}
+				records[d.st.Language] = r
+			} else if r == &totals && notCode[d.st.Language] {
+				continue
 			}
-		} else { // we ARE in a triple.
-			if tripleBoundary(ctx.line) {
-				if isincomment {
-					// Delete text if it's a comment (not if data)
-					ctx.line = dtrailer.ReplaceAllLiteral(ctx.line, []byte(""))
-					ctx.line = strailer.ReplaceAllLiteral(ctx.line, []byte(""))
-				} else {
-					// Leave something there to count.
-					ctx.line = dtrailer.ReplaceAllLiteral(ctx.line, []byte("x"))
-					ctx.line = strailer.ReplaceAllLiteral(ctx.line, []byte("x"))
-				}
-				// But wait!  Another triple might
-				// start on this ctx.line!  (see
-				// Python-1.5.2/Tools/freeze/makefreeze.py
-				// for an example)
-				if tripleBoundary(ctx.line) {
-					// It did!  No change in state!
-				} else {
-					isintriple = false
-					isincomment = false
-				}
+			switch d.status {
+			case "added":
+				r.added += d.dsloc
+				r.dfiles++
+			case "removed":
+				r.removed -= d.dsloc
+				r.dfiles--
+			default:
+				r.changed += d.dsloc
 			}
-		}
-		ctx.line = bytes.Trim(ctx.line, " \t\r\n")
-		if !isincomment && len(ctx.line) > 0 {
-			stats.SLOC++
-			if ctx.line[len(ctx.line)-1] != '\\' {
-				stats.LLOC++
+			r.dlloc += d.dlloc
+			if d.status != "removed" {
+				r.sloc += d.st.SLOC
+				r.lloc += d.st.LLOC
+				r.files++
 			}
 		}
 	}
 
-	return stats
-}
-
-// perlCounter - count SLOC in Perl
-//
-// Physical lines of Perl are MUCH HARDER to count than you'd think.
-// Comments begin with "#".
-// Also, anything in a "perlpod" is a comment.
-// See perlpod(1) for more info; a perlpod starts with
-// \s*=command, can have more commands, and ends with \s*=cut.
-// Note that = followed by space is NOT a perlpod.
-// Although we ignore everything after __END__ in a file,
-// we will count everything after __DATA__; there's arguments for counting
-// and for not counting __DATA__.
-//
-// What's worse, "here" documents must be COUNTED AS CODE, even if
-// they're FORMATTED AS A PERLPOD.  Surely no one would do this, right?
-// Sigh... it can happen. See perl5.005_03/pod/splitpod.
-func perlCounter(ctx *countContext, path string) SourceStat {
-	var heredoc string
-	var isinpod bool
-	var stats SourceStat
-
-	ctx.setup(path)
-	stats.Path = path
-	defer ctx.teardown()
-
-	for ctx.munchline() {
-		// Delete trailing comments
-		i := bytes.Index(ctx.line, []byte("#"))
-		if i > -1 {
-			ctx.line = ctx.line[:i]
-		}
-
-		ctx.line = bytes.Trim(ctx.line, " \t\r\n")
-
-		if heredoc != "" && strings.HasPrefix(string(ctx.line), heredoc) {
-			heredoc = "" //finished here doc.
-		} else if i := bytes.Index(ctx.line, []byte("<<")); i > -1 {
-			// Beginning of a here document.
-			heredoc = string(bytes.Trim(ctx.line[i:], "< \t\"';,"))
-		} else if len(heredoc) == 0 && bytes.HasPrefix(ctx.line, []byte("=cut")) {
-			// Ending a POD?
-			if !isinpod {
-				fmt.Fprintf(os.Stderr, "%q, %d: cut without pod start\n",
-					path, ctx.lineNumber)
-			}
-			isinpod = false
-			continue // Don't count the cut command.
-		} else if len(heredoc) == 0 && podheader.Match(ctx.line) {
-			// Starting or continuing a POD?
-			// Perlpods can have multiple contents, so
-			// it's okay if isinpod == true.  Note that
-			// =(space) isn't a POD; library file
-			// perl5db.pl does this!
-			isinpod = true
-		} else if bytes.HasPrefix(ctx.line, []byte("__END__")) {
-			// Stop processing this file on __END__.
-			break
+	summary := []*diffRecord{&totals}
+	for _, r := range records {
+		if r.added != 0 || r.removed != 0 || r.changed != 0 || r.dlloc != 0 {
+			summary = append(summary, r)
 		}
-		if !isinpod && len(ctx.line) > 0 {
-			stats.SLOC++
-			if strings.Contains(string(ctx.line), ";") {
-				stats.LLOC++
-			}
+	}
+	abs := func(n int) int {
+		if n < 0 {
+			return -n
 		}
+		return n
 	}
+	sort.SliceStable(summary[1:], func(i, j int) bool {
+		a, b := summary[1+i], summary[1+j]
+		if abs(a.delta()) != abs(b.delta()) {
+			return abs(a.delta()) > abs(b.delta())
+		}
+		return a.language < b.language
+	})
 
-	return stats
-}
-
-// pascalCounter - Handle lanuages like Pascal and Modula 3
-func pascalCounter(ctx *countContext, path string, syntax pascalLike) SourceStat {
-	mode := stateNORMAL /* stateNORMAL, or stateINCOMMENT */
-	var stats SourceStat
-	var startline uint
-
-	if syntax.verifier != nil && !syntax.verifier(ctx, path) {
-		return stats
+	now := countRecord{"all", totals.sloc, totals.lloc, totals.files}
+	was := countRecord{"all",
+		uint(int(totals.sloc) - totals.delta()),
+		uint(int(totals.lloc) - totals.dlloc),
+		uint(int(totals.files) - totals.dfiles)}
+	var checks []checkResult
+	failed := false
+	for _, cond := range conditions {
+		checks = append(checks, checkResult{cond.text, cond.holds(now, &was)})
+		failed = failed || cond.holds(now, &was)
 	}
 
-	ctx.setup(path)
-	stats.Path = path
-	defer ctx.teardown()
-
-	for {
-		c, err := ctx.getachar()
-		if err == io.EOF {
-			break
+	var added []fileDelta
+	for _, d := range deltas {
+		if d.status == "added" && !notCode[d.st.Language] {
+			added = append(added, d)
 		}
+	}
+	sort.SliceStable(added, func(i, j int) bool { return added[i].dsloc > added[j].dsloc })
+	if len(added) > reviewNewFiles {
+		added = added[:reviewNewFiles]
+	}
 
-		if mode == stateNORMAL {
-			if syntax.bracketcomments && c == '{' {
-				mode = stateINCOMMENT
-			} else if (c == '(') && ctx.ispeek('*') {
-				c, _ = ctx.getachar()
-				mode = stateINCOMMENT
-			} else if !isspace(c) {
-				ctx.nonblank = true
-			} else if c == '\n' {
-				if ctx.nonblank {
-					stats.SLOC++
-				}
-				ctx.nonblank = false
+	switch {
+	case individual:
+		for _, d := range deltas {
+			if d.status != "changed" || d.dsloc != 0 || d.dlloc != 0 {
+				fmt.Printf("%s %s %s %+d %+d\n", d.st.Path, d.st.Label(), d.status, d.dsloc, d.dlloc)
 			}
-			if len(syntax.terminator) > 0 && c == syntax.terminator[0] {
-				stats.LLOC++
-			}
-		} else { /* stateINCOMMENT mode */
-			if syntax.bracketcomments && c == '}' {
-				mode = stateNORMAL
-			} else if (c == '*') && ctx.ispeek(')') {
-				_, _ = ctx.getachar()
-				mode = stateNORMAL
+		}
+	case reviewFormat != "":
+		writeReview(os.Stdout, reviewFormat, summary, added, checks)
+	default:
+		for _, r := range summary {
+			if json {
+				printJSON(diffJSONRecord{r.language, r.sloc, r.lloc, r.files,
+					r.delta(), r.added, r.removed, r.changed, r.dlloc, r.dfiles})
+			} else {
+				fmt.Printf("%-12s SLOC=%-7d (%+d: %d added, %d removed, %+d changed)\tLLOC=%-7d (%+d)\tin %d files (%+d)\n",
+					r.language, r.sloc,
+					r.delta(), r.added, r.removed, r.changed,
+					r.lloc, r.dlloc, r.files, r.dfiles)
 			}
 		}
 	}
-	/* We're done with the file.  Handle EOF-without-EOL. */
-	if ctx.nonblank {
-		stats.SLOC++
-	}
-	ctx.nonblank = false
 
-	if mode == stateINCOMMENT {
-		fmt.Fprintf(os.Stderr, "%q, line %d: ERROR - terminated in comment beginning here.\n",
-			path, startline)
-	} else if mode == stateINSTRING {
-		fmt.Fprintf(os.Stderr, "%q, line %d: ERROR - terminated in string beginning here.\n",
-			path, startline)
+	for _, check := range checks {
+		if check.failed {
+			fmt.Fprintf(os.Stderr, "loccount: failed condition %s\n", check.condition)
+		}
+	}
+	if githubSummary {
+		githubStep(func(w io.Writer) {
+			writeReview(w, "markdown", summary, added, checks)
+		}, checks)
 	}
+	return failed
+}
+
+// Review reports.  With --review, the diff modes write a summary meant
+// to be posted as a comment on a change under review: the SLOC added
+// and removed per language, the largest new files, and how each
+// --fail-if condition came out.
 
-	return stats
+var reviewFormat string
+var reviewNewFiles = 5
+
+type checkResult struct {
+	condition string
+	failed    bool
 }
 
-func fortranCounter(ctx *countContext, path string, syntax fortranLike) SourceStat {
-	var stats SourceStat
+// GitHub Actions.  With --github-summary, a run under Actions appends
+// its report in Markdown to the job summary, and raises a warning
+// annotation for each --fail-if condition that held.
 
-	ctx.setup(path)
-	stats.Path = path
-	defer ctx.teardown()
+var githubSummary bool
 
-	for ctx.munchline() {
-		if !(syntax.comment.Match(ctx.line) && !syntax.nocomment.Match(ctx.line)) {
-			stats.SLOC++
+// githubStep - append to the job summary and annotate failed conditions,
+// if this is a GitHub Actions step
+func githubStep(render func(io.Writer), checks []checkResult) {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return
+	}
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+		return
+	}
+	w := bufio.NewWriter(fp)
+	render(w)
+	fmt.Fprintln(w)
+	if err = w.Flush(); err == nil {
+		err = fp.Close()
+	} else {
+		fp.Close()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+	}
+	for _, c := range checks {
+		if c.failed {
+			fmt.Printf("::warning title=loccount::failed condition %s\n", c.condition)
 		}
 	}
-	return stats
 }
 
-// Generic - recognize lots of languages with generic syntax
-func countGeneric(path string) []SourceStat {
-	ctx := new(countContext)
-	var singleStat SourceStat
-	singleStat.Path = path
-
-	autofilter := func(eolcomment string) bool {
-		if wasGeneratedAutomatically(ctx, path, eolcomment) {
-			if debug > 0 {
-				fmt.Printf("automatic generation filter failed: %s\n", path)
-			}
-			return true
-		}
-		if debug > 0 {
-			fmt.Printf("automatic generation filter passed: %s\n", path)
+// writeMarkdownSummary - write the summary report as Markdown, with the
+// change in each language since the baseline if there is one
+func writeMarkdownSummary(w io.Writer, counts map[string]countRecord, totals countRecord, was map[string]countRecord, wasTotals *countRecord, checks []checkResult) {
+	assignHeaders(counts)
+	counts = foldMinor(counts, totals)
+	if minSLOC > 0 || minPercent > 0 {
+		// The baseline is folded the same way, so "other" compares
+		// like with like
+		var wasAll countRecord
+		if wasTotals != nil {
+			wasAll = *wasTotals
 		}
-		return false
+		was = foldMinor(was, wasAll)
 	}
+	totals.language = "all"
+	summary := sortable{totals}
+	for _, v := range counts {
+		summary = append(summary, v)
+	}
+	sort.Sort(summary[1:])
 
-	for i := range genericLanguages {
-		lang := genericLanguages[i]
-		if strings.HasSuffix(path, lang.suffix) {
-			if autofilter(lang.eolcomment) {
-				return []SourceStat{singleStat}
-			} else if len(lang.commentleader) > 0 {
-				stats := cFamilyCounter(ctx, path, lang)
-				if strings.HasSuffix(path, ".go") {
-					stats[0].LLOC = goCounter(path)
-				}
-				if stats[0].nonEmpty() {
-					return stats
-				}
-			} else {
-				singleStat = genericCounter(ctx, path, lang)
-				if singleStat.nonEmpty() {
-					return []SourceStat{singleStat}
-				}
+	fmt.Fprintf(w, "### loccount: %d SLOC in %d files", totals.slinecount, totals.filecount)
+	if wasTotals != nil {
+		fmt.Fprintf(w, " (%+d SLOC)", int(totals.slinecount)-int(wasTotals.slinecount))
+	}
+	fmt.Fprintf(w, "\n\n| Language | SLOC | Share | LLOC | Files |")
+	if wasTotals != nil {
+		fmt.Fprintf(w, " Change |")
+	}
+	fmt.Fprintf(w, "\n|:--|--:|--:|--:|--:|")
+	if wasTotals != nil {
+		fmt.Fprintf(w, "--:|")
+	}
+	fmt.Fprintln(w)
+	for _, r := range summary {
+		var share float64
+		if totals.slinecount > 0 {
+			share = float64(r.slinecount) * 100.0 / float64(totals.slinecount)
+		}
+		fmt.Fprintf(w, "| %s | %d | %.2f%% | %d | %d |", r.language, r.slinecount, share, r.llinecount, r.filecount)
+		if wasTotals != nil {
+			before := was[r.language].slinecount
+			if r.language == "all" {
+				before = wasTotals.slinecount
 			}
+			fmt.Fprintf(w, " %+d |", int(r.slinecount)-int(before))
 		}
+		fmt.Fprintln(w)
 	}
+	if len(checks) > 0 {
+		writeChecks(w, checks)
+	}
+}
 
-	if strings.HasSuffix(path, ".py") || hashbang(ctx, path, "python") {
-		if autofilter("#") {
-			return []SourceStat{singleStat}
+// writeChecks - list --fail-if verdicts in Markdown
+func writeChecks(w io.Writer, checks []checkResult) {
+	fmt.Fprintf(w, "\n**Checks**\n\n")
+	for _, c := range checks {
+		verdict := "passed"
+		if c.failed {
+			verdict = "**failed**"
 		}
-		singleStat = pythonCounter(ctx, path)
-		singleStat.Language = "python"
-		return []SourceStat{singleStat}
+		fmt.Fprintf(w, "- `%s`: %s\n", c.condition, verdict)
 	}
+}
 
-	if strings.HasSuffix(path, ".pl") || strings.HasSuffix(path, ".pm") || strings.HasSuffix(path, ".ph") || hashbang(ctx, path, "perl") {
-		if autofilter("#") {
-			return []SourceStat{singleStat}
-		}
-		singleStat = perlCounter(ctx, path)
-		singleStat.Language = "perl"
-		return []SourceStat{singleStat}
+// writeReview - write a review report as one JSON object or as Markdown
+func writeReview(w io.Writer, format string, summary []*diffRecord, added []fileDelta, checks []checkResult) {
+	if format == "json" {
+		type languageDelta struct {
+			Language    string `json:"language"`
+			SLOC        uint   `json:"sloc"`
+			DeltaSLOC   int    `json:"delta_sloc"`
+			AddedSLOC   int    `json:"added_sloc"`
+			RemovedSLOC int    `json:"removed_sloc"`
+			ChangedSLOC int    `json:"changed_sloc"`
+			DeltaFiles  int    `json:"delta_files"`
+		}
+		type newFile struct {
+			Path     string `json:"path"`
+			Language string `json:"language"`
+			SLOC     uint   `json:"sloc"`
+		}
+		type check struct {
+			Condition string `json:"condition"`
+			Failed    bool   `json:"failed"`
+		}
+		report := struct {
+			Languages []languageDelta `json:"languages"`
+			NewFiles  []newFile       `json:"new_files"`
+			Checks    []check         `json:"checks"`
+			Passed    bool            `json:"passed"`
+		}{[]languageDelta{}, []newFile{}, []check{}, true}
+		for _, r := range summary {
+			report.Languages = append(report.Languages, languageDelta{r.language, r.sloc,
+				r.delta(), r.added, r.removed, r.changed, r.dfiles})
+		}
+		for _, d := range added {
+			report.NewFiles = append(report.NewFiles, newFile{d.st.Path, d.st.Language, d.st.SLOC})
+		}
+		for _, c := range checks {
+			report.Checks = append(report.Checks, check{c.condition, c.failed})
+			report.Passed = report.Passed && !c.failed
+		}
+		encoder := encjson.NewEncoder(w)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(report)
+		return
 	}
 
-	if filepath.Base(path) == "wscript" {
-		if autofilter("#") {
-			return []SourceStat{singleStat}
+	totals := summary[0]
+	fmt.Fprintf(w, "### loccount: %+d SLOC (%d added, %d removed, %+d changed)\n\n",
+		totals.delta(), totals.added, totals.removed, totals.changed)
+	fmt.Fprintf(w, "| Language | SLOC | Change | Added | Removed | Changed | Files |\n")
+	fmt.Fprintf(w, "|:--|--:|--:|--:|--:|--:|--:|\n")
+	for _, r := range summary {
+		fmt.Fprintf(w, "| %s | %d | %+d | %d | %d | %+d | %+d |\n",
+			r.language, r.sloc, r.delta(), r.added, r.removed, r.changed, r.dfiles)
+	}
+	if len(added) > 0 {
+		fmt.Fprintf(w, "\n**Largest new files**\n\n")
+		fmt.Fprintf(w, "| File | Language | SLOC |\n")
+		fmt.Fprintf(w, "|:--|:--|--:|\n")
+		for _, d := range added {
+			fmt.Fprintf(w, "| `%s` | %s | %d |\n", d.st.Path, d.st.Language, d.st.SLOC)
 		}
-		singleStat = pythonCounter(ctx, path)
-		singleStat.Language = "waf"
-		return []SourceStat{singleStat}
 	}
+	if len(checks) > 0 {
+		writeChecks(w, checks)
+	}
+}
+
+// Churn.  The lines git reports added and deleted over a range are
+// summed per language, each path taking the language it was counted as
+// at the end of the range, or at the start if it was deleted.  Paths
+// that don't count as code at either end are left out.
+
+type churnRecord struct {
+	language string
+	sloc     uint // static counts at the end of the range
+	lloc     uint
+	files    uint
+	added    uint
+	deleted  uint
+	changed  uint // files with churn
+}
+
+// churnJSONRecord is a churnRecord as -j writes it
+type churnJSONRecord struct {
+	Language     string `json:"language"`
+	SLOC         uint   `json:"sloc"`
+	LLOC         uint   `json:"lloc"`
+	Filecount    uint   `json:"filecount"`
+	Added        uint   `json:"added"`
+	Deleted      uint   `json:"deleted"`
+	ChangedFiles uint   `json:"changed_files"`
+}
 
-	for i := range scriptingLanguages {
-		if autofilter("#") {
-			return []SourceStat{singleStat}
+// patchJSONRecord is a churnRecord of a patch as -j writes it
+type patchJSONRecord struct {
+	Language  string `json:"language"`
+	Added     uint   `json:"added"`
+	Deleted   uint   `json:"deleted"`
+	Filecount uint   `json:"filecount"`
+}
+
+// churn - report lines added and deleted per language over a range
+func churn(ctx context.Context, repo string, revs []string, individual bool, json bool) error {
+	numstat, err := exec.Command("git", "-C", repo, "log", "--numstat", "--no-renames", "--format=", revs[0]+".."+revs[1], "--").Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("%s", bytes.TrimSpace(exit.Stderr))
 		}
-		lang := scriptingLanguages[i]
-		if strings.HasSuffix(path, lang.suffix) || hashbang(ctx, path, lang.hashbang) {
-			singleStat = genericCounter(ctx, path,
-				genericLanguage{
-					name:lang.name,
-					eolcomment:"#",
-				})
-			singleStat.Language = lang.name
-			return []SourceStat{singleStat}
+		return fmt.Errorf("git log: %v", err)
+	}
+	var counts [2]map[string]counter.SourceStat
+	for i, rev := range revs {
+		if counts[i], err = gitTreeFiles(ctx, repo, rev); err != nil {
+			return err
 		}
 	}
 
-	for i := range pascalLikes {
-		lang := pascalLikes[i]
-		if strings.HasSuffix(path, lang.suffix) {
-			singleStat = pascalCounter(ctx, path, lang)
-			singleStat.Language = lang.name
-			if singleStat.nonEmpty() {
-				return []SourceStat{singleStat}
+	// Where a file has more than one result, as with #if 0 regions
+	// or split lex files, the biggest body of code names it.
+	languages := [2]map[string]counter.SourceStat{{}, {}}
+	for i := range counts {
+		for _, st := range counts[i] {
+			if notCode[st.Language] {
+				continue
+			}
+			was, ok := languages[i][st.Path]
+			if !ok || st.SLOC > was.SLOC || (st.SLOC == was.SLOC && st.Language < was.Language) {
+				languages[i][st.Path] = st
 			}
 		}
 	}
 
-	for i := range fortranLikes {
-		lang := fortranLikes[i]
-		if strings.HasSuffix(path, lang.suffix) {
-			singleStat = fortranCounter(ctx, path, lang)
-			singleStat.Language = lang.name
-			if singleStat.nonEmpty() {
-				return []SourceStat{singleStat}
+	type pathChurn struct {
+		added   uint
+		deleted uint
+	}
+	paths := map[string]*pathChurn{}
+	for _, line := range strings.Split(string(numstat), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		// Binary files show - for both numbers
+		added, err1 := strconv.ParseUint(fields[0], 10, 64)
+		deleted, err2 := strconv.ParseUint(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if paths[fields[2]] == nil {
+			paths[fields[2]] = new(pathChurn)
+		}
+		paths[fields[2]].added += uint(added)
+		paths[fields[2]].deleted += uint(deleted)
+	}
+
+	records := map[string]*churnRecord{}
+	record := func(language string) *churnRecord {
+		if records[language] == nil {
+			records[language] = &churnRecord{language: language}
+		}
+		return records[language]
+	}
+	for _, st := range counts[1] {
+		r := record(st.Language)
+		r.sloc += st.SLOC
+		r.lloc += st.LLOC
+		r.files++
+	}
+	var changed []string
+	for path := range paths {
+		changed = append(changed, path)
+	}
+	sort.Strings(changed)
+	for _, path := range changed {
+		st, ok := languages[1][path]
+		if !ok {
+			if st, ok = languages[0][path]; !ok {
+				continue
 			}
 		}
+		c := paths[path]
+		if individual {
+			fmt.Printf("%s %s %d %d\n", path, st.Language, c.added, c.deleted)
+			continue
+		}
+		r := record(st.Language)
+		r.added += c.added
+		r.deleted += c.deleted
+		r.changed++
+	}
+	if individual {
+		return nil
 	}
 
-	// Without this fallthrough to returning an empty stat block,
-	// we'd get no report on unclassifiables.
-	return []SourceStat{singleStat}
+	totals := churnRecord{language: "all"}
+	var summary []*churnRecord
+	for _, r := range records {
+		if !notCode[r.language] {
+			totals.sloc += r.sloc
+			totals.lloc += r.lloc
+			totals.files += r.files
+			totals.added += r.added
+			totals.deleted += r.deleted
+			totals.changed += r.changed
+		}
+		summary = append(summary, r)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		a, b := summary[i], summary[j]
+		if a.added+a.deleted != b.added+b.deleted {
+			return a.added+a.deleted > b.added+b.deleted
+		}
+		return a.language < b.language
+	})
+	for _, r := range append([]*churnRecord{&totals}, summary...) {
+		if json {
+			printJSON(churnJSONRecord{r.language, r.sloc, r.lloc, r.files, r.added, r.deleted, r.changed})
+		} else {
+			fmt.Printf("%-12s SLOC=%-7d\tADDED=%-7d DELETED=%-7d\tin %d of %d files\n",
+				r.language, r.sloc, r.added, r.deleted, r.changed, r.files)
+		}
+	}
+	return nil
 }
 
-func isDirectory(path string) bool {
-	fileInfo, err := os.Stat(path)
-	return err == nil && fileInfo.Mode().IsDir()
-}
+// Patches.  A unified diff, or a series of them as mailed, is sized
+// without applying it: each file's hunks are put together into a
+// fragment of its new version, or for a deleted file of its old one,
+// and the fragment is run through recognition under the file's path.
 
-func isRegular(path string) bool {
-	fileInfo, err := os.Stat(path)
-	return err == nil && fileInfo.Mode().IsRegular()
+type patchFile struct {
+	path    string
+	added   uint
+	deleted uint
+	image   []byte // the lines of the file the hunks show
 }
 
-// filter - winnows out uninteresting paths before handing them to process
-func filter(path string, info os.FileInfo, err error) error {
-	if debug > 0 {
-		fmt.Printf("entering filter: %s\n", path)
-	}
-	suffix := filepath.Ext(path)
-	if suffix != "" && neverInterestingBySuffix[suffix] {
-		if debug > 0 {
-			fmt.Printf("suffix filter failed: %s\n", path)
-		}
-		return err
+// patchSource serves the fragments of patched files
+type patchSource map[string]*patchFile
+
+func (p patchSource) Open(name string) (fs.File, error) {
+	file, ok := p[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
 	}
-	for i := range neverInterestingByPrefix {
-		if strings.HasPrefix(path, neverInterestingByPrefix[i]) {
-			if debug > 0 {
-				fmt.Printf("prefix filter failed: %s\n", path)
+	return patchImage{bytes.NewReader(file.image), file}, nil
+}
+
+// patchImage is an open fragment, and its own description
+type patchImage struct {
+	*bytes.Reader
+	file *patchFile
+}
+
+func (p patchImage) Stat() (fs.FileInfo, error) { return p, nil }
+func (p patchImage) Close() error               { return nil }
+func (p patchImage) Name() string               { return filepath.Base(p.file.path) }
+func (p patchImage) Size() int64                { return int64(len(p.file.image)) }
+func (p patchImage) Mode() fs.FileMode          { return 0644 }
+func (p patchImage) ModTime() time.Time         { return time.Time{} }
+func (p patchImage) IsDir() bool                { return false }
+func (p patchImage) Sys() interface{}           { return nil }
+
+// patchPath - the path a diff header names, without its a/ or b/
+// prefix or a trailing timestamp
+func patchPath(header string) string {
+	if i := strings.IndexByte(header, '\t'); i > -1 {
+		header = header[:i]
+	}
+	header = strings.TrimSpace(header)
+	if strings.HasPrefix(header, "a/") || strings.HasPrefix(header, "b/") {
+		header = header[2:]
+	}
+	return header
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,(\d+))? \+\d+(?:,(\d+))? @@`)
+
+// parsePatch - gather what a unified diff does to each file
+func parsePatch(text []byte, files patchSource, order *[]string) {
+	var file *patchFile
+	var oldPath string
+	var oldLeft, newLeft int
+	for _, line := range strings.SplitAfter(string(text), "\n") {
+		if oldLeft > 0 || newLeft > 0 {
+			switch {
+			case strings.HasPrefix(line, "+"):
+				file.added++
+				newLeft--
+				file.image = append(file.image, line[1:]...)
+			case strings.HasPrefix(line, "-"):
+				file.deleted++
+				oldLeft--
+				if file.path == oldPath {
+					file.image = append(file.image, line[1:]...)
+				}
+			case strings.HasPrefix(line, "\\"):
+				// No newline at end of file
+			default:
+				oldLeft--
+				newLeft--
+				file.image = append(file.image, strings.TrimPrefix(line, " ")...)
 			}
-			return err
+			continue
 		}
-	}
-	for i := range neverInterestingByInfix {
-		if strings.Contains(path, neverInterestingByInfix[i]) {
-			if debug > 0 {
-				fmt.Printf("infix filter failed: %s\n", path)
+		switch {
+		case strings.HasPrefix(line, "--- "):
+			oldPath = patchPath(line[4:])
+		case strings.HasPrefix(line, "+++ "):
+			path := patchPath(line[4:])
+			if path == "/dev/null" {
+				path = oldPath
 			}
-			if isDirectory(path) {
-				if debug > 0 {
-					fmt.Printf("directory skipped: %s\n", path)
+			if file = files[path]; file == nil {
+				file = &patchFile{path: path}
+				files[path] = file
+				*order = append(*order, path)
+			}
+		case file != nil:
+			if m := hunkHeader.FindStringSubmatch(line); m != nil {
+				oldLeft, newLeft = 1, 1
+				if m[1] != "" {
+					oldLeft, _ = strconv.Atoi(m[1])
+				}
+				if m[2] != "" {
+					newLeft, _ = strconv.Atoi(m[2])
 				}
-				return filepath.SkipDir
 			}
-			return err
 		}
 	}
-	basename := filepath.Base(path)
-	if neverInterestingByBasename[strings.ToLower(basename)] {
-		if debug > 0 {
-			fmt.Printf("basename filter failed: %s\n", path)
-		}
-		return err
+}
+
+// countPatches - report lines added and deleted per language by
+// patches read from files, or from standard input if none are named
+func countPatches(names []string, individual bool, json bool) error {
+	files := patchSource{}
+	var order []string
+	if len(names) == 0 {
+		names = []string{"-"}
 	}
-	if exclusions != nil && exclusions.MatchString(path) {
-		if debug > 0 {
-			fmt.Printf("exclusion '%s' filter failed: %s\n", exclusions, path)
+	for _, name := range names {
+		var text []byte
+		var err error
+		if name == "-" {
+			text, err = io.ReadAll(os.Stdin)
+		} else {
+			text, err = os.ReadFile(name)
 		}
-		return err
+		if err != nil {
+			return err
+		}
+		parsePatch(counter.NormalizeEOL(text, true), files, &order)
+	}
+
+	o := opts
+	o.Source = files
+	records := map[string]*churnRecord{}
+	for _, path := range order {
+		// Where there is more than one result, the biggest body of
+		// code names the file.
+		var language string
+		var most uint
+		parts, _ := counter.CountFileParts(path, o)
+		for _, st := range parts {
+			if st.Language != "" && !notCode[st.Language] && (language == "" || st.SLOC > most) {
+				language, most = linguistName(st.Language), st.SLOC
+			}
+		}
+		if language == "" {
+			continue
+		}
+		file := files[path]
+		if individual {
+			fmt.Printf("%s %s %d %d\n", path, language, file.added, file.deleted)
+			continue
+		}
+		if records[language] == nil {
+			records[language] = &churnRecord{language: language}
+		}
+		records[language].added += file.added
+		records[language].deleted += file.deleted
+		records[language].changed++
+	}
+	if individual {
+		return nil
 	}
 
-	/* has to come after the infix check for directory */
-	if !isRegular(path) {
-		if debug > 0 {
-			fmt.Printf("regular-file filter failed: %s\n", path)
+	totals := churnRecord{language: "all"}
+	var summary []*churnRecord
+	for _, r := range records {
+		totals.added += r.added
+		totals.deleted += r.deleted
+		totals.changed += r.changed
+		summary = append(summary, r)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		a, b := summary[i], summary[j]
+		if a.added+a.deleted != b.added+b.deleted {
+			return a.added+a.deleted > b.added+b.deleted
+		}
+		return a.language < b.language
+	})
+	for _, r := range append([]*churnRecord{&totals}, summary...) {
+		if json {
+			printJSON(patchJSONRecord{r.language, r.added, r.deleted, r.changed})
+		} else {
+			fmt.Printf("%-12s ADDED=%-7d DELETED=%-7d\tin %d files\n",
+				r.language, r.added, r.deleted, r.changed)
 		}
+	}
+	return nil
+}
+
+// History.  "loccount history" samples the first-parent history of a
+// git repository, counting the last commit of each day, week, month or
+// year, and writes SLOC per language as CSV or JSON.
+
+// periodStart - the start of the period of the given step that t is in
+func periodStart(t time.Time, step string) (time.Time, error) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch step {
+	case "daily":
+		return day, nil
+	case "weekly":
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7)), nil
+	case "monthly":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	case "yearly":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	return t, fmt.Errorf("unknown step %s (daily, weekly, monthly or yearly)", step)
+}
+
+// historyRecord is one language at one sample as -j writes it
+type historyRecord struct {
+	Date      string `json:"date"`
+	Commit    string `json:"commit"`
+	Language  string `json:"language"`
+	SLOC      uint   `json:"sloc"`
+	LLOC      uint   `json:"lloc"`
+	Filecount uint   `json:"filecount"`
+}
+
+// history - report a time series of counts from a repository's history
+func history(ctx context.Context, args []string, json bool) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	since := flags.String("since", "", "start at commits made on or after this date")
+	step := flags.String("step", "monthly", "sample daily, weekly, monthly or yearly")
+	rev := flags.String("rev", "HEAD", "revision whose history is sampled")
+	flags.Parse(args)
+	repo := "."
+	if flags.NArg() > 0 {
+		repo = flags.Arg(0)
+	}
+	if _, err := periodStart(time.Now(), *step); err != nil {
 		return err
 	}
 
-	/* toss generated Makefiles */
-	if basename == "Makefile" {
-		if _, err := os.Stat(path + ".in"); err == nil {
-			if debug > 0 {
-				fmt.Printf("generated-makefile filter failed: %s\n", path)
-			}
-			return err
+	command := []string{"-C", repo, "log", "--first-parent", "--reverse", "--format=%H %ct"}
+	if *since != "" {
+		command = append(command, "--since="+*since)
+	}
+	listing, err := exec.Command("git", append(command, *rev, "--")...).Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("%s", bytes.TrimSpace(exit.Stderr))
 		}
+		return fmt.Errorf("git log: %v", err)
 	}
 
-	if debug > 0 {
-		fmt.Printf("passed filter: %s\n", path)
+	// The last commit of each period stands for it
+	type sample struct {
+		period time.Time
+		commit string
 	}
-
-	// Now the real work gets done
-	for _, st := range countGeneric(path) {
-		pipeline <- st
+	var samples []sample
+	for _, line := range strings.Split(strings.TrimSpace(string(listing)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		stamp, _ := strconv.ParseInt(fields[1], 10, 64)
+		period, _ := periodStart(time.Unix(stamp, 0), *step)
+		if n := len(samples); n > 0 && samples[n-1].period.Equal(period) {
+			samples[n-1].commit = fields[0]
+		} else {
+			samples = append(samples, sample{period, fields[0]})
+		}
 	}
 
-	return err
-}
-
-type countRecord struct {
-	language   string
-	slinecount uint
-	llinecount uint
-	filecount  uint
+	if !json {
+		fmt.Println("date,commit,language,sloc,lloc,files")
+	}
+	for _, s := range samples {
+		files, err := gitTreeFiles(ctx, repo, s.commit)
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		totals := countRecord{language: "all"}
+		counts := map[string]countRecord{}
+		for _, st := range files {
+			r := counts[st.Language]
+			r.language = st.Language
+			r.slinecount += st.SLOC
+			r.llinecount += st.LLOC
+			r.filecount++
+			counts[st.Language] = r
+			if !notCode[st.Language] {
+				totals.slinecount += st.SLOC
+				totals.llinecount += st.LLOC
+				totals.filecount++
+			}
+		}
+		series := []countRecord{totals}
+		for _, r := range counts {
+			series = append(series, r)
+		}
+		sort.Slice(series[1:], func(i, j int) bool { return series[1+i].language < series[1+j].language })
+		date := s.period.Format("2006-01-02")
+		for _, r := range series {
+			if json {
+				printJSON(historyRecord{date, s.commit, r.language, r.slinecount, r.llinecount, r.filecount})
+			} else {
+				fmt.Printf("%s,%s,%s,%d,%d,%d\n",
+					date, s.commit, r.language, r.slinecount, r.llinecount, r.filecount)
+			}
+		}
+	}
+	return nil
 }
 
 func cocomo81(sloc uint) float64 {
@@ -1766,124 +1442,737 @@ func reportCocomo(loc uint, curve func(uint) float64) {
 	fmt.Printf(" (average salary = $%d/year, overhead = %2.2f).\n", cSALARY, cOVERHEAD)
 }
 
-// listLanguages lists all languages for which we can extract line counts.
-// It also performs a sanity check on identifying file extemsions and
-// interpreter names.
-func listLanguages(lloc bool) ([]string, bool) {
-	names := []string{"python", "waf", "perl", "go"}
-	var lastlang string
-	counts := make(map[string]int)
-	duplicates := false
-	for i := range genericLanguages {
-		lang := genericLanguages[i]
-		if lang.verifier == nil {
-			counts[lang.suffix]++
-		}
-		if counts[lang.suffix] > 1 {
-			fmt.Fprintf(os.Stderr, "loccount: extension %s duplicated\n", lang.suffix)
-			duplicates = true
-		}
-		if lang.name != lastlang {
-			if !lloc || len(genericLanguages[i].terminator) > 0 {
-				names = append(names, lang.name)
-				lastlang = lang.name
-			}
+// Versions and dialects of a language are reported as one unless
+// dialects is set.  Per-file listings always give the detailed name.
+
+var dialects bool
+
+// reportedLanguage - the name a summary gives a language
+func reportedLanguage(name string) string {
+	if !dialects {
+		return counter.BaseLanguage(name)
+	}
+	return name
+}
+
+// linguistName - the name a language is given in output
+func linguistName(name string) string {
+	if opts.LinguistNames {
+		return counter.LinguistName(name)
+	}
+	return name
+}
+
+// C headers are reassigned to the first of these present, or shared
+// among them, as headerMode says.
+var cHeaderPriority = []string{"c", "c++", "objective-c"}
+var headerMode = "first"
+
+// assignHeaders - C headers may get reassigned based on what other
+// languages are present in the tree.  By default they all go to the
+// first of C, C++ and Objective-C present; in "split" mode they are
+// shared among those present in proportion to their SLOC, and in
+// "keep" mode they stay on a line of their own.
+func assignHeaders(counts map[string]countRecord) {
+	headers, ok := counts["c-header"]
+	if !ok || headerMode == "keep" {
+		return
+	}
+	var present []string
+	var implementation uint
+	for _, lang := range cHeaderPriority {
+		if counts[lang].slinecount > 0 {
+			present = append(present, lang)
+			implementation += counts[lang].slinecount
 		}
 	}
+	if len(present) == 0 {
+		return
+	}
+	if headerMode != "split" {
+		present = present[:1]
+		implementation = counts[present[0]].slinecount
+	}
+	// Shares are rounded down; what that leaves over goes to the
+	// language with the most code.
+	largest := present[0]
+	var given countRecord
+	for _, lang := range present {
+		tmp := counts[lang]
+		weight := tmp.slinecount
+		part := func(n uint) uint {
+			return uint(uint64(n) * uint64(weight) / uint64(implementation))
+		}
+		given.slinecount += part(headers.slinecount)
+		given.llinecount += part(headers.llinecount)
+		given.filecount += part(headers.filecount)
+		tmp.slinecount += part(headers.slinecount)
+		tmp.llinecount += part(headers.llinecount)
+		tmp.filecount += part(headers.filecount)
+		counts[lang] = tmp
+		if tmp.slinecount > counts[largest].slinecount {
+			largest = lang
+		}
+	}
+	tmp := counts[largest]
+	tmp.slinecount += headers.slinecount - given.slinecount
+	tmp.llinecount += headers.llinecount - given.llinecount
+	tmp.filecount += headers.filecount - given.filecount
+	counts[largest] = tmp
+	delete(counts, "c-header")
+}
+
+// Daemon mode.  The cache stays in memory between queries, which
+// arrive as JSON objects on a Unix-domain socket, one per connection:
+//
+//	{"roots": ["/path/to/tree"], "exclude": "regexp"}
+//
+// and are answered with a report object.  Each connection is read in
+// a goroutine of its own, with a deadline, so a silent client holds up
+// no one; the counting itself is done one query at a time, so that the
+// files each query sees make up the cache for the next.
+
+// daemonTimeout bounds how long a client may take to send its query,
+// and to take its report.
+var daemonTimeout = 30 * time.Second
+
+// daemonLock serializes the counting of queries.
+var daemonLock sync.Mutex
+
+type daemonQuery struct {
+	Root    string   `json:"root"`
+	Roots   []string `json:"roots"`
+	Exclude string   `json:"exclude"`
+}
+
+type daemonRecord struct {
+	Language  string `json:"language"`
+	SLOC      uint   `json:"sloc"`
+	LLOC      uint   `json:"lloc"`
+	Filecount uint   `json:"filecount"`
+}
 
-	for i := range pascalLikes {
-		lang := pascalLikes[i]
-		if lang.verifier == nil {
-			counts[lang.suffix]++
+type daemonReport struct {
+	Error     string            `json:"error,omitempty"`
+	Totals    daemonRecord      `json:"totals"`
+	Languages []daemonRecord    `json:"languages"`
+	Warnings  []counter.Warning `json:"warnings"`
+}
+
+// answer - count the trees named in a query
+func answer(query daemonQuery) daemonReport {
+	report := daemonReport{Languages: []daemonRecord{}, Warnings: []counter.Warning{}}
+	roots := query.Roots
+	if query.Root != "" {
+		roots = append(roots, query.Root)
+	}
+	if len(roots) == 0 {
+		report.Error = "no roots given"
+		return report
+	}
+	for _, root := range roots {
+		if _, err := os.Stat(root); err != nil {
+			report.Error = err.Error()
+			return report
 		}
-		if counts[lang.suffix] > 1 {
-			fmt.Fprintf(os.Stderr, "loccount: extension %s duplicated\n", lang.suffix)
-			duplicates = true
+	}
+	o := opts
+	o.Exclude = nil
+	if query.Exclude != "" {
+		var err error
+		if o.Exclude, err = regexp.Compile(query.Exclude); err != nil {
+			report.Error = err.Error()
+			return report
 		}
-		if lang.name != lastlang {
-			if !lloc || len(pascalLikes[i].terminator) > 0 {
-				names = append(names, lang.name)
-				lastlang = lang.name
+	}
+	var warnLock sync.Mutex
+	o.Warn = func(w counter.Warning) {
+		warnLock.Lock()
+		report.Warnings = append(report.Warnings, w)
+		warnLock.Unlock()
+	}
+
+	var totals countRecord
+	counts := map[string]countRecord{}
+	for st := range counter.StreamPaths(context.Background(), roots, o.Jobs, o) {
+		if st.SLOC > 0 {
+			lang := reportedLanguage(st.Language)
+			var tmp = counts[lang]
+			tmp.language = lang
+			tmp.slinecount += st.SLOC
+			tmp.llinecount += st.LLOC
+			tmp.filecount++
+			counts[lang] = tmp
+			if !notCode[st.Language] {
+				totals.slinecount += st.SLOC
+				totals.llinecount += st.LLOC
+				totals.filecount++
 			}
 		}
 	}
+	assignHeaders(counts)
 
-	if !lloc {
-		for i := range scriptingLanguages {
-			lang := scriptingLanguages[i]
-			if lang.verifier == nil {
-				counts[lang.hashbang]++
-			}
-			if counts[lang.hashbang] > 1 {
-				fmt.Fprintf(os.Stderr, "loccount: hashbang %s duplicated\n", lang.suffix)
-				duplicates = true
+	var summary sortable
+	for _, v := range counts {
+		summary = append(summary, v)
+	}
+	sort.Sort(summary)
+	report.Totals = daemonRecord{"all", totals.slinecount, totals.llinecount, totals.filecount}
+	for _, r := range summary {
+		report.Languages = append(report.Languages,
+			daemonRecord{r.language, r.slinecount, r.llinecount, r.filecount})
+	}
+
+	// What was seen on this query becomes the cache for the next
+	opts.Cache.Advance()
+	return report
+}
+
+// serve - answer queries on a Unix-domain socket until interrupted
+func serve(socket string, cachefile string) error {
+	if opts.Cache == nil {
+		opts.Cache = counter.NewCache()
+	}
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+
+	runctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-runctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if runctx.Err() != nil {
+				return nil
 			}
-			if lang.name != lastlang {
-				names = append(names, lang.name)
-				lastlang = lang.name
+			return err
+		}
+		go converse(conn, cachefile)
+	}
+}
+
+// converse - read one query from a connection and send back the report
+func converse(conn net.Conn, cachefile string) {
+	defer conn.Close()
+	var query daemonQuery
+	var report daemonReport
+	conn.SetReadDeadline(time.Now().Add(daemonTimeout))
+	if err := encjson.NewDecoder(conn).Decode(&query); err != nil {
+		report = daemonReport{Error: err.Error(), Languages: []daemonRecord{}, Warnings: []counter.Warning{}}
+	} else {
+		if opts.Debug > 0 {
+			fmt.Fprintf(os.Stderr, "loccount: query %v\n", query)
+		}
+		daemonLock.Lock()
+		report = answer(query)
+		if cachefile != "" {
+			if err := opts.Cache.Save(cachefile); err != nil {
+				fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
 			}
 		}
+		daemonLock.Unlock()
+	}
+	conn.SetWriteDeadline(time.Now().Add(daemonTimeout))
+	encjson.NewEncoder(conn).Encode(report)
+}
+
+// Watch mode.  There is no portable way to be told of changes to a
+// tree without going outside the standard library, so the tree is
+// polled: a digest of every path's size and modification time is taken
+// each interval, and when it differs the roots are recounted as a
+// daemon query would be.  Files that haven't changed come from the
+// in-memory cache, so each recount only reads what was edited.
+
+// treeDigest - summarize the sizes and modification times of the files
+// under roots, less those exclude matches
+func treeDigest(roots []string, skip map[string]bool, exclude *regexp.Regexp) string {
+	digest := sha256.New()
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if abs, _ := filepath.Abs(path); skip[abs] || (exclude != nil && exclude.MatchString(path)) {
+				if entry.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			if info, err := entry.Info(); err == nil {
+				fmt.Fprintf(digest, "%s\x00%d\x00%d\n", path, info.Size(), info.ModTime().UnixNano())
+			}
+			return nil
+		})
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
 
-		for i := range fortranLikes {
-			lang := fortranLikes[i]
-			if counts[lang.suffix] > 1 {
-				fmt.Fprintf(os.Stderr, "loccount: extension %s duplicated\n", lang.suffix)
-				duplicates = true
+// watch - recount roots and reprint the report whenever they change,
+// until interrupted
+func watch(ctx context.Context, roots []string, interval time.Duration, exclude string, cachefile string, json bool) error {
+	if opts.Cache == nil {
+		opts.Cache = counter.NewCache()
+	}
+	// The cache's own files mustn't look like changes to the tree
+	skip := map[string]bool{}
+	for _, path := range []string{cachefile, opts.CacheDir} {
+		if path != "" {
+			skip[path] = true
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last string
+	for {
+		if digest := treeDigest(roots, skip, opts.Exclude); digest != last {
+			last = digest
+			report := answer(daemonQuery{Roots: roots, Exclude: exclude})
+			if report.Error != "" {
+				return fmt.Errorf("%s", report.Error)
 			}
-			counts[lang.suffix]++
-			if lang.name != lastlang {
-				names = append(names, lang.name)
-				lastlang = lang.name
+			if json {
+				encjson.NewEncoder(os.Stdout).Encode(report)
+			} else {
+				counts := map[string]countRecord{}
+				for _, r := range report.Languages {
+					counts[r.Language] = countRecord{r.Language, r.SLOC, r.LLOC, r.Filecount}
+				}
+				fmt.Printf("\n%s\n", time.Now().Format("15:04:05"))
+				printSummary(counts, countRecord{"all", report.Totals.SLOC, report.Totals.LLOC, report.Totals.Filecount}, "", false)
+			}
+			if cachefile != "" {
+				if err := opts.Cache.Save(cachefile); err != nil {
+					fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+				}
 			}
 		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
 	}
-	sort.Strings(names)
-	return names, duplicates
 }
 
-func listExtensions() {
-	extensions := map[string][]string{
-		"python": {".py"},
-		"waf":    {"waf"},
-		"perl":   {"pl", "pm"},
+// The -j report is one JSON object: the records of each part of the
+// report are gathered as it is done, and written at the end with the
+// version of loccount and the time of the run.  With --json-lines,
+// jsonDocument is off and each record is written as a line of its own
+// as soon as it is ready, as -j did before.
+
+var jsonDocument bool
+var jsonLines bool
+var document reportDocument
+
+type reportDocument struct {
+	Version       string                     `json:"version"`
+	Timestamp     string                     `json:"timestamp"`
+	Totals        *summaryRecord             `json:"totals"`
+	Languages     []summaryRecord            `json:"languages"`
+	Sections      []summaryRecord            `json:"sections,omitempty"`
+	Licenses      []licenseRecord            `json:"licenses,omitempty"`
+	Duplicates    []duplicateRecord          `json:"duplicates,omitempty"`
+	Duplicated    *duplicatedRecord          `json:"duplicated,omitempty"`
+	Distribution  []distributionRecord       `json:"distribution,omitempty"`
+	Extensions    []extensionSummary         `json:"extensions,omitempty"`
+	Warnings      []counter.Warning          `json:"warnings"`
+	WarningCounts map[string]map[string]uint `json:"warning_counts"`
+}
+
+type summaryRecord struct {
+	Language  string `json:"language"`
+	SLOC      uint   `json:"sloc"`
+	LLOC      uint   `json:"lloc"`
+	Filecount uint   `json:"filecount"`
+	Section   string `json:"section,omitempty"`
+}
+
+type licenseRecord struct {
+	License   string `json:"license"`
+	SLOC      uint   `json:"sloc"`
+	LLOC      uint   `json:"lloc"`
+	Filecount uint   `json:"filecount"`
+}
+
+type duplicateRecord struct {
+	Copies int      `json:"copies"`
+	SLOC   uint     `json:"sloc"`
+	Paths  []string `json:"paths"`
+}
+
+type duplicatedRecord struct {
+	SLOC  uint `json:"duplicated_sloc"`
+	Files uint `json:"duplicated_files"`
+}
+
+type distributionRecord struct {
+	Language  string  `json:"language"`
+	Filecount int     `json:"filecount"`
+	Min       uint    `json:"min"`
+	Median    float64 `json:"median"`
+	Mean      float64 `json:"mean"`
+	Max       uint    `json:"max"`
+}
+
+type extensionSummary struct {
+	Extension string `json:"extension"`
+	Filecount uint   `json:"filecount"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// With csvTable set, the report, or with -i the listing of files, is
+// written as CSV under a header row instead.  Only the language table
+// is written, so the output stays one table.
+var csvTable *csv.Writer
+
+// printJSON - write one record of the -j report
+func printJSON(record interface{}) {
+	line, _ := encjson.Marshal(record)
+	fmt.Println(string(line))
+}
+
+// writeDocument - write the gathered -j report as one object
+func writeDocument(w io.Writer) {
+	document.Version = version
+	document.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	if document.Languages == nil {
+		document.Languages = []summaryRecord{}
 	}
-	for i := range genericLanguages {
-		lang := genericLanguages[i]
-		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	encoder := encjson.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(document)
+}
+
+// printSummary - print the language records of a run, or of a section
+// of one, with the totals ahead of them
+func printSummary(counts map[string]countRecord, totals countRecord, section string, json bool) {
+	assignHeaders(counts)
+	counts = foldMinor(counts, totals)
+
+	// The totals are always given, so the report has the same shape
+	// however little was counted.
+	totals.language = "all"
+	summary := sortable{totals}
+	for _, v := range counts {
+		summary = append(summary, v)
 	}
 
-	for i := range scriptingLanguages {
-		lang := scriptingLanguages[i]
-		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	sort.Sort(summary[1:])
+	for i := range summary {
+		r := summary[i]
+		if json {
+			record := summaryRecord{r.language, r.slinecount, r.llinecount, r.filecount, section}
+			switch {
+			case !jsonDocument:
+				printJSON(record)
+			case section != "":
+				document.Sections = append(document.Sections, record)
+			case i == 0:
+				document.Totals = &record
+			default:
+				document.Languages = append(document.Languages, record)
+			}
+		} else if csvTable != nil {
+			csvTable.Write([]string{r.language, fmt.Sprint(r.slinecount), fmt.Sprint(r.llinecount), fmt.Sprint(r.filecount), section})
+		} else {
+			fmt.Println(summaryLine(r, totals))
+		}
 	}
+}
+
+// Languages with less code than minSLOC lines or minPercent of the
+// totals are summed into one "other" row of the summary.
+var minSLOC uint
+var minPercent float64
 
-	for i := range pascalLikes {
-		lang := pascalLikes[i]
-		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+// foldMinor - sum the languages too small to list into "other"
+func foldMinor(counts map[string]countRecord, totals countRecord) map[string]countRecord {
+	if minSLOC == 0 && minPercent == 0 {
+		return counts
 	}
+	folded := map[string]countRecord{}
+	for lang, r := range counts {
+		share := 0.0
+		if totals.slinecount > 0 {
+			share = float64(r.slinecount) * 100.0 / float64(totals.slinecount)
+		}
+		if notCode[lang] || (r.slinecount >= minSLOC && share >= minPercent) {
+			folded[lang] = r
+			continue
+		}
+		var tmp = folded["other"]
+		tmp.language = "other"
+		tmp.slinecount += r.slinecount
+		tmp.llinecount += r.llinecount
+		tmp.filecount += r.filecount
+		folded["other"] = tmp
+	}
+	return folded
+}
+
+// Which counts the summary gives as shares of the totals
+var percentBy = map[string]bool{"sloc": true}
+
+// setPercentBy - parse a --percent-by list
+func setPercentBy(list string) error {
+	percentBy = map[string]bool{}
+	for _, metric := range strings.Split(list, ",") {
+		switch metric = strings.TrimSpace(metric); metric {
+		case "sloc", "lloc", "files":
+			percentBy[metric] = true
+		case "":
+		default:
+			return fmt.Errorf("--percent-by: unknown count %s", metric)
+		}
+	}
+	return nil
+}
+
+// summaryLine - render one record of the summary, with the shares
+// of the totals asked for
+func summaryLine(r countRecord, totals countRecord) string {
+	width := 7
+	if pretty || siSuffixes {
+		width = 9
+	}
+	share := func(metric string, part, whole uint) string {
+		if !percentBy[metric] {
+			return ""
+		} else if whole == 0 {
+			return " (0.00%)"
+		}
+		return fmt.Sprintf(" (%2.2f%%)", float64(part)*100.0/float64(whole))
+	}
+	return fmt.Sprintf("%-12s SLOC=%-*s%s\tLLOC=%-*s%s in %s files%s",
+		r.language,
+		width, formatCount(r.slinecount),
+		share("sloc", r.slinecount, totals.slinecount),
+		width, formatCount(r.llinecount),
+		share("lloc", r.llinecount, totals.llinecount),
+		formatCount(r.filecount),
+		share("files", r.filecount, totals.filecount))
+}
 
-	for i := range fortranLikes {
-		lang := fortranLikes[i]
-		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+// reportDistribution - print the spread of SLOC per file in each
+// language, so a few huge files can be told from many small ones
+func reportDistribution(fileSizes map[string][]uint, json bool) {
+	var languages []string
+	for lang, sizes := range fileSizes {
+		sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+		languages = append(languages, lang)
 	}
-	names, duplicates := listLanguages(false)
-	for i := range names {
-		fmt.Printf("%s: %v\n", names[i], extensions[names[i]])
+	total := func(sizes []uint) (sum uint) {
+		for _, n := range sizes {
+			sum += n
+		}
+		return sum
 	}
-	if duplicates {
-		os.Exit(1)
+	sort.Slice(languages, func(i, j int) bool {
+		ti, tj := total(fileSizes[languages[i]]), total(fileSizes[languages[j]])
+		if ti != tj {
+			return ti > tj
+		}
+		return languages[i] < languages[j]
+	})
+	if !json && len(languages) > 0 {
+		fmt.Printf("\n%-12s %7s %7s %9s %9s %7s\n", "distribution", "files", "min", "median", "mean", "max")
+	}
+	for _, lang := range languages {
+		sizes := fileSizes[lang]
+		n := len(sizes)
+		median := float64(sizes[n/2])
+		if n%2 == 0 {
+			median = float64(sizes[n/2-1]+sizes[n/2]) / 2
+		}
+		mean := float64(total(sizes)) / float64(n)
+		record := distributionRecord{lang, n, sizes[0], median, math.Round(mean*100) / 100, sizes[n-1]}
+		if json && jsonDocument {
+			document.Distribution = append(document.Distribution, record)
+		} else if json {
+			printJSON(record)
+		} else {
+			fmt.Printf("%-12s %7d %7d %9.1f %9.1f %7d\n",
+				lang, n, sizes[0], median, mean, sizes[n-1])
+		}
+	}
+}
+
+// Files with the same contents, most duplicated code first.  Files are
+// named as from the command line, so those of different roots differ.
+type duplicateGroup struct {
+	sloc  uint
+	paths []string
+}
+
+// has - is a path already in the group?
+func (g *duplicateGroup) has(path string) bool {
+	for _, p := range g.paths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// noteCopy - add a result to the group of files with its contents, and
+// report whether it is an extra copy rather than (a part of) the first
+func noteCopy(copies map[string]*duplicateGroup, st counter.SourceStat) bool {
+	group := copies[st.Digest]
+	if group == nil {
+		group = &duplicateGroup{paths: []string{st.Origin}}
+		copies[st.Digest] = group
+	} else if !group.has(st.Origin) {
+		group.paths = append(group.paths, st.Origin)
+	}
+	if st.Origin != group.paths[0] {
+		return true
+	}
+	group.sloc += st.SLOC
+	return false
+}
+
+// reportDuplicates - print each set of identical files, and how much
+// code the extra copies add up to
+func reportDuplicates(copies map[string]*duplicateGroup, json bool) {
+	var groups []*duplicateGroup
+	var sloc, files uint
+	for _, g := range copies {
+		if len(g.paths) > 1 {
+			sort.Strings(g.paths)
+			groups = append(groups, g)
+			sloc += g.sloc * uint(len(g.paths)-1)
+			files += uint(len(g.paths) - 1)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		wi := groups[i].sloc * uint(len(groups[i].paths)-1)
+		wj := groups[j].sloc * uint(len(groups[j].paths)-1)
+		if wi != wj {
+			return wi > wj
+		}
+		return groups[i].paths[0] < groups[j].paths[0]
+	})
+	if json {
+		for _, g := range groups {
+			record := duplicateRecord{len(g.paths), g.sloc, g.paths}
+			if jsonDocument {
+				document.Duplicates = append(document.Duplicates, record)
+			} else {
+				printJSON(record)
+			}
+		}
+		if jsonDocument {
+			document.Duplicated = &duplicatedRecord{sloc, files}
+		} else {
+			printJSON(duplicatedRecord{sloc, files})
+		}
+		return
+	}
+	fmt.Printf("\nduplicates:\n")
+	for _, g := range groups {
+		fmt.Printf("%d copies of SLOC=%s: %s\n",
+			len(g.paths), formatCount(g.sloc), strings.Join(g.paths, ", "))
+	}
+	fmt.Printf("%-12s SLOC=%-7s in %s files\n", "duplicated", formatCount(sloc), formatCount(files))
+}
+
+// Unclassified files can be summarized by extension, so it is plain
+// which missing language would matter most to a tree.
+
+var unclassifiedSummary bool
+
+type extensionRecord struct {
+	extension string
+	filecount uint
+	bytes     int64
+}
+
+// reportExtensions - print unclassified files and bytes per extension,
+// most files first
+func reportExtensions(extensions map[string]extensionRecord, json bool) {
+	var summary []extensionRecord
+	for _, v := range extensions {
+		summary = append(summary, v)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		if summary[i].filecount != summary[j].filecount {
+			return summary[i].filecount > summary[j].filecount
+		}
+		if summary[i].bytes != summary[j].bytes {
+			return summary[i].bytes > summary[j].bytes
+		}
+		return summary[i].extension < summary[j].extension
+	})
+	for _, r := range summary {
+		if record := (extensionSummary{r.extension, r.filecount, r.bytes}); json && jsonDocument {
+			document.Extensions = append(document.Extensions, record)
+		} else if json {
+			printJSON(record)
+		} else {
+			fmt.Printf("%-12s %s files, %s bytes\n",
+				r.extension, formatCount(r.filecount), formatCount(uint(r.bytes)))
+		}
+	}
+}
+
+// reportLicenses - print SLOC and files under each license found
+func reportLicenses(licenses map[string]countRecord, totals countRecord, json bool) {
+	var summary sortable
+	for _, v := range licenses {
+		summary = append(summary, v)
+	}
+	sort.Sort(summary)
+	if !json && len(summary) > 0 {
+		fmt.Printf("\nlicenses:\n")
+	}
+	for _, r := range summary {
+		if record := (licenseRecord{r.language, r.slinecount, r.llinecount, r.filecount}); json && jsonDocument {
+			document.Licenses = append(document.Licenses, record)
+		} else if json {
+			printJSON(record)
+		} else {
+			fmt.Println(summaryLine(r, totals))
+		}
 	}
 }
 
 type sortable []countRecord
 
-func (a sortable) Len() int           { return len(a) }
-func (a sortable) Swap(i int, j int)  { a[i], a[j] = a[j], a[i] }
-func (a sortable) Less(i, j int) bool { return -a[i].slinecount < -a[j].slinecount }
+func (a sortable) Len() int          { return len(a) }
+func (a sortable) Swap(i int, j int) { a[i], a[j] = a[j], a[i] }
+func (a sortable) Less(i, j int) bool {
+	// Small languages summed by foldMinor come after the rest
+	if (a[i].language == "other") != (a[j].language == "other") {
+		return a[j].language == "other"
+	}
+	return -a[i].slinecount < -a[j].slinecount
+}
+
+// opts holds the settings of every count the command line makes
+var opts counter.Options
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
+var memprofile = flag.String("memprofile", "", "write memory profile to file")
 
 func main() {
+	os.Exit(run())
+}
+
+// run - do what the command line asks, returning the exit status, so
+// deferred cleanup is done before the process exits
+func run() int {
+	var status int
 	var individual bool
 	var unclassified bool
 	var llist bool
@@ -1891,13 +2180,41 @@ func main() {
 	var extensions bool
 	var cocomo bool
 	var json bool
+	var csvOutput bool
 	var showversion bool
+	var cachefile string
+	var langdefs string
+	var forced forceList
+	var langFor forceList
+	var markers forceList
+	var failIf forceList
+	var compare string
+	var showprogress bool
+	var showstats bool
+	var explainpath string
+	var timeout time.Duration
+	var daemon string
+	var watchInterval time.Duration
+	var percentList string
+	var auditfile string
+	var duplicates bool
+	var distribution bool
+	var dedupe bool
+	var gitDiff string
+	var churnRange string
+	var replaceMarkers bool
+	var testGlobs forceList
+	var replaceTestGlobs bool
+	var respectGitignore bool
+	var sourceEncoding string
 	excludePtr := flag.String("x", "",
 		"paths and directories to exclude")
 	flag.BoolVar(&individual, "i", false,
 		"list counts and types for individual files")
 	flag.BoolVar(&unclassified, "u", false,
 		"list unclassified files")
+	flag.BoolVar(&unclassifiedSummary, "unclassified-summary", false,
+		"summarize unclassified files by extension")
 	flag.BoolVar(&cocomo, "c", false,
 		"report Cocomo-model estimation")
 	flag.BoolVar(&llist, "l", false,
@@ -1906,14 +2223,284 @@ func main() {
 		"list languages that yield SLOC and exit")
 	flag.BoolVar(&extensions, "e", false,
 		"list extensions associated with each language and exit")
-	flag.IntVar(&debug, "d", 0,
+	flag.IntVar(&opts.Debug, "d", 0,
 		"set debug level")
+	// LOCCOUNT_JOBS sets the default, for runners where the
+	// command line is out of reach.
+	defaultJobs := runtime.NumCPU()
+	if env := os.Getenv("LOCCOUNT_JOBS"); env != "" {
+		n, err := strconv.Atoi(env)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: LOCCOUNT_JOBS must be a number\n")
+			return 1
+		}
+		defaultJobs = n
+	}
+	flag.IntVar(&opts.Jobs, "jobs", defaultJobs,
+		"number of files and directories to work on in parallel; LOCCOUNT_JOBS sets the default")
+	flag.IntVar(&opts.MaxOpenFiles, "max-open", 64,
+		"maximum number of files to hold open at once")
 	flag.BoolVar(&json, "j", false,
 		"dump statistics in JSON format")
+	flag.BoolVar(&json, "json", false,
+		"same as -j")
+	flag.BoolVar(&csvOutput, "csv", false,
+		"write the report, or the -i listing, as CSV with a header row")
+	flag.BoolVar(&jsonLines, "json-lines", false,
+		"dump statistics as JSON records, one per line")
 	flag.BoolVar(&showversion, "V", false,
 		"report version and exit")
+	flag.Var(&failIf, "fail-if",
+		"exit with status 2 if a condition such as 'sloc > 500000' holds")
+	flag.StringVar(&compare, "compare", "",
+		"JSON report of an earlier run, for delta_ conditions")
+	flag.BoolVar(&showprogress, "progress", false,
+		"show files and bytes scanned so far on stderr")
+	flag.StringVar(&explainpath, "explain", "",
+		"show how a single file is classified and exit")
+	flag.BoolVar(&opts.DryRun, "dry-run", false,
+		"list paths with the language their names suggest, without reading them")
+	flag.StringVar(&daemon, "daemon", "",
+		"answer JSON count queries on this Unix-domain socket")
+	flag.DurationVar(&watchInterval, "watch", 0,
+		"poll the tree this often, reprinting the report when it changes")
+	flag.StringVar(&headerMode, "headers", headerMode,
+		"first, split or keep: give C headers to the first C-family language present, share them, or list them apart")
+	flag.BoolVar(&dialects, "dialects", false,
+		"report versions and dialects such as php5 or fortran90 apart")
+	flag.BoolVar(&opts.LinguistNames, "linguist-names", false,
+		"name languages as GitHub's Linguist does")
+	flag.BoolVar(&opts.PerRoot, "per-root", false,
+		"report each argument separately after the combined counts")
+	flag.StringVar(&opts.Submodules, "submodules", "",
+		"include, exclude, or separate git submodules, warning of any not checked out")
+	flag.BoolVar(&respectGitignore, "respect-gitignore", true,
+		"skip what .gitignore files rule out; on by default inside git work trees")
+	flag.BoolVar(&opts.VCSOnly, "vcs-only", false,
+		"count only files tracked by git, submodules included")
+	flag.BoolVar(&githubSummary, "github-summary", false,
+		"under GitHub Actions, add the report to the job summary and annotate failed conditions")
+	flag.StringVar(&reviewFormat, "review", "",
+		"json or markdown: write diffs as a summary for code review, with --fail-if verdicts")
+	flag.StringVar(&gitDiff, "git-diff", "",
+		"report count changes between two git revisions, rev1..rev2")
+	flag.StringVar(&churnRange, "churn", "",
+		"report lines added and deleted per language over git revisions rev1..rev2")
+	flag.DurationVar(&timeout, "timeout", 0,
+		"stop walking after this long and report what was counted")
+	flag.StringVar(&auditfile, "audit", "",
+		"write each path left out of the totals, and why, to this file as JSON lines")
+	flag.BoolVar(&showstats, "stats", false,
+		"report timing and filter statistics on stderr")
+	flag.BoolVar(&pretty, "pretty", false,
+		"group digits in the report using the locale's separator")
+	flag.BoolVar(&siSuffixes, "si", false,
+		"abbreviate large numbers in the report with SI suffixes")
+	flag.UintVar(&minSLOC, "min-sloc", 0,
+		"sum languages with fewer SLOC than this into an \"other\" row")
+	flag.Float64Var(&minPercent, "min-percent", 0,
+		"sum languages with less than this percentage of the SLOC into an \"other\" row")
+	flag.StringVar(&percentList, "percent-by", "sloc",
+		"comma-separated counts to give as percentages: sloc, lloc, files")
+	flag.BoolVar(&quiet, "q", false,
+		"suppress warnings about malformed source")
+	flag.StringVar(&cachefile, "cache", "",
+		"reuse and update per-file counts stored in this file")
+	flag.StringVar(&opts.CacheDir, "cache-dir", "",
+		"reuse and store per-file counts keyed by content hash in this directory")
+	flag.StringVar(&sourceEncoding, "encoding", "auto",
+		"encoding of source files (auto to detect)")
+	flag.StringVar(&langdefs, "langdefs", "",
+		"read additional language definitions from this file")
+	flag.Var(&forced, "force-lang",
+		"make ext:language count files with that extension as that language")
+	flag.BoolVar(&opts.CountGenerated, "count-generated", false,
+		"count files that look automatically generated")
+	flag.BoolVar(&opts.GeneratedBucket, "generated-bucket", false,
+		"count generated files, reporting them as language \"generated\"")
+	flag.IntVar(&opts.GeneratedLines, "generated-lines", 15,
+		"number of leading lines to search for generated-code markers")
+	flag.IntVar(&opts.VerifyLines, "verify-lines", 0,
+		"number of leading lines content checks may read, 0 for all")
+	flag.IntVar(&opts.MinifiedLine, "minified-line", 5000,
+		"first-line length in bytes marking a file as minified (0 to disable)")
+	flag.BoolVar(&opts.SplitRecipes, "split-recipes", false,
+		"report makefile recipe lines as shell")
+	flag.BoolVar(&opts.CountPostScript, "postscript", false,
+		"count .ps and .eps files as PostScript programs")
+	flag.StringVar(&opts.SQLDialect, "sql-dialect", "auto",
+		"auto, mysql or ansi: whether SQL has MySQL # and /*! comments")
+	flag.BoolVar(&opts.CountEmbedded, "embedded", false,
+		"count scripts in web pages, fenced code in Markdown and SQL here-documents as their own languages")
+	flag.BoolVar(&opts.CountHardlinks, "count-hardlinks", false,
+		"count every hard link to a file, and bind-mounted directories each time they are reached")
+	flag.BoolVar(&opts.SplitEmbedded, "split-embedded", false,
+		"report C code in lex and yacc files as language \"embedded-c\"")
+	flag.BoolVar(&opts.SkipDisabled, "skip-disabled", false,
+		"leave #if 0 regions out of SLOC, reporting them as \"disabled\"")
+	flag.IntVar(&opts.MinifiedAverage, "minified-average", 200,
+		"average line length in bytes marking JavaScript or CSS as minified (0 to disable)")
+	flag.BoolVar(&opts.EmptyFiles, "empty-files", false,
+		"report files holding only comments or blank lines as \"comment-only\" or \"blank-only\"")
+	flag.BoolVar(&countMinified, "count-minified", false,
+		"include minified files, reported as language \"minified\", in the totals")
+	flag.Var(&markers, "generated-marker",
+		"add a regexp marking generated files")
+	flag.BoolVar(&replaceMarkers, "no-default-markers", false,
+		"use only generated-file markers given by -generated-marker")
+	flag.BoolVar(&distribution, "distribution", false,
+		"report the spread of SLOC per file in each language")
+	flag.BoolVar(&duplicates, "duplicates", false,
+		"report groups of identical files")
+	flag.BoolVar(&dedupe, "dedupe", false,
+		"count each set of identical files once")
+	flag.BoolVar(&opts.Licenses, "licenses", false,
+		"report SLOC and files under each license declared in the sources")
+	flag.BoolVar(&opts.SplitTests, "split-tests", false,
+		"report test code apart from the rest, with the test-to-code ratio")
+	flag.Var(&testGlobs, "test-pattern",
+		"add a glob marking test code for -split-tests")
+	flag.BoolVar(&replaceTestGlobs, "no-default-tests", false,
+		"use only test globs given by -test-pattern")
+	flag.Var(&langFor, "lang-for",
+		"make glob:language count matching paths as that language (or ignore them)")
 	flag.Parse()
 
+	if jsonLines {
+		json = true
+	}
+	jsonDocument = json && !jsonLines
+	// Asked for, ignore rules apply outside git work trees too
+	opts.KeepIgnored = !respectGitignore
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "respect-gitignore" {
+			opts.IgnoreAnywhere = respectGitignore
+		}
+	})
+	// On the command line 0 turns these checks off
+	for _, setting := range []*int{&opts.GeneratedLines, &opts.MinifiedLine, &opts.MinifiedAverage} {
+		if *setting == 0 {
+			*setting = -1
+		}
+	}
+	if csvOutput && json {
+		fmt.Fprintf(os.Stderr, "loccount: --csv and -j can't be used together\n")
+		return 1
+	}
+	if csvOutput {
+		// These reports have no place in the table; a listing
+		// made with -i leaves them out anyway, but for licenses
+		// as a column.
+		for _, report := range []struct {
+			name string
+			set  bool
+		}{
+			{"-c", cocomo && !individual},
+			{"--licenses", opts.Licenses && !individual},
+			{"--duplicates", duplicates && !individual},
+			{"--distribution", distribution && !individual},
+			{"--unclassified-summary", unclassifiedSummary},
+		} {
+			if report.set {
+				fmt.Fprintf(os.Stderr, "loccount: --csv and %s can't be used together\n", report.name)
+				return 1
+			}
+		}
+	}
+	if countMinified {
+		delete(notCode, "minified")
+	}
+
+	diffing := gitDiff != "" || (flag.NArg() > 0 && flag.Arg(0) == "diff")
+	if reviewFormat != "" && reviewFormat != "json" && reviewFormat != "markdown" {
+		fmt.Fprintf(os.Stderr, "loccount: --review must be json or markdown\n")
+		return 1
+	}
+	if reviewFormat != "" && !diffing {
+		fmt.Fprintf(os.Stderr, "loccount: --review needs the diff subcommand or --git-diff\n")
+		return 1
+	}
+	var conditions []condition
+	var baseline *countRecord
+	var baselineLanguages map[string]countRecord
+	for _, text := range failIf {
+		cond, err := parseCondition(text)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+		if strings.HasPrefix(cond.metric, "delta_") && compare == "" && !diffing {
+			fmt.Fprintf(os.Stderr, "loccount: %s needs --compare or a diff\n", cond.metric)
+			return 1
+		}
+		conditions = append(conditions, cond)
+	}
+	if compare != "" {
+		var err error
+		if baseline, baselineLanguages, err = loadBaseline(compare); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+	}
+
+	if headerMode != "first" && headerMode != "split" && headerMode != "keep" {
+		fmt.Fprintf(os.Stderr, "loccount: --headers must be first, split or keep\n")
+		return 1
+	}
+	if d := opts.SQLDialect; d != "auto" && d != "mysql" && d != "ansi" {
+		fmt.Fprintf(os.Stderr, "loccount: --sql-dialect must be auto, mysql or ansi\n")
+		return 1
+	}
+	if m := opts.Submodules; m != "" && m != "include" && m != "exclude" && m != "separate" {
+		fmt.Fprintf(os.Stderr, "loccount: --submodules must be include, exclude or separate\n")
+		return 1
+	}
+	if opts.Jobs < 1 {
+		fmt.Fprintf(os.Stderr, "loccount: -jobs must be at least 1\n")
+		return 1
+	}
+	if opts.MaxOpenFiles < 1 {
+		fmt.Fprintf(os.Stderr, "loccount: -max-open must be at least 1\n")
+		return 1
+	}
+
+	if err := opts.AddGeneratedMarkers(markers, replaceMarkers); err != nil {
+		fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+		return 1
+	}
+	opts.Digests = duplicates || dedupe
+	if err := setPercentBy(percentList); err != nil {
+		fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+		return 1
+	}
+	if err := opts.AddTestPatterns(testGlobs, replaceTestGlobs); err != nil {
+		fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+		return 1
+	}
+	if langdefs != "" {
+		if err := opts.LoadLangdefs(langdefs); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+	}
+	for _, mapping := range forced {
+		if err := counter.ForceLanguage(mapping); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+	}
+	for _, rule := range langFor {
+		if err := opts.AddPathRule(rule); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+	}
+
+	if err := opts.SetEncoding(sourceEncoding); err != nil {
+		fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+		return 1
+	}
+
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
@@ -1922,11 +2509,29 @@ func main() {
 		pprof.StartCPUProfile(f)
 		defer pprof.StopCPUProfile()
 	}
+	if *memprofile != "" {
+		f, err := os.Create(*memprofile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			runtime.GC() // get up-to-date statistics
+			pprof.Lookup("allocs").WriteTo(f, 0)
+			f.Close()
+		}()
+	}
+	if len(*excludePtr) > 0 {
+		opts.Exclude = regexp.MustCompile(*excludePtr)
+	}
+	opts.Warn, opts.Skipped, opts.Counted = noteWarning, noteSkipped, noteCounted
 	if showversion {
 		fmt.Printf("loccount %s\n", version)
-		return
+		return 0
 	} else if slist {
-		ll, duplicates := listLanguages(false)
+		ll, duplicates := counter.Languages(false)
+		for _, err := range duplicates {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+		}
 		if !individual {
 			fmt.Printf("%d: %s\n", len(ll), ll)
 		} else {
@@ -1934,12 +2539,12 @@ func main() {
 				fmt.Printf("%s\n", lang)
 			}
 		}
-		if duplicates {
-			os.Exit(1)
+		if duplicates != nil {
+			return 1
 		}
-		return
+		return 0
 	} else if llist {
-		ll, _ := listLanguages(true)
+		ll, _ := counter.Languages(true)
 		if !individual {
 			fmt.Printf("%d: %s\n", len(ll), ll)
 		} else {
@@ -1947,12 +2552,41 @@ func main() {
 				fmt.Printf("%s\n", lang)
 			}
 		}
-		return
+		return 0
+	} else if extensions && json {
+		out, _ := encjson.MarshalIndent(counter.LanguageTable(), "", "  ")
+		fmt.Println(string(out))
+		return 0
 	} else if extensions {
-		listExtensions()
-		return
+		suffixes := counter.Extensions()
+		names, duplicates := counter.Languages(false)
+		for _, err := range duplicates {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+		}
+		for _, name := range names {
+			fmt.Printf("%s: %v\n", name, suffixes[name])
+		}
+		if duplicates != nil {
+			return 1
+		}
+		return 0
+	} else if explainpath != "" {
+		counter.Explain(os.Stdout, explainpath, opts)
+		return 0
+	} else if flag.NArg() > 0 && flag.Arg(0) == "identify" && !isDirectory("identify") && !isRegular("identify") {
+		for _, path := range flag.Args()[1:] {
+			if isDirectory(path) {
+				fmt.Printf("%s directory\n", path)
+			} else if !isRegular(path) {
+				fmt.Printf("%s unreadable\n", path)
+			} else {
+				fmt.Printf("%s %s\n", path, linguistName(counter.Identify(path, opts)))
+			}
+		}
+		return 0
 	}
 
+	unclassified = unclassified || unclassifiedSummary
 	individual = individual || unclassified
 
 	// For maximum performance, make the pipeline be as deep as the
@@ -1964,55 +2598,222 @@ func main() {
 	if individual || unclassified {
 		chandepth = 0
 	} else {
-		chandepth = runtime.NumCPU()
+		chandepth = opts.Jobs
 	}
-	pipeline = make(chan SourceStat, chandepth)
+	collectWarnings = json && !individual
 
-	if len(*excludePtr) > 0 {
-		exclusions = regexp.MustCompile(*excludePtr)
-	}
 	roots := flag.Args()
 
-	here, _ := os.Getwd()
-	go func() {
-		for i := range roots {
-			fi, err := os.Stat(roots[i])
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				break
+	opts.Salt = optionSalt()
+	if cachefile != "" {
+		cachefile, _ = filepath.Abs(cachefile)
+		opts.Cache = counter.LoadCache(cachefile)
+	}
+	if opts.CacheDir != "" {
+		opts.CacheDir, _ = filepath.Abs(opts.CacheDir)
+	}
+
+	var auditFile *os.File
+	if auditfile != "" {
+		var err error
+		if auditFile, err = os.Create(auditfile); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+		auditLog = bufio.NewWriter(auditFile)
+	}
+	if showstats {
+		statistics = newRunStatistics()
+	}
+	if showprogress {
+		progress = new(progressMeter)
+		progress.start()
+	}
+
+	// Interruption or a deadline stops the walk; what has been
+	// counted by then is still reported.
+	runctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runctx, cancel = context.WithTimeout(runctx, timeout)
+		defer cancel()
+	}
+
+	if daemon != "" {
+		if err := serve(daemon, cachefile); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if watchInterval > 0 {
+		if err := watch(runctx, roots, watchInterval, *excludePtr, cachefile, json); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if (gitDiff != "" || churnRange != "" || (len(roots) > 0 && roots[0] == "history")) && opts.Cache == nil {
+		// Files unchanged between revisions are counted once
+		opts.Cache = counter.NewCache()
+	}
+	if gitDiff != "" || churnRange != "" {
+		repo := "."
+		if len(roots) > 0 {
+			repo = roots[0]
+		}
+		var err error
+		if gitDiff != "" {
+			var revs []string
+			if revs, err = revisionRange("--git-diff", gitDiff); err == nil {
+				var counts [2]map[string]counter.SourceStat
+				for i, rev := range revs {
+					if counts[i], err = gitTreeFiles(runctx, repo, rev); err != nil {
+						break
+					}
+				}
+				if err == nil && diffCounts(counts[0], counts[1], individual, json, conditions) {
+					status = 2
+				}
 			}
-			if fi.Mode().IsDir() {
-				os.Chdir(roots[i])
-				// The system filepath.Walk() works here,
-				// but is slower.
-				walk(".", filter)
-				os.Chdir(here)
-			} else {
-				filter(roots[i], fi, nil)
+		} else {
+			var revs []string
+			if revs, err = revisionRange("--churn", churnRange); err == nil {
+				err = churn(runctx, repo, revs, individual, json)
 			}
 		}
-		close(pipeline)
-	}()
+		if progress != nil {
+			progress.stop()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+		return status
+	}
+	if len(roots) > 0 && roots[0] == "history" && !isDirectory("history") && !isRegular("history") {
+		if err := history(runctx, roots[1:], json); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+		if progress != nil {
+			progress.stop()
+		}
+		return status
+	}
+	if len(roots) > 0 && roots[0] == "patch" && !isDirectory("patch") && !isRegular("patch") {
+		if err := countPatches(roots[1:], individual, json); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+			return 1
+		}
+		return status
+	}
+	if len(roots) > 0 && roots[0] == "diff" && !isDirectory("diff") && !isRegular("diff") {
+		if len(roots) != 3 || !isDirectory(roots[1]) || !isDirectory(roots[2]) {
+			fmt.Fprintf(os.Stderr, "loccount: diff needs two directories\n")
+			return 1
+		}
+		failed := diffCounts(countTreeFiles(runctx, roots[1], nil), countTreeFiles(runctx, roots[2], nil), individual, json, conditions)
+		if progress != nil {
+			progress.stop()
+		}
+		if failed {
+			return 2
+		}
+		return status
+	}
+
+	results := counter.StreamPaths(runctx, roots, chandepth, opts)
+	if csvOutput {
+		csvTable = csv.NewWriter(os.Stdout)
+		if individual && opts.Licenses {
+			csvTable.Write([]string{"path", "language", "sloc", "lloc", "license"})
+		} else if individual {
+			csvTable.Write([]string{"path", "language", "sloc", "lloc"})
+		} else if !unclassified && !opts.DryRun {
+			csvTable.Write([]string{"language", "sloc", "lloc", "files", "section"})
+		}
+	}
 
 	var totals countRecord
-	counts := map[string]countRecord{}
+	var testSLOC uint
+	licenses := map[string]countRecord{}
+	unknownExts := map[string]extensionRecord{}
+	copies := map[string]*duplicateGroup{}
+	fileSizes := map[string][]uint{}
+	counts := map[string]map[string]countRecord{}
+	sectionTotals := map[string]countRecord{}
 
 	// Mainline resumes
-	for {
-		st, more := <-pipeline
-		if !more {
-			break
-		}
-		if debug > 0 {
+	for st := range results {
+		if opts.Debug > 0 {
 			fmt.Printf("from pipeline: %s %d %s\n",
 				st.Path, st.SLOC, st.Language)
 		}
+		if opts.DryRun {
+			if st.Reason != "" {
+				fmt.Printf("%s skipped (%s)\n", st.Path, st.Reason)
+			} else {
+				fmt.Printf("%s %s\n", st.Path, st.Language)
+			}
+			continue
+		}
+
+		if st.Digest != "" && noteCopy(copies, st) && dedupe {
+			continue
+		}
+
+		if st.SLOC > 0 && !notCode[st.Language] {
+			totals.slinecount += st.SLOC
+			totals.llinecount += st.LLOC
+			totals.filecount++
+			if opts.SplitTests && opts.IsTest(st.Path) {
+				testSLOC += st.SLOC
+			}
+			if opts.Licenses {
+				license := st.License
+				if license == "" {
+					license = "none"
+				}
+				var tmp = licenses[license]
+				tmp.language = license
+				tmp.slinecount += st.SLOC
+				tmp.llinecount += st.LLOC
+				tmp.filecount++
+				licenses[license] = tmp
+			}
+		}
 
 		if individual {
-			if !unclassified && st.SLOC > 0 {
+			if !unclassified && st.SLOC > 0 && opts.Licenses {
+				license := st.License
+				if license == "" {
+					license = "none"
+				}
+				if csvTable != nil {
+					csvTable.Write([]string{st.Path, st.Label(), fmt.Sprint(st.SLOC), fmt.Sprint(st.LLOC), license})
+				} else {
+					fmt.Printf("%s %s %d %d %s\n",
+						st.Path, st.Label(), st.SLOC, st.LLOC, license)
+				}
+			} else if !unclassified && reported(st) && csvTable != nil {
+				csvTable.Write([]string{st.Path, st.Label(), fmt.Sprint(st.SLOC), fmt.Sprint(st.LLOC)})
+			} else if !unclassified && reported(st) {
 				fmt.Printf("%s %s %d %d\n",
-					st.Path, st.Language, st.SLOC, st.LLOC)
-			} else if unclassified && st.SLOC == 0 {
+					st.Path, st.Label(), st.SLOC, st.LLOC)
+			} else if unclassified && !reported(st) && unclassifiedSummary {
+				ext := strings.ToLower(filepath.Ext(st.Path))
+				if ext == "" {
+					ext = "(none)"
+				}
+				var tmp = unknownExts[ext]
+				tmp.extension = ext
+				tmp.bytes += st.Size
+				tmp.filecount++
+				unknownExts[ext] = tmp
+			} else if unclassified && !reported(st) {
 				// Not a recognized source type,
 				// nor anything we know to discard
 				fmt.Println(st.Path)
@@ -2020,62 +2821,166 @@ func main() {
 			continue
 		}
 
-		if st.SLOC > 0 {
-			var tmp = counts[st.Language]
-			tmp.language = st.Language
+		lang := reportedLanguage(st.Language)
+		if st.SLOC > 0 && distribution {
+			fileSizes[lang] = append(fileSizes[lang], st.SLOC)
+		}
+		if reported(st) {
+			if counts[st.Section] == nil {
+				counts[st.Section] = map[string]countRecord{}
+			}
+			var tmp = counts[st.Section][lang]
+			tmp.language = lang
 			tmp.slinecount += st.SLOC
 			tmp.llinecount += st.LLOC
 			tmp.filecount++
-			counts[st.Language] = tmp
-			totals.slinecount += st.SLOC
-			totals.llinecount += st.LLOC
-			totals.filecount++
+			counts[st.Section][lang] = tmp
+			if !notCode[st.Language] {
+				tmp = sectionTotals[st.Section]
+				tmp.slinecount += st.SLOC
+				tmp.llinecount += st.LLOC
+				tmp.filecount++
+				sectionTotals[st.Section] = tmp
+			}
 		}
 	}
 
-	if individual {
-		return
+	if progress != nil {
+		progress.stop()
+	}
+	if auditLog != nil {
+		auditLog.Flush()
+		auditFile.Close()
 	}
 
-	// C headers may get reassigned based on what other languages
-	// are present in the tree
-	if counts["c-header"].slinecount > 0 {
-		for i := range cHeaderPriority {
-			if counts[cHeaderPriority[i]].slinecount > 0 {
-				var tmp = counts[cHeaderPriority[i]]
-				tmp.slinecount += counts["c-header"].slinecount
-				counts[cHeaderPriority[i]] = tmp
-				delete(counts, "c-header")
-				break
-			}
+	if err := runctx.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "loccount: %v; counts are partial\n", err)
+		status = 1
+	}
+	stop()
+
+	if cachefile != "" {
+		if err := opts.Cache.Save(cachefile); err != nil {
+			fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
 		}
 	}
 
-	var summary sortable
-	totals.language = "all"
-	if totals.filecount > 1 {
-		summary = append(summary, totals)
+	if statistics != nil {
+		statistics.report()
 	}
-	for _, v := range counts {
-		summary = append(summary, v)
+
+	// Threshold failures are reported after the normal output
+	failed := false
+	var checks []checkResult
+	for _, cond := range conditions {
+		holds := cond.holds(totals, baseline)
+		if holds {
+			fmt.Fprintf(os.Stderr, "loccount: failed condition %s\n", cond.text)
+			failed = true
+		}
+		checks = append(checks, checkResult{cond.text, holds})
+	}
+	if failed {
+		status = 2
 	}
 
-	sort.Sort(summary)
-	for i := range summary {
-		r := summary[i]
-		if json {
-			fmt.Printf("{\"language\":%q, \"sloc\":%d, \"lloc\":%d, \"filecount\":%d}\n",
-				r.language,
-				r.slinecount,
-				r.llinecount,
-				r.filecount)
+	if unclassifiedSummary {
+		reportExtensions(unknownExts, json)
+	}
+	if individual || opts.DryRun {
+		if csvTable != nil {
+			csvTable.Flush()
+		}
+		if unclassifiedSummary && jsonDocument {
+			writeDocument(os.Stdout)
+		}
+		return status
+	}
+
+	var sections []string
+	for section := range counts {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	if len(sections) == 0 && !opts.PerRoot {
+		sections = []string{""}
+	}
+	combined := map[string]countRecord{}
+	for _, section := range sections {
+		for lang, r := range counts[section] {
+			var tmp = combined[lang]
+			tmp.language = lang
+			tmp.slinecount += r.slinecount
+			tmp.llinecount += r.llinecount
+			tmp.filecount += r.filecount
+			combined[lang] = tmp
+		}
+	}
+	if opts.PerRoot {
+		// The whole run comes first, then each root
+		printSummary(combined, totals, "", json)
+	}
+	for _, section := range sections {
+		if section != "" && !json && csvTable == nil {
+			fmt.Printf("\n%s:\n", section)
+		}
+		printSummary(counts[section], sectionTotals[section], section, json)
+	}
+	if csvTable != nil {
+		csvTable.Flush()
+	}
+	if opts.Licenses {
+		reportLicenses(licenses, totals, json)
+	}
+	if duplicates {
+		reportDuplicates(copies, json)
+	}
+	if distribution {
+		reportDistribution(fileSizes, json)
+	}
+	if opts.SplitTests && !json && totals.slinecount > testSLOC {
+		ratio := fmt.Sprintf("test-to-code ratio %.2f\n", float64(testSLOC)/float64(totals.slinecount-testSLOC))
+		if csvTable != nil {
+			// Kept out of the table, like warnings
+			fmt.Fprint(os.Stderr, ratio)
 		} else {
-			fmt.Printf("%-12s SLOC=%-7d (%2.2f%%)\tLLOC=%-7d in %d files\n",
-				r.language,
-				r.slinecount,
-				float64(r.slinecount)*100.0/float64(totals.slinecount),
-				r.llinecount,
-				r.filecount)
+			fmt.Print("\n" + ratio)
+		}
+	}
+
+	if json {
+		sort.Slice(warnings, func(i, j int) bool {
+			if warnings[i].File != warnings[j].File {
+				return warnings[i].File < warnings[j].File
+			}
+			return warnings[i].Line < warnings[j].Line
+		})
+		if warnings == nil {
+			warnings = []counter.Warning{}
+		}
+		if jsonDocument {
+			document.Warnings, document.WarningCounts = warnings, warningCounts
+			writeDocument(os.Stdout)
+		} else {
+			// Readers of the stream may expect language records
+			// only, so there are no warning records without warnings.
+			if len(warnings) > 0 {
+				printJSON(map[string][]counter.Warning{"warnings": warnings})
+			}
+			if len(warningCounts) > 0 {
+				printJSON(map[string]interface{}{"warning_counts": warningCounts})
+			}
+		}
+	} else {
+		// Warnings have no place in a CSV table
+		out := os.Stdout
+		if csvTable != nil {
+			out = os.Stderr
+		}
+		if summary := warningSummary(); suppressedWarnings > 0 {
+			fmt.Fprintf(out, "%d warnings suppressed (%s)\n", suppressedWarnings, summary)
+		} else if summary != "" {
+			fmt.Fprintf(out, "warnings: %s\n", summary)
 		}
 	}
 
@@ -2083,6 +2988,13 @@ func main() {
 		reportCocomo(totals.slinecount, cocomo81)
 		reportCocomo(totals.llinecount, cocomo2000)
 	}
+
+	if githubSummary {
+		githubStep(func(w io.Writer) {
+			writeMarkdownSummary(w, combined, totals, baselineLanguages, baseline, checks)
+		}, checks)
+	}
+	return status
 }
 
 // end
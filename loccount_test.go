@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gitlab.com/esr/loccount/pkg/counter"
+)
+
+// Identical files at the same path under two roots are two copies.
+func TestDuplicatesAcrossRoots(t *testing.T) {
+	dir := t.TempDir()
+	for _, root := range []string{"a", "b"} {
+		if err := os.MkdirAll(filepath.Join(dir, root), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, root, "m.c"), []byte("int x;\nint y;\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	copies := map[string]*duplicateGroup{}
+	var extra int
+	roots := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	for st := range counter.StreamPaths(context.Background(), roots, 1, counter.Options{Digests: true}) {
+		if st.Digest != "" && noteCopy(copies, st) {
+			extra++
+		}
+	}
+	if extra != 1 || len(copies) != 1 {
+		t.Fatalf("got %d extra copies in %d groups, want 1 in 1", extra, len(copies))
+	}
+	for _, g := range copies {
+		if len(g.paths) != 2 || g.sloc != 2 {
+			t.Errorf("got paths %v with SLOC %d, want both files with 2", g.paths, g.sloc)
+		}
+	}
+}
+
+// A --compare baseline may be a -j document or --json-lines records.
+func TestLoadBaseline(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		"doc.json": `{"version": "2.0", "totals": {"language": "all", "sloc": 5, "lloc": 3, "filecount": 2},
+			"languages": [{"language": "c", "sloc": 3, "lloc": 2, "filecount": 1},
+				{"language": "go", "sloc": 2, "lloc": 1, "filecount": 1}]}`,
+		"lines.json": `{"language":"all","sloc":5,"lloc":3,"filecount":2}
+{"language":"c","sloc":3,"lloc":2,"filecount":1}
+{"language":"go","sloc":2,"lloc":1,"filecount":1}
+`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		totals, languages, err := loadBaseline(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if totals.slinecount != 5 || totals.filecount != 2 || languages["c"].slinecount != 3 {
+			t.Errorf("%s: got totals %+v and languages %+v", name, *totals, languages)
+		}
+	}
+}
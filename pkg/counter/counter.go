@@ -0,0 +1,5803 @@
+// SPDX-License-Identifier: BSD-2-Clause
+
+// Package counter is the counting engine of loccount: it walks trees,
+// recognizes the languages of files, and counts their source lines.
+// The settings of a count are passed in Options, and every run keeps
+// its own state, so runs may go on at once.  Only the language tables,
+// extended by RegisterLanguage, LoadLangdefs and ForceLanguage, are
+// shared.
+package counter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	encjson "encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+/*
+How to add support for a language to this program:
+
+All the language-specific information this program needs to know to do
+its job is the syntax of comments and string literals.  Generally,
+languages fall into one of the following groups:
+
+* C-like: C is the prototype. This code recognizes them by file
+  extension and verifier.  These languages have two kinds of comment.
+  One is a block comment delimited by two distinct strings and the
+  second is a winged comment introduced by a third string and
+  terminated by newline.You can add support simply by appending
+  an initializer to the genericLanguages table; any entry with a
+  nonempty block-comment leader invokes C-like parsing.  See tthe
+  list of syntax flags for more.
+
+* Generic languages have only winged comments, usually led with #.
+  This code recognizes them by file extension and verifier.  You can
+  append an initializer to the genericLanguages table specifying a
+  name, an extension, and the winged-comment leader.  Any entry with
+  empty commentleader and trailer strings gets generic parsing.
+
+* Scripting languages have only winged comments, always led with #.
+  This code recognizes them by file extension, or by looking for a
+  hashbang line identifying the interpreter.  You can append an
+  initializer to the scriptingLanguages table specifying a name, an
+  extension, and a matching string to look for in a hashbang line.
+
+* Pascal-likes use the (* *) block comment syntax.  This code
+  recognizes them by file extension and verifier.  You can append an
+  initializer to the PascalLikes table specifying a name, an
+  extension, and a boolean saying whether the language uses { } as
+  additional pair of block comments.
+
+* Fortran-likes use various start-of-line characters as comment
+  leaders.  This code recognizes them by file extension only.  You can
+  append an initializer to the fortranLikes table specifying a pair of
+  (compiled) regular expressions; comments are recognized by matching
+  the first and not the second.
+
+* Anything else can be handled by writing a Counter and passing it,
+  with the suffixes and hashbang it claims, to RegisterLanguage.  The
+  same call can replace the counting of a language the tables handle,
+  as registered languages are tried first.
+
+You may add multiple entries with the same language name, but extensions
+must be unique across all tables - *except* that entries with verifiers
+may share extensions with each other and with one trailing entry that has
+no verifier.
+
+Important last step in adding a language: Add an example to the test suite!
+*/
+
+// Following code swiped from Michael T. Jones's "walk" package.
+// It's a parallelized implementation of tree-walking that's
+// faster than the version in the system filepath library.
+// Note, however, it seems to have a limitation - does not like paths
+// containing "..".
+
+type visitData struct {
+	path string
+	info fs.FileInfo
+}
+
+// WalkFunc is the type of the function called for each file or directory
+// visited by Walk. The path argument contains the argument to Walk as a
+// prefix; that is, if Walk is called with "dir", which is a directory
+// containing the file "a", the walk function will be called with argument
+// "dir/a". The info argument is the fs.FileInfo for the named path.
+//
+// If there was a problem walking to the file or directory named by path, the
+// incoming error will describe the problem and the function can decide how
+// to handle that error (and Walk will not descend into that directory). If
+// an error is returned, processing stops. The sole exception is that if path
+// is a directory and the function returns the special value SkipDir, the
+// contents of the directory are skipped and processing continues as usual on
+// the next file.
+type WalkFunc func(path string, info fs.FileInfo, err error) error
+
+type walkState struct {
+	ctx        context.Context
+	run        *run
+	walkFn     WalkFunc
+	lock       sync.Mutex
+	wake       *sync.Cond  // signalled when work arrives or runs out
+	queue      []visitData // directories waiting to be visited
+	pending    int         // directories queued or being visited
+	firstError error       // accessed using lock
+}
+
+func (ws *walkState) terminated() bool {
+	ws.lock.Lock()
+	done := ws.firstError != nil
+	ws.lock.Unlock()
+	return done || ws.ctx.Err() != nil
+}
+
+func (ws *walkState) setTerminated(err error) {
+	ws.lock.Lock()
+	if ws.firstError == nil {
+		ws.firstError = err
+	}
+	ws.lock.Unlock()
+	return
+}
+
+// push - queue a directory for whichever worker is free first.  The
+// queue grows as needed, so no worker ever has to recurse into a
+// directory itself, however wide or deep the tree.
+func (ws *walkState) push(file visitData) {
+	ws.lock.Lock()
+	ws.queue = append(ws.queue, file)
+	ws.pending++
+	ws.lock.Unlock()
+	ws.wake.Signal()
+}
+
+// visitQueue - a worker: visit queued directories until none are left
+func (ws *walkState) visitQueue() {
+	ws.lock.Lock()
+	for {
+		for len(ws.queue) == 0 && ws.pending > 0 {
+			ws.wake.Wait()
+		}
+		if ws.pending == 0 {
+			ws.lock.Unlock()
+			ws.wake.Broadcast()
+			return
+		}
+		// Take the newest entry, which keeps the walk depth-first
+		// and the queue short.
+		file := ws.queue[len(ws.queue)-1]
+		ws.queue = ws.queue[:len(ws.queue)-1]
+		ws.lock.Unlock()
+		ws.visitFile(file)
+		ws.lock.Lock()
+		ws.pending--
+		if ws.pending == 0 {
+			ws.wake.Broadcast()
+		}
+	}
+}
+
+// readDirNames reads the directory named by dirname and returns
+// a sorted list of directory entries.
+func (r *run) readDirNames(dirname string) ([]string, error) {
+	r.openFile()
+	entries, err := fs.ReadDir(r.source, dirname)
+	r.closeFile()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i := range entries {
+		names[i] = entries[i].Name()
+	}
+	return names, nil
+}
+
+func (ws *walkState) visitFile(file visitData) {
+	if ws.terminated() {
+		return
+	}
+
+	err := ws.walkFn(file.path, file.info, nil)
+	if err != nil {
+		if !(file.info.IsDir() && err == filepath.SkipDir) {
+			ws.setTerminated(err)
+		}
+		return
+	}
+
+	if !file.info.IsDir() {
+		return
+	}
+
+	names, err := ws.run.readDirNames(file.path)
+	if err != nil {
+		err = ws.walkFn(file.path, file.info, err)
+		if err != nil {
+			ws.setTerminated(err)
+		}
+		return
+	}
+
+	here := file.path
+	for _, name := range names {
+		if ws.terminated() {
+			return
+		}
+		file.path = filepath.Join(here, name)
+		file.info, err = ws.run.lstat(file.path)
+		if err != nil {
+			err = ws.walkFn(file.path, file.info, err)
+			if err != nil && (!file.info.IsDir() || err != filepath.SkipDir) {
+				ws.setTerminated(err)
+				return
+			}
+		} else {
+			switch file.info.IsDir() {
+			case true:
+				// push directory info to queue for concurrent traversal
+				ws.push(file)
+			case false:
+				err = ws.walkFn(file.path, file.info, nil)
+				if err != nil {
+					ws.setTerminated(err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root. All errors that arise visiting files
+// and directories are filtered by walkFn. The files are walked in a random
+// order. walk does not follow symbolic links.  The walk stops early, returning
+// the context's error, if ctx is cancelled.
+
+func (r *run) walk(ctx context.Context, root string, walkFn WalkFunc) error {
+	info, err := r.lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+
+	ws := &walkState{
+		ctx:    ctx,
+		run:    r,
+		walkFn: walkFn,
+	}
+	ws.wake = sync.NewCond(&ws.lock)
+	ws.push(visitData{root, info})
+
+	var workers sync.WaitGroup
+	for i := 0; i < r.Jobs; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			ws.visitQueue()
+		}()
+	}
+	workers.Wait()
+
+	if ws.firstError == nil {
+		return ctx.Err()
+	}
+	return ws.firstError
+}
+
+// Swiped code ends here
+
+// SourceStat - line count record for a specified path
+type SourceStat struct {
+	Path     string
+	Language string
+	SLOC     uint
+	LLOC     uint
+	Section  string `json:",omitempty"` // Part of the report, if not the main one
+	License  string `json:",omitempty"` // As declared by the file, with Options.Licenses
+	Digest   string `json:",omitempty"` // Of the contents, with Options.Digests
+	Reason   string `json:",omitempty"` // Why nothing was counted, if known
+	Host     string `json:",omitempty"` // Language the code is embedded in, if any
+	Size     int64  `json:"-"`          // Bytes in the file
+	Origin   string `json:"-"`          // The file as named from the root it was found under, with Digest
+}
+
+// Label gives the language as listings of individual files give it.
+func (s SourceStat) Label() string {
+	if s.Host != "" {
+		return s.Language + "@" + s.Host
+	}
+	return s.Language
+}
+
+func (s SourceStat) nonEmpty() bool {
+	return s.SLOC > 0
+}
+
+// Options say what a count takes in and how it counts.  The zero value
+// counts as the loccount command does given no options.  The settings
+// that have to be parsed, such as path rules, are made with methods.
+type Options struct {
+	Source       fs.FS          // Where paths are read from; nil for the operating system's files
+	Exclude      *regexp.Regexp // Paths to leave out, as with -x
+	Jobs         int            // Files and directories worked on at once; 0 for one per CPU
+	MaxOpenFiles int            // Files held open at once; 0 for 64
+	Debug        int            // Level of debugging output, on standard output
+
+	// With DryRun no file is read.  The SourceStat of each file gives
+	// the languages its name suggests, separated by slashes, as its
+	// Language, or the Reason it would be skipped.
+	DryRun bool
+
+	// With VCSOnly, only files git tracks under a directory root are
+	// counted, those of submodules included.
+	VCSOnly bool
+
+	// Files and directories matched by .gitignore files, or by the
+	// info/exclude file of the repository, are skipped unless
+	// KeepIgnored is set.  A root inside a git work tree is matched as
+	// a path from the top of it, so the .gitignore files of the
+	// directories above the root apply too.  Outside a work tree, the
+	// .gitignore files of the tree itself are read only if
+	// IgnoreAnywhere is set.
+	KeepIgnored    bool
+	IgnoreAnywhere bool
+
+	// By default the walk goes into whatever submodules are checked
+	// out.  With Submodules set to "include", "exclude" or "separate",
+	// the submodules under each directory root are looked up first:
+	// those not checked out are reported, and the others included,
+	// excluded, or given sections of their own.
+	Submodules string
+
+	CountHardlinks bool // Count a file each time a hard link or bind mount leads to it
+	PerRoot        bool // Make each root the Section of its files
+	SplitTests     bool // Add " tests" to the Section of test code
+
+	GeneratedLines  int    // Leading lines searched for generated-code markers; 0 for 15, negative for none
+	CountGenerated  bool   // Count files that look generated
+	GeneratedBucket bool   // Count them as language "generated"
+	VerifyLines     int    // Lines content checks may read; 0 for all
+	MinifiedLine    int    // Length of a first line marking a file minified; 0 for 5000, negative for no limit
+	MinifiedAverage int    // Average line length marking JavaScript or CSS minified; 0 for 200, negative for no limit
+	SkipDisabled    bool   // Report C-family code under #if 0 as "disabled"
+	SplitEmbedded   bool   // Report the C in lex and yacc files as "embedded-c"
+	CountEmbedded   bool   // Count code embedded in other files as the language it is written in
+	SplitRecipes    bool   // Report the recipes of makefiles as shell
+	SQLDialect      string // "mysql" or "ansi", or "" to decide file by file
+	CountPostScript bool   // Count .ps and .eps files as PostScript programs
+	EmptyFiles      bool   // Report files holding no code as "comment-only" or "blank-only"
+	Licenses        bool   // Fill in License
+	Digests         bool   // Fill in Digest and Origin
+	LinguistNames   bool   // Name languages as GitHub's Linguist does
+
+	Cache    *Cache // Counts kept between runs, by path
+	CacheDir string // Directory of counts kept by content hash
+	Salt     string // Fingerprint of the settings, stored with cached counts
+
+	// Each warning about malformed source is passed to Warn, if it is
+	// set, or else its message printed on standard error.
+	Warn func(Warning)
+
+	// Skipped is called, if set, with each path the filters leave out,
+	// the reason, and whether it is a directory, and Counted with each
+	// file counted.  Calls may come from several goroutines at once.
+	Skipped func(path string, reason string, dir bool)
+	Counted func(path string, info fs.FileInfo, results []SourceStat, elapsed time.Duration)
+
+	encoding   string // of the sources; "" to detect
+	generated  string // markers of generated code, as one regexp
+	markersSet bool   // generated replaces the default markers
+	pathRules  []pathRule
+	testRules  []pathRule
+	testsSet   bool // testRules replaces the default test patterns
+}
+
+// A run is one walk over the roots given to StreamPaths, or the count
+// of a single file, with the state kept on the way.
+type run struct {
+	Options
+	source     fs.FS           // Files are read through, rooted at the directory being walked
+	done       <-chan struct{} // Closed when the run is cancelled
+	pipeline   chan SourceStat
+	countQueue chan visitData
+	openFiles  chan struct{}
+	explaining io.Writer // Where classification is traced, if anywhere
+
+	seenLock  sync.Mutex
+	seenFiles map[fileID]bool
+
+	root          string          // As named by the caller
+	tracked       map[string]bool // With VCSOnly, files under root git tracks, and the directories above them
+	submoduleRoot string
+	submodules    []string
+
+	ignoreActive bool   // Ignore rules apply to root
+	ignoreTop    string // Top of its work tree, or "" to read through source
+	ignorePrefix string // Path from ignoreTop to root, slash-separated
+	ignoreLock   sync.Mutex
+	ignoreCache  map[string][]ignoreRule
+}
+
+// newRun - set up a run, filling in the defaults of settings left zero
+func newRun(ctx context.Context, opts Options) *run {
+	r := &run{Options: opts, done: ctx.Done(), seenFiles: map[fileID]bool{}}
+	if r.Source == nil {
+		r.Source = osFS{}
+	}
+	r.source = r.Source
+	if r.Jobs < 1 {
+		r.Jobs = runtime.NumCPU()
+	}
+	if r.MaxOpenFiles < 1 {
+		r.MaxOpenFiles = 64
+	}
+	if r.GeneratedLines == 0 {
+		r.GeneratedLines = 15
+	}
+	if r.MinifiedLine == 0 {
+		r.MinifiedLine = 5000
+	}
+	if r.MinifiedAverage == 0 {
+		r.MinifiedAverage = 200
+	}
+	if !r.markersSet {
+		r.generated = defaultMarkers
+	}
+	return r
+}
+
+type ignoreRule struct {
+	pattern  *regexp.Regexp
+	negate   bool
+	dirOnly  bool
+	basename bool // pattern has no slash, so matches a name at any depth
+}
+
+// Data tables driving the recognition and counting of classes of languages.
+// The generic table is written with keyed fields, so that a language
+// gives only the capabilities it has.
+
+type genericLanguage struct {
+	name           string
+	suffix         string
+	commentleader  string
+	commenttrailer string
+	eolcomment     string
+	multistring    string
+	flags          uint
+	terminator     string
+	verifier       func(*countContext, string) bool
+	rawstrings     []rawString
+	heredoc        *heredoc
+	continuation   string // ends a line that continues on the next
+}
+
+// rawString describes a raw or verbatim string literal form.  Inside
+// one, comment leaders and ordinary quotes mean nothing, and neither
+// does backslash unless escapes is set.
+type rawString struct {
+	open    string // introducer, such as R" or @"
+	close   string // terminator
+	fence   string // C++: a delimiter ending at fence[0] follows open; fence[1] and the delimiter precede close
+	hashes  bool   // Rust, Swift: open is followed by any number of #, then ", and close by as many # as seen
+	doubled bool   // C#: close written twice stands for itself
+	escapes bool   // backslash escapes apply
+	doc     bool   // first on its line, it is documentation, not code
+}
+
+var cppRawStrings = []rawString{{open: `R"`, close: `"`, fence: "()"}}
+var csharpRawStrings = []rawString{
+	{open: `"""`, close: `"""`},
+	{open: `@"`, close: `"`, doubled: true},
+	{open: `@$"`, close: `"`, doubled: true},
+}
+var rustRawStrings = []rawString{{open: "r", close: `"`, hashes: true}}
+var swiftRawStrings = []rawString{
+	{open: "#", close: `"`, hashes: true},
+	{open: `"""`, close: `"""`, escapes: true},
+}
+var textBlocks = []rawString{{open: `"""`, close: `"""`, escapes: true}}
+var backquoteStrings = []rawString{{open: "`", close: "`"}}
+var redStrings = []rawString{
+	{open: "comment {", close: "}", doc: true},
+	{open: "{", close: "}"},
+}
+
+// CoffeeScript block comments are documentation.  Four or more #s
+// make an ordinary comment, often a rule across the page.
+var coffeeStrings = []rawString{
+	{open: "####", close: "\n", doc: true},
+	{open: "###", close: "###", doc: true},
+	{open: `"""`, close: `"""`, escapes: true},
+	{open: "'''", close: "'''", escapes: true},
+}
+var ponyDocstrings = []rawString{{open: `"""`, close: `"""`, escapes: true, doc: true}}
+
+// Eiffel verbatim strings, aligned and not, often fill note clauses
+var eiffelVerbatimStrings = []rawString{
+	{open: `"[`, close: `]"`},
+	{open: `"{`, close: `}"`},
+}
+
+// Janet long strings close with as many backquotes as opened them
+var janetLongStrings = []rawString{
+	{open: "````", close: "````"},
+	{open: "```", close: "```"},
+	{open: "``", close: "``"},
+	{open: "`", close: "`"},
+}
+
+// heredoc describes a language's here-documents.  The first nonempty
+// submatch of introducer is the terminating word; a line holding just
+// that word, perhaps indented and followed by punctuation, ends the
+// document.  The lines in between are string data: they count as code
+// but are never scanned for comments or statement terminators.
+type heredoc struct {
+	introducer *regexp.Regexp
+}
+
+var shellHeredoc = &heredoc{regexp.MustCompile(`(?:^|[^<])<<-?[ \t]*(?:"([^"\n]+)"|'([^'\n]+)'|\\?([\pL_][\pL\pN_]*))`)}
+var rubyHeredoc = &heredoc{regexp.MustCompile(`(?:^|[^<\pL\pN_])<<[-~]?(?:"([^"\n]+)"|'([^'\n]+)'|([\p{Lu}_][\p{Lu}\pN_]*))`)}
+var perlHeredoc = &heredoc{regexp.MustCompile(`<<~?[ \t]*(?:"([^"\n]+)"|'([^'\n]+)')|<<~?([\pL_][\pL\pN_]*)`)}
+var phpHeredoc = &heredoc{regexp.MustCompile(`<<<[ \t]*(?:"([\pL\pN_]+)"|'([\pL\pN_]+)'|([\pL_][\pL\pN_]*))`)}
+
+// opens - the terminator of a here-document a line begins, if any
+func (h *heredoc) opens(line []byte) string {
+	if h == nil {
+		return ""
+	}
+	m := h.introducer.FindSubmatch(line)
+	for i := 1; i < len(m); i++ {
+		if len(m[i]) > 0 {
+			return string(m[i])
+		}
+	}
+	return ""
+}
+
+// closes - does a line end the here-document with the given terminator?
+func (h *heredoc) closes(line []byte, terminator string) bool {
+	line = bytes.TrimLeftFunc(line, unicode.IsSpace)
+	if !bytes.HasPrefix(line, []byte(terminator)) {
+		return false
+	}
+	return len(bytes.Trim(line[len(terminator):], " \t\r\n;,)")) == 0
+}
+
+func (g genericLanguage) property(v uint) bool {
+	return (v & g.flags) != 0
+}
+
+var genericLanguages []genericLanguage
+
+type scriptingLanguage struct {
+	name     string
+	suffix   string
+	hashbang string
+	verifier func(*countContext, string) bool
+	heredoc  *heredoc
+}
+
+var scriptingLanguages []scriptingLanguage
+
+type pascalLike struct {
+	name            string
+	suffix          string
+	bracketcomments bool
+	nestcomments    bool // (* *) comments nest
+	terminator      string
+	verifier        func(*countContext, string) bool
+}
+
+var pascalLikes []pascalLike
+
+const dt = `"""`
+const st = `'''`
+
+var dtriple, striple, dtrailer, strailer, dlonely, slonely *regexp.Regexp
+
+var podheader *regexp.Regexp
+
+type fortranLike struct {
+	name         string
+	suffix       string
+	comment      *regexp.Regexp
+	nocomment    *regexp.Regexp
+	continuation string // ends a continued line; if empty, fixed form (column 6 marks continuations)
+}
+
+var fortranLikes []fortranLike
+
+var neverInterestingByPrefix []string
+var neverInterestingByInfix []string
+var neverInterestingBySuffix map[string]bool
+var neverInterestingByBasename map[string]bool
+
+// defaultMarkers - the phrases marking generated code, as one regexp
+var defaultMarkers string
+
+// Minified and packed files are recognized by a first line of at least
+// MinifiedLine bytes, by names such as .min.js, or, for JavaScript and
+// CSS, by long average lines with next to no comments.  Running them
+// through the state machines is slow and their counts mean little, so
+// their nonblank lines go under "minified".
+var minifiedSuffixes = []string{".min.js", ".min.css", ".bundle.js", ".bundle.css"}
+var webAssetSuffixes = []string{".js", ".mjs", ".cjs", ".css"}
+
+// With SkipDisabled, C-family code between #if 0 and its #else, #elif
+// or #endif is left out of SLOC and reported as "disabled".
+var disabledStart = regexp.MustCompile(`^[ \t]*#[ \t]*if[ \t]+0[ \t]*(?:$|/[*/])`)
+var conditionalStart = regexp.MustCompile(`^[ \t]*#[ \t]*if`)
+var conditionalElse = regexp.MustCompile(`^[ \t]*#[ \t]*(?:else|elif)\b`)
+var conditionalEnd = regexp.MustCompile(`^[ \t]*#[ \t]*endif\b`)
+
+// SQLDialect says whether SQL is read as MySQL, with its # comments
+// and /*! conditional comments; otherwise mysqlTells decide file by
+// file.
+var mysqlTells = []string{
+	`^[ \t]*#`,
+	`/\*!`,
+	`(?i)^[ \t]*delimiter[ \t]`,
+	`(?i)\b(?:engine|auto_increment)\b`,
+	"`[A-Za-z_][A-Za-z_0-9]*`",
+}
+
+// Syntax flags
+const nf = 0x00        // no flags
+const eolwarn = 0x01   // Warn on EOL in string
+const cbs = 0x02       // C-style backslash escapes
+const gotick = 0x04    // Strong backtick a la Go
+const cpp = 0x08       // Count C preprocessor directives or Objective C #import
+const asm = 0x10       // Assembler syntax: handle multiple winged-comment types
+const mstring = 0x20   // Triple-quote string literals (not implemented)
+const lexyacc = 0x40   // Lex or yacc: %% sections with C embedded
+const cnest = 0x80     // Block comments nest
+const mysql = 0x100    // MySQL: # comments, /*! conditional comments are code
+const literate = 0x200 // Literate source: only indented blocks are code
+
+const assemblerLeaders = ";#*" // Intel, GAS, IBM
+
+func init() {
+	// For speed, try to put more common languages and extensions
+	// earlier in this list.
+	//
+	// Verifiers are expensive, so try to put extensions that need them
+	// after extensions that don't. But remember that you don't pay the
+	// overhead for a verifier unless the extension matches.
+	//
+	// If you have multiple entries for an extension, (a) all the entries
+	// with verifiers should go first, and (b) there should be at most one
+	// entry without a verifier (because any second and later ones will be
+	// pre-empted by it).  Run loccount -s to check this property; if it's
+	// violated you get a message on stderr.
+	//
+	// All entries for a given language should be in a contiguous span,
+	// otherwise the primitive duplicate director in listLanguages will
+	// be foiled.
+	//
+	// See https://en.wikipedia.org/wiki/Comparison_of_programming_languages_(syntax)
+	genericLanguages = []genericLanguage{
+		/* C family */
+		{name: "c", suffix: ".c", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", continuation: "\\"},
+		{name: "c-header", suffix: ".h", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", continuation: "\\"},
+		{name: "yacc", suffix: ".y", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp | lexyacc, terminator: ";", continuation: "\\"},
+		{name: "lex", suffix: ".l", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp | lexyacc, terminator: ";", verifier: reallyLex, continuation: "\\"},
+		{name: "c++", suffix: ".cpp", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", rawstrings: cppRawStrings, continuation: "\\"},
+		{name: "c++", suffix: ".cxx", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", rawstrings: cppRawStrings, continuation: "\\"},
+		{name: "c++", suffix: ".cc", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", rawstrings: cppRawStrings, continuation: "\\"},
+		{name: "c++", suffix: ".hpp", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", rawstrings: cppRawStrings, continuation: "\\"},
+		{name: "c++", suffix: ".hxx", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", rawstrings: cppRawStrings, continuation: "\\"},
+		{name: "arduino", suffix: ".ino", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", rawstrings: cppRawStrings, continuation: "\\"},
+		{name: "java", suffix: ".java", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", rawstrings: textBlocks},
+		{name: "javascript", suffix: ".js", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs},
+		{name: "coffeescript", suffix: ".coffee", eolcomment: "#", flags: cbs, rawstrings: coffeeStrings},
+		{name: "coffeescript", suffix: ".litcoffee", eolcomment: "#", flags: cbs | literate, rawstrings: coffeeStrings},
+		{name: "objective-c", suffix: ".m", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", verifier: reallyObjectiveC, continuation: "\\"},
+		{name: "objective-c", suffix: ".mm", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cpp, terminator: ";", verifier: reallyObjectiveC, continuation: "\\"},
+		{name: "c#", suffix: ".cs", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", rawstrings: csharpRawStrings},
+		//{"html", ".html", "<!--", "-->", "", "", nf, "", nil},
+		//{"html", ".htm", "<!--", "-->", "", "", nf, "", nil},
+		//{"xml", ".xml", "<!--", "-->", "", "", nf, "", nil},
+		{name: "php", suffix: ".php", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", heredoc: phpHeredoc},
+		{name: "php3", suffix: ".php3", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", heredoc: phpHeredoc},
+		{name: "php4", suffix: ".php4", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", heredoc: phpHeredoc},
+		{name: "php5", suffix: ".php5", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", heredoc: phpHeredoc},
+		{name: "php6", suffix: ".php6", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", heredoc: phpHeredoc},
+		{name: "php7", suffix: ".php7", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", heredoc: phpHeredoc},
+		{name: "go", suffix: ".go", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", multistring: "`", flags: eolwarn | cbs | gotick},
+		{name: "hare", suffix: ".ha", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", rawstrings: backquoteStrings},
+		{name: "ballerina", suffix: ".bal", eolcomment: "//", flags: eolwarn | cbs, terminator: ";", rawstrings: backquoteStrings},
+		{name: "swift", suffix: ".swift", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cnest, rawstrings: swiftRawStrings},
+		{name: "sql", suffix: ".sql", commentleader: "/*", commenttrailer: "*/", eolcomment: "--"},
+		{name: "haskell", suffix: ".hs", commentleader: "{-", commenttrailer: "-}", eolcomment: "--", flags: eolwarn | cnest},
+		{name: "purescript", suffix: ".purs", commentleader: "{-", commenttrailer: "-}", eolcomment: "--", flags: eolwarn | cnest},
+		{name: "pl/1", suffix: ".pl1", commentleader: "/*", commenttrailer: "*/", flags: eolwarn, terminator: ";"},
+		/* everything else */
+		{name: "asm", suffix: ".asm", commentleader: "/*", commenttrailer: "*/", eolcomment: ";", flags: eolwarn | asm, terminator: "\n"},
+		{name: "asm", suffix: ".s", commentleader: "/*", commenttrailer: "*/", eolcomment: ";", flags: eolwarn | asm, terminator: "\n"},
+		{name: "asm", suffix: ".S", commentleader: "/*", commenttrailer: "*/", eolcomment: ";", flags: eolwarn | asm, terminator: "\n"},
+		{name: "ada", suffix: ".ada", eolcomment: "--", flags: eolwarn, terminator: ";"},
+		{name: "ada", suffix: ".adb", eolcomment: "--", flags: eolwarn, terminator: ";"},
+		{name: "ada", suffix: ".ads", eolcomment: "--", flags: eolwarn, terminator: ";"},
+		{name: "ada", suffix: ".pad", eolcomment: "--", flags: eolwarn}, // Oracle Ada preprocessoer.
+		{name: "css", suffix: ".css", commentleader: "/*", commenttrailer: "*/", flags: eolwarn},
+		{name: "delphi-form", suffix: ".dfm", verifier: reallyTextForm},
+		{name: "delphi-form", suffix: ".lfm", verifier: reallyTextForm},
+		{name: "zsh", suffix: "zshrc", eolcomment: "#", flags: 0, heredoc: shellHeredoc},
+		{name: "makefile", suffix: ".mk", eolcomment: "#", flags: eolwarn},
+		{name: "makefile", suffix: "Makefile", eolcomment: "#", flags: eolwarn},
+		{name: "makefile", suffix: "makefile", eolcomment: "#", flags: eolwarn},
+		{name: "makefile", suffix: "Imakefile", eolcomment: "#", flags: eolwarn},
+		{name: "makefile", suffix: ".mak", eolcomment: "#", flags: eolwarn},
+		{name: "makefile", suffix: ".make", eolcomment: "#", flags: eolwarn},
+		{name: "makefile", suffix: "Kbuild", eolcomment: "#", flags: eolwarn},
+		{name: "m4", suffix: ".m4", eolcomment: "#", flags: eolwarn},
+		{name: "lisp", suffix: ".lisp", commentleader: "#|", commenttrailer: "|#", eolcomment: ";", flags: eolwarn},
+		{name: "lisp", suffix: ".lsp", commentleader: "#|", commenttrailer: "|#", eolcomment: ";", flags: eolwarn}, // XLISP
+		{name: "lisp", suffix: ".cl", commentleader: "#|", commenttrailer: "|#", eolcomment: ";", flags: eolwarn},  // Common Lisp
+		{name: "lisp", suffix: ".l", commentleader: "#|", commenttrailer: "|#", eolcomment: ";", flags: eolwarn},
+		{name: "scheme", suffix: ".scm", eolcomment: ";", flags: eolwarn},
+		{name: "elisp", suffix: ".el", eolcomment: ";", flags: eolwarn},    // Emacs Lisp
+		{name: "clojure", suffix: ".clj", eolcomment: ";", flags: eolwarn}, // Clojure
+		{name: "clojure", suffix: ".cljc", eolcomment: ";", flags: eolwarn},
+		{name: "clojurescript", suffix: ".cljs", eolcomment: ";", flags: eolwarn},
+		{name: "fennel", suffix: ".fnl", eolcomment: ";", flags: eolwarn},
+		{name: "janet", suffix: ".janet", eolcomment: "#", rawstrings: janetLongStrings},
+		{name: "cobol", suffix: ".CBL", eolcomment: "*", flags: eolwarn},
+		{name: "cobol", suffix: ".cbl", eolcomment: "*", flags: eolwarn},
+		{name: "cobol", suffix: ".COB", eolcomment: "*", flags: eolwarn},
+		{name: "cobol", suffix: ".cob", eolcomment: "*", flags: eolwarn},
+		{name: "eiffel", suffix: ".e", eolcomment: "--", flags: eolwarn, rawstrings: eiffelVerbatimStrings},
+		{name: "sather", suffix: ".sa", eolcomment: "--", flags: eolwarn, terminator: ";", verifier: reallySather},
+		{name: "lua", suffix: ".lua", commentleader: "--[[", commenttrailer: "]]", eolcomment: "--", flags: eolwarn},
+		{name: "clu", suffix: ".clu", eolcomment: "%", flags: eolwarn, terminator: ";"},
+		{name: "rust", suffix: ".rs", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cnest, terminator: ";", rawstrings: rustRawStrings},
+		{name: "rust", suffix: ".rlib", eolcomment: "//", flags: eolwarn, terminator: ";"},
+		{name: "reason", suffix: ".re", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn, terminator: ";", verifier: reallyReason},
+		{name: "reason", suffix: ".rei", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn, terminator: ";"},
+		{name: "rescript", suffix: ".res", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn},
+		{name: "rescript", suffix: ".resi", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn},
+		{name: "wolfram", suffix: ".wl", commentleader: "(*", commenttrailer: "*)", flags: cnest},
+		{name: "wolfram", suffix: ".wls", commentleader: "(*", commenttrailer: "*)", flags: cnest},
+		{name: "modelica", suffix: ".mo", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: cbs, terminator: ";", verifier: reallyModelica},
+		{name: "erlang", suffix: ".erl", eolcomment: "%", flags: eolwarn},
+		{name: "postscript", suffix: ".ps", eolcomment: "%"},
+		{name: "postscript", suffix: ".eps", eolcomment: "%"},
+		{name: "vhdl", suffix: ".vhdl", eolcomment: "--"},
+		{name: "v", suffix: ".v", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cnest, verifier: reallyV},
+		{name: "coq", suffix: ".v", commentleader: "(*", commenttrailer: "*)", flags: cnest, terminator: ".", verifier: reallyCoq},
+		{name: "verilog", suffix: ".v", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn, terminator: ";"},
+		{name: "verilog", suffix: ".vh", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn, terminator: ";"},
+		//{"turing", ".t", "", "", "%", "", eolwarn, "", nil},
+		{name: "d", suffix: ".d", commentleader: "/+", commenttrailer: "+/", eolcomment: "//", flags: eolwarn | cnest, terminator: ";"},
+		{name: "pony", suffix: ".pony", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: cbs | cnest, rawstrings: ponyDocstrings},
+		{name: "chapel", suffix: ".chpl", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn | cbs | cnest, terminator: ";"},
+		{name: "occam", suffix: ".f", eolcomment: "//", flags: eolwarn, verifier: reallyOccam},
+		{name: "f#", suffix: ".fs", eolcomment: "//", flags: eolwarn},
+		{name: "f#", suffix: ".fsi", eolcomment: "//", flags: eolwarn},
+		{name: "f#", suffix: ".fsx", eolcomment: "//", flags: eolwarn},
+		{name: "f#", suffix: ".fscript", eolcomment: "//", flags: eolwarn},
+		{name: "kotlin", suffix: ".kt", eolcomment: "//", flags: eolwarn},
+		{name: "dart", suffix: ".dart", eolcomment: "//", flags: eolwarn, terminator: ";"},
+		{name: "vb", suffix: ".vb", eolcomment: "'", terminator: "\n", continuation: " _"},
+		{name: "vb", suffix: ".bas", eolcomment: "'", terminator: "\n", continuation: " _"},
+		{name: "julia", suffix: ".jl", commentleader: "#=", commenttrailer: "=#", eolcomment: "#", flags: eolwarn | cbs | mstring | cnest},
+		{name: "nim", suffix: ".nim", commentleader: "#[", commenttrailer: "]#", eolcomment: "#", flags: eolwarn | cbs | mstring | cnest},
+		{name: "prolog", suffix: ".pl", eolcomment: "%", flags: eolwarn, terminator: ".", verifier: reallyProlog},
+		{name: "wolfram", suffix: ".m", commentleader: "(*", commenttrailer: "*)", flags: cnest, verifier: reallyWolfram},
+		{name: "mercury", suffix: ".m", commentleader: "/*", commenttrailer: "*/", eolcomment: "%", flags: eolwarn, terminator: ".", verifier: reallyMercury},
+		{name: "matlab", suffix: ".m", commentleader: "%{", commenttrailer: "%}", eolcomment: "%", flags: eolwarn | cnest, verifier: reallyMatlab},
+		//{"mumps", ".m", "", "", ";", "", eolwarn, "", nil},	// See obj-c
+		{name: "mumps", suffix: ".mps", eolcomment: ";", flags: eolwarn},
+		{name: "mumps", suffix: ".m", eolcomment: ";", flags: eolwarn},
+		{name: "pop11", suffix: ".p", eolcomment: ";", flags: eolwarn, verifier: reallyPOP11},
+		{name: "red", suffix: ".r", eolcomment: ";", verifier: reallyRed, rawstrings: redStrings},
+		{name: "rebol", suffix: ".r", eolcomment: "comment"},
+		{name: "red", suffix: ".red", eolcomment: ";", rawstrings: redStrings},
+		{name: "red", suffix: ".reds", eolcomment: ";", rawstrings: redStrings}, // Red/System
+		{name: "simula", suffix: ".sim", eolcomment: "comment", terminator: ";"},
+		{name: "icon", suffix: ".icn", eolcomment: "#"},
+		{name: "cobra", suffix: ".cobra", commentleader: "/#", commenttrailer: "#/", eolcomment: "#", flags: eolwarn | cbs},
+		{name: "algol60", suffix: ".alg", eolcomment: "COMMENT", multistring: `"""`, terminator: ";"},
+		{name: "vrml", suffix: ".wrl", eolcomment: "#", flags: eolwarn},
+		// autoconf cruft
+		{name: "autotools", suffix: "config.h.in", commentleader: "/*", commenttrailer: "*/", eolcomment: "//", flags: eolwarn},
+		{name: "autotools", suffix: "autogen.sh", eolcomment: "#", flags: eolwarn},
+		{name: "autotools", suffix: "configure.in", eolcomment: "#", flags: eolwarn},
+		{name: "autotools", suffix: "Makefile.in", eolcomment: "#", flags: eolwarn},
+		{name: "autotools", suffix: ".am", eolcomment: "#", flags: eolwarn},
+		{name: "autotools", suffix: ".ac", eolcomment: "#", flags: eolwarn},
+		{name: "autotools", suffix: ".mf", eolcomment: "#", flags: eolwarn},
+		// Scons
+	}
+
+	var err error
+	dtriple, err = regexp.Compile(dt + "." + dt)
+	if err != nil {
+		panic(err)
+	}
+	striple, err = regexp.Compile(st + "." + st)
+	if err != nil {
+		panic(err)
+	}
+	dlonely, err = regexp.Compile("^[ \t]*\"[^\"]+\"")
+	if err != nil {
+		panic(err)
+	}
+	slonely, err = regexp.Compile("^[ \t]*'[^']+'")
+	if err != nil {
+		panic(err)
+	}
+	strailer, err = regexp.Compile(".*" + st)
+	if err != nil {
+		panic(err)
+	}
+	dtrailer, err = regexp.Compile(".*" + dt)
+	if err != nil {
+		panic(err)
+	}
+
+	scriptingLanguages = []scriptingLanguage{
+		{"tcl", ".tcl", "tcl", nil, nil}, /* before sh, because tclsh */
+		{"tcl", ".tcl", "wish", nil, nil},
+		{"csh", ".csh", "csh", nil, shellHeredoc},
+		{"zsh", ".zsh", "zsh", nil, shellHeredoc}, /* before sh, as with csh */
+		{"fish", ".fish", "fish", nil, nil},
+		{"shell", ".sh", "sh", nil, shellHeredoc},
+		{"ruby", ".rb", "ruby", nil, rubyHeredoc},
+		{"awk", ".awk", "awk", nil, nil},
+		{"sed", ".sed", "sed", nil, nil},
+		{"expect", ".exp", "expect", reallyExpect, nil},
+	}
+	pascalLikes = []pascalLike{
+		{"pascal", ".pas", true, false, ";", nil},
+		{"pascal", ".dpr", true, false, ";", nil}, // Delphi program
+		{"pascal", ".lpr", true, false, ";", nil}, // Lazarus program
+		{"pascal", ".dpk", true, false, ";", nil}, // Delphi package
+		{"pascal", ".p", true, false, ";", reallyPascal},
+		{"pascal", ".inc", true, false, ";", reallyPascal},
+		{"modula", ".mod", false, true, ";", nil},
+		{"modula2", ".i2", false, true, ";", nil},
+		{"modula2", ".m2", false, true, ";", nil},
+		{"modula3", ".i3", false, true, ";", nil},
+		{"modula3", ".m3", false, true, ";", nil},
+		{"modula3", ".ig", false, true, ";", nil},
+		{"modula3", ".mg", false, true, ";", nil},
+		{"ml", ".ml", false, true, "", nil}, // Could be CAML or OCAML
+		{"ml", ".mli", false, true, "", nil},
+		{"ml", ".mll", false, true, "", nil},
+		{"ml", ".mly", false, true, "", nil},
+		{"sml", ".sml", false, true, "", nil},
+		{"oberon", ".ob", false, true, ";", nil},
+		{"oberon2", ".ob2", false, true, ";", nil},
+	}
+
+	var ferr error
+	f90comment, ferr := regexp.Compile("^([ \t]*!|[ \t]*$)")
+	if ferr != nil {
+		panic("unexpected failure while building f90 comment analyzer")
+	}
+	f90nocomment, ferr := regexp.Compile("^[ \t]*!(hpf|omp)[$]")
+	if ferr != nil {
+		panic("unexpected failure while building f90 no-comment analyzer")
+	}
+	f77comment, ferr := regexp.Compile("^([c*!]|[ \t]+!|[ \t]*$)")
+	if ferr != nil {
+		panic("unexpected failure while building f77 comment analyzer")
+	}
+	f77nocomment, ferr := regexp.Compile("^[c*!](hpf|omp)[$]")
+	if ferr != nil {
+		panic("unexpected failure while building f77 nocomment analyzer")
+	}
+	fortranLikes = []fortranLike{
+		{"fortran90", ".f90", f90comment, f90nocomment, "&"},
+		{"fortran95", ".f95", f90comment, f90nocomment, "&"},
+		{"fortran03", ".f03", f90comment, f90nocomment, "&"},
+		{"fortran", ".f77", f77comment, f77nocomment, ""},
+		{"fortran", ".f", f77comment, f77nocomment, ""},
+	}
+
+	var perr error
+	podheader, perr = regexp.Compile("^=[a-zA-Z]")
+	if perr != nil {
+		panic(perr)
+	}
+
+	neverInterestingByPrefix = []string{"."}
+	neverInterestingByInfix = []string{".so.", "/."}
+	ignoreSuffixes := []string{"~",
+		".a", ".la", ".o", ".so", ".ko",
+		".gif", ".jpg", ".jpeg", ".ico", ".xpm", ".xbm", ".bmp",
+		".ps", ".pdf", ".eps",
+		".tfm", ".ttf", ".bdf", ".afm",
+		".fig", ".pic",
+		".pyc", ".pyo", ".elc",
+		".1", ".2", ".3", ".4", ".5", ".6", ".7", ".8", ".n", ".man",
+		".html", ".htm", ".sgml", ".xml",
+		".adoc", "md", ".txt", ".tex", ".texi",
+		".po",
+		".gz", ".bz2", ".Z", ".tgz", ".zip",
+		".au", ".wav", ".ogg",
+	}
+	neverInterestingBySuffix = make(map[string]bool)
+	for i := range ignoreSuffixes {
+		neverInterestingBySuffix[ignoreSuffixes[i]] = true
+	}
+	neverInterestingByBasename = map[string]bool{
+		"readme": true, "readme.tk": true, "readme.md": true,
+		"changelog": true, "repository": true, "changes": true,
+		"bugs": true, "todo": true, "copying": true, "maintainers": true,
+		"news":      true,
+		"configure": true, "autom4te.cache": true, "config.log": true,
+		"config.status": true,
+		"lex.yy.c":      true, "lex.yy.cc": true,
+		"y.code.c": true, "y.tab.c": true, "y.tab.h": true,
+	}
+	defaultMarkers = strings.Join([]string{
+		"automatically generated", "generated automatically",
+		"generated by", "a lexical scanner generated by flex",
+		"this is a generated file", "generated with the.*utility",
+		"do not edit", "do not hand-hack",
+		// Phabricator and Rust tooling, .NET designers, and
+		// generators that describe the file rather than themselves.
+		// Bare "auto-generated" is too common in prose to use.
+		"@generated", "<auto-generated",
+		"this (?:file|module) (?:is|was) (?:auto-?|automatically |machine[- ])?generated",
+		"machine[- ]generated",
+	}, "|")
+
+}
+
+// User-defined language definitions.  These are read from a file in a
+// small subset of TOML: one [[generic]], [[scripting]], [[pascal]], or
+// [[fortran]] table per language, with string, boolean, and
+// string-array values, e.g.
+//
+//	[[generic]]
+//	name = "mydsl"
+//	extensions = [".dsl"]
+//	block_comment = ["/*", "*/"]
+//	line_comment = "//"
+//	terminator = ";"
+//	flags = ["eolwarn", "cbs"]
+//
+// Entries read this way take precedence over the built-in ones, and
+// replace any built-in entries for the same extensions.
+
+type langdef struct {
+	kind   string
+	line   int
+	fields map[string]interface{}
+	index  int // place among the tables of its kind, in a JSON file
+}
+
+var syntaxFlags = map[string]uint{
+	"eolwarn":  eolwarn,
+	"cbs":      cbs,
+	"gotick":   gotick,
+	"cpp":      cpp,
+	"asm":      asm,
+	"mstring":  mstring,
+	"cnest":    cnest,
+	"lexyacc":  lexyacc,
+	"mysql":    mysql,
+	"literate": literate,
+}
+
+// tomlValue - parse the right-hand side of a key = value line
+func tomlValue(text string) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "true":
+		return true, nil
+	case text == "false":
+		return false, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("unterminated string %s", text)
+		}
+		return text[1 : len(text)-1], nil
+	case strings.HasPrefix(text, "\""):
+		return strconv.Unquote(text)
+	case strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]"):
+		var items []string
+		rest := strings.TrimSpace(text[1 : len(text)-1])
+		for rest != "" {
+			var item string
+			if rest[0] == '\'' {
+				end := strings.IndexByte(rest[1:], '\'')
+				if end == -1 {
+					return nil, fmt.Errorf("unterminated string in %s", text)
+				}
+				item, rest = rest[1:end+1], rest[end+2:]
+			} else if rest[0] == '"' {
+				quoted, err := strconv.QuotedPrefix(rest)
+				if err != nil {
+					return nil, err
+				}
+				item, _ = strconv.Unquote(quoted)
+				rest = rest[len(quoted):]
+			} else {
+				return nil, fmt.Errorf("array items must be strings in %s", text)
+			}
+			items = append(items, item)
+			rest = strings.TrimSpace(rest)
+			rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unsupported value %s", text)
+}
+
+// parseLangdefs - read a language-definition file
+func parseLangdefs(path string) ([]langdef, error) {
+	if strings.HasSuffix(path, ".json") {
+		return parseJSONLangdefs(path)
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var defs []langdef
+	scanner := bufio.NewScanner(fp)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[[") && strings.HasSuffix(line, "]]") {
+			kind := strings.TrimSpace(line[2 : len(line)-2])
+			defs = append(defs, langdef{kind, lineno, make(map[string]interface{}), 0})
+			continue
+		}
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 || len(defs) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected key = value inside a table", path, lineno)
+		}
+		value, err := tomlValue(line[eq+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineno, err)
+		}
+		defs[len(defs)-1].fields[strings.TrimSpace(line[:eq])] = value
+	}
+	return defs, scanner.Err()
+}
+
+// parseJSONLangdefs - read a language-definition file written as JSON,
+// an object whose keys are the table names of the TOML form, each with
+// an array of tables
+func parseJSONLangdefs(path string) ([]langdef, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tables map[string][]map[string]interface{}
+	if err := encjson.Unmarshal(text, &tables); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	var kinds []string
+	for kind := range tables {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	var defs []langdef
+	for _, kind := range kinds {
+		for i, table := range tables[kind] {
+			d := langdef{kind, 0, make(map[string]interface{}), i + 1}
+			for key, value := range table {
+				switch v := value.(type) {
+				case string, bool:
+					d.fields[key] = v
+				case []interface{}:
+					var items []string
+					for _, item := range v {
+						s, ok := item.(string)
+						if !ok {
+							return nil, fmt.Errorf("%s: array items must be strings in %s", d.where(path), key)
+						}
+						items = append(items, s)
+					}
+					d.fields[key] = items
+				default:
+					return nil, fmt.Errorf("%s: unsupported value for %s", d.where(path), key)
+				}
+			}
+			defs = append(defs, d)
+		}
+	}
+	return defs, nil
+}
+
+// where - the place of a table in its file, for messages
+func (d langdef) where(path string) string {
+	if d.line == 0 {
+		return fmt.Sprintf("%s: %s entry %d", path, d.kind, d.index)
+	}
+	return fmt.Sprintf("%s:%d", path, d.line)
+}
+
+func (d langdef) str(key string) string {
+	s, _ := d.fields[key].(string)
+	return s
+}
+
+func (d langdef) strs(key string) []string {
+	if s, ok := d.fields[key].(string); ok {
+		return []string{s}
+	}
+	l, _ := d.fields[key].([]string)
+	return l
+}
+
+// forgetSuffix - drop built-in entries for an extension being redefined
+func forgetSuffix(suffix string) {
+	var g []genericLanguage
+	for _, lang := range genericLanguages {
+		if lang.suffix != suffix {
+			g = append(g, lang)
+		}
+	}
+	genericLanguages = g
+	var s []scriptingLanguage
+	for _, lang := range scriptingLanguages {
+		if lang.suffix != suffix {
+			s = append(s, lang)
+		}
+	}
+	scriptingLanguages = s
+	var p []pascalLike
+	for _, lang := range pascalLikes {
+		if lang.suffix != suffix {
+			p = append(p, lang)
+		}
+	}
+	pascalLikes = p
+	var f []fortranLike
+	for _, lang := range fortranLikes {
+		if lang.suffix != suffix {
+			f = append(f, lang)
+		}
+	}
+	fortranLikes = f
+}
+
+// LoadLangdefs merges the languages of a definition file into the
+// tables, which every count shares, and adds the file's generated-code
+// markers and path rules to o.  The tables must not be changed while
+// anything is being counted.
+func (o *Options) LoadLangdefs(path string) error {
+	defs, err := parseLangdefs(path)
+	if err != nil {
+		return err
+	}
+	var generics []genericLanguage
+	var scriptings []scriptingLanguage
+	var pascals []pascalLike
+	var fortrans []fortranLike
+	var paths []langdef
+	for _, d := range defs {
+		if d.kind == "generated" {
+			replace, _ := d.fields["replace"].(bool)
+			if err := o.AddGeneratedMarkers(d.strs("markers"), replace); err != nil {
+				return fmt.Errorf("%s: %v", d.where(path), err)
+			}
+			continue
+		}
+		if d.kind == "path" {
+			// Added once the file's languages are known
+			paths = append(paths, d)
+			continue
+		}
+		name := d.str("name")
+		extensions := d.strs("extensions")
+		if name == "" || len(extensions) == 0 {
+			return fmt.Errorf("%s: %s entry needs a name and extensions", d.where(path), d.kind)
+		}
+		for _, ext := range extensions {
+			forgetSuffix(ext)
+		}
+		switch d.kind {
+		case "generic":
+			var flags uint
+			for _, f := range d.strs("flags") {
+				v, ok := syntaxFlags[f]
+				if !ok {
+					return fmt.Errorf("%s: unknown syntax flag %s", d.where(path), f)
+				}
+				flags |= v
+			}
+			block := d.strs("block_comment")
+			if len(block) != 0 && (len(block) != 2 || len(block[0]) < 2 || len(block[1]) < 2) {
+				return fmt.Errorf("%s: block_comment needs a leader and trailer of at least two characters", d.where(path))
+			}
+			if len(block) == 0 {
+				block = []string{"", ""}
+			}
+			var raw []rawString
+			for _, key := range []string{"raw_strings", "doc_strings"} {
+				delimiters := d.strs(key)
+				if len(delimiters)%2 != 0 {
+					return fmt.Errorf("%s: %s needs pairs of opening and closing delimiters", d.where(path), key)
+				}
+				for i := 0; i < len(delimiters); i += 2 {
+					if delimiters[i] == "" || delimiters[i+1] == "" {
+						return fmt.Errorf("%s: %s delimiters can't be empty", d.where(path), key)
+					}
+					raw = append(raw, rawString{open: delimiters[i], close: delimiters[i+1], doc: key == "doc_strings"})
+				}
+			}
+			for _, ext := range extensions {
+				generics = append(generics, genericLanguage{
+					name:           name,
+					suffix:         ext,
+					commentleader:  block[0],
+					commenttrailer: block[1],
+					eolcomment:     d.str("line_comment"),
+					multistring:    d.str("multistring"),
+					flags:          flags,
+					terminator:     d.str("terminator"),
+					rawstrings:     raw,
+					continuation:   d.str("continuation"),
+				})
+			}
+		case "scripting":
+			hashbang := d.str("hashbang")
+			if hashbang == "" {
+				hashbang = name
+			}
+			for _, ext := range extensions {
+				scriptings = append(scriptings, scriptingLanguage{name, ext, hashbang, nil, nil})
+			}
+		case "pascal":
+			brackets, _ := d.fields["bracket_comments"].(bool)
+			nested, _ := d.fields["nested_comments"].(bool)
+			for _, ext := range extensions {
+				pascals = append(pascals, pascalLike{name, ext, brackets, nested, d.str("terminator"), nil})
+			}
+		case "fortran":
+			comment, err := regexp.Compile(d.str("comment"))
+			if err != nil {
+				return fmt.Errorf("%s: %v", d.where(path), err)
+			}
+			nocomment, err := regexp.Compile(d.str("nocomment"))
+			if err != nil {
+				return fmt.Errorf("%s: %v", d.where(path), err)
+			}
+			if d.str("nocomment") == "" {
+				nocomment = regexp.MustCompile("$^")
+			}
+			for _, ext := range extensions {
+				fortrans = append(fortrans, fortranLike{name, ext, comment, nocomment, d.str("continuation")})
+			}
+		default:
+			return fmt.Errorf("%s: unknown language class %s", d.where(path), d.kind)
+		}
+	}
+	genericLanguages = append(generics, genericLanguages...)
+	scriptingLanguages = append(scriptings, scriptingLanguages...)
+	pascalLikes = append(pascals, pascalLikes...)
+	fortranLikes = append(fortrans, fortranLikes...)
+	for _, d := range paths {
+		if err := o.AddPathRule(d.str("glob") + ":" + d.str("language")); err != nil {
+			return fmt.Errorf("%s: %v", d.where(path), err)
+		}
+	}
+	return nil
+}
+
+// AddGeneratedMarkers adds regexps marking generated code to those
+// looked for, or with replace, puts them in place of the built-in ones.
+func (o *Options) AddGeneratedMarkers(markers []string, replace bool) error {
+	for _, marker := range markers {
+		if _, err := regexp.Compile(marker); err != nil {
+			return fmt.Errorf("bad generated-code marker %s: %v", marker, err)
+		}
+	}
+	if !o.markersSet {
+		o.generated, o.markersSet = defaultMarkers, true
+	}
+	if replace {
+		o.generated = ""
+	}
+	for _, marker := range markers {
+		if o.generated != "" {
+			o.generated += "|"
+		}
+		o.generated += "(?:" + marker + ")"
+	}
+	return nil
+}
+
+// Forced extension-to-language mappings from --force-lang.  Python and
+// Perl have dedicated counters rather than table entries, so forcing
+// an extension to one of them is recorded separately.
+
+var forcedLanguages = make(map[string]string)
+
+// dedicatedSuffixes - extensions of the languages with dedicated counters
+var dedicatedSuffixes = map[string]string{
+	".py": "python",
+	".pl": "perl",
+	".pm": "perl",
+	".ph": "perl",
+}
+
+// dedicatedMatch - is a file Python or Perl by its extension or, given a
+// context, its hashbang line?  An extension named by --force-lang is
+// settled by that alone.
+func dedicatedMatch(ctx *countContext, path string, name string) bool {
+	ext := filepath.Ext(path)
+	if forced, ok := forcedLanguages[ext]; ok {
+		return forced == name
+	}
+	if dedicatedSuffixes[ext] == name {
+		return true
+	}
+	return ctx != nil && hashbang(ctx, path, name)
+}
+
+// ForceLanguage takes a mapping ext:language, as --force-lang does, and
+// makes the named language's counter handle files with that extension
+// in every count.  Like LoadLangdefs, it must not be called while
+// anything is being counted.
+func ForceLanguage(mapping string) error {
+	colon := strings.LastIndexByte(mapping, ':')
+	if colon == -1 {
+		return fmt.Errorf("--force-lang wants ext:language, not %s", mapping)
+	}
+	ext, name := mapping[:colon], mapping[colon+1:]
+	if ext == "" || name == "" {
+		return fmt.Errorf("--force-lang wants ext:language, not %s", mapping)
+	}
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	var generic *genericLanguage
+	for i := range genericLanguages {
+		if genericLanguages[i].name == name {
+			generic = &genericLanguages[i]
+			break
+		}
+	}
+	var scripting *scriptingLanguage
+	for i := range scriptingLanguages {
+		if scriptingLanguages[i].name == name {
+			scripting = &scriptingLanguages[i]
+			break
+		}
+	}
+	var pascal *pascalLike
+	for i := range pascalLikes {
+		if pascalLikes[i].name == name {
+			pascal = &pascalLikes[i]
+			break
+		}
+	}
+	var fortran *fortranLike
+	for i := range fortranLikes {
+		if fortranLikes[i].name == name {
+			fortran = &fortranLikes[i]
+			break
+		}
+	}
+
+	switch {
+	case generic != nil:
+		lang := *generic
+		forgetSuffix(ext)
+		lang.suffix, lang.verifier = ext, nil
+		genericLanguages = append([]genericLanguage{lang}, genericLanguages...)
+	case scripting != nil:
+		lang := *scripting
+		forgetSuffix(ext)
+		lang.suffix, lang.verifier = ext, nil
+		scriptingLanguages = append([]scriptingLanguage{lang}, scriptingLanguages...)
+	case pascal != nil:
+		lang := *pascal
+		forgetSuffix(ext)
+		lang.suffix, lang.verifier = ext, nil
+		pascalLikes = append([]pascalLike{lang}, pascalLikes...)
+	case fortran != nil:
+		lang := *fortran
+		forgetSuffix(ext)
+		lang.suffix = ext
+		fortranLikes = append([]fortranLike{lang}, fortranLikes...)
+	case name == "python" || name == "perl":
+		forgetSuffix(ext)
+	default:
+		return fmt.Errorf("--force-lang: unknown language %s", name)
+	}
+	forcedLanguages[ext] = name
+	return nil
+}
+
+// Source files are read through the Source of the Options.  The
+// command line reads the operating system's files; other hosts, such as
+// a browser running the WebAssembly build, can set it to any fs.FS.
+// osFS differs from os.DirFS in accepting absolute paths and paths with
+// "..", as users type them on the command line.
+
+type osFS struct {
+	root string // directory paths are relative to, if not empty
+}
+
+func (o osFS) path(name string) string {
+	if o.root == "" {
+		return name
+	}
+	return filepath.Join(o.root, name)
+}
+
+func (o osFS) Open(name string) (fs.File, error) {
+	return os.Open(o.path(name))
+}
+
+func (o osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(o.path(name))
+}
+
+func (o osFS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(o.path(name))
+}
+
+func (o osFS) ReadLink(name string) (string, error) {
+	return os.Readlink(o.path(name))
+}
+
+func (o osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(o.path(name))
+}
+
+// subSource - the file source rooted at a directory of another
+func subSource(fsys fs.FS, dir string) (fs.FS, error) {
+	if o, ok := fsys.(osFS); ok {
+		return osFS{o.path(dir)}, nil
+	}
+	return fs.Sub(fsys, dir)
+}
+
+// lstat - stat a path without following a final symlink
+func (r *run) lstat(path string) (fs.FileInfo, error) {
+	if rl, ok := r.source.(fs.ReadLinkFS); ok {
+		return rl.Lstat(path)
+	}
+	return fs.Stat(r.source, path)
+}
+
+// sourcePath - the operating-system path of a source path, as far as
+// there is one
+func (r *run) sourcePath(path string) string {
+	if o, ok := r.source.(osFS); ok {
+		return o.path(path)
+	}
+	return path
+}
+
+// A Revision serves the files of one git revision from the repository's
+// object store, so revisions can be counted without checking them out.
+// The tree is listed up front; blobs are read on demand through a
+// single "git cat-file --batch" process.  Set as the Source of Options,
+// it is counted from ".".
+type Revision struct {
+	entries map[string]*gitEntry
+	dirs    map[string][]fs.DirEntry
+	objects *gitObjects
+}
+
+type gitEntry struct {
+	name   string
+	mode   fs.FileMode
+	size   int64
+	object string
+}
+
+func (e *gitEntry) Name() string               { return e.name }
+func (e *gitEntry) Size() int64                { return e.size }
+func (e *gitEntry) Mode() fs.FileMode          { return e.mode }
+func (e *gitEntry) ModTime() time.Time         { return time.Time{} }
+func (e *gitEntry) IsDir() bool                { return e.mode.IsDir() }
+func (e *gitEntry) Sys() interface{}           { return nil }
+func (e *gitEntry) Type() fs.FileMode          { return e.mode.Type() }
+func (e *gitEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+// gitFile is an open blob or directory of a Revision
+type gitFile struct {
+	*bytes.Reader
+	entry *gitEntry
+}
+
+func (f gitFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f gitFile) Close() error               { return nil }
+
+type gitObjects struct {
+	lock sync.Mutex
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+	out  *bufio.Reader
+}
+
+// OpenRevision lists the tree of a revision in the repository at repo.
+// The Revision must be closed when done with.
+func OpenRevision(repo string, rev string) (*Revision, error) {
+	listing, err := exec.Command("git", "-C", repo, "ls-tree", "-r", "-z", "--long", rev).Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("%s", bytes.TrimSpace(exit.Stderr))
+		}
+		return nil, fmt.Errorf("git ls-tree %s: %v", rev, err)
+	}
+	src := &Revision{
+		entries: map[string]*gitEntry{".": {name: ".", mode: fs.ModeDir | 0755}},
+		dirs:    map[string][]fs.DirEntry{},
+	}
+	for _, record := range bytes.Split(listing, []byte{0}) {
+		// <mode> SP <type> SP <object> SP+ <size> TAB <path>
+		tab := bytes.IndexByte(record, '\t')
+		if tab == -1 {
+			continue
+		}
+		fields := strings.Fields(string(record[:tab]))
+		if len(fields) != 4 || fields[1] != "blob" {
+			continue // submodules
+		}
+		size, _ := strconv.ParseInt(fields[3], 10, 64)
+		var mode fs.FileMode = 0644
+		if fields[0] == "100755" {
+			mode = 0755
+		} else if fields[0] == "120000" {
+			mode = fs.ModeSymlink | 0777
+		}
+		src.add(string(record[tab+1:]), &gitEntry{mode: mode, size: size, object: fields[2]})
+	}
+	cmd := exec.Command("git", "-C", repo, "cat-file", "--batch")
+	objects := &gitObjects{cmd: cmd}
+	if objects.in, err = cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	objects.out = bufio.NewReader(out)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	src.objects = objects
+	return src, nil
+}
+
+// add - enter a file, and any directories above it, into the listing
+func (g *Revision) add(path string, entry *gitEntry) {
+	for {
+		entry.name = filepath.Base(path)
+		g.entries[path] = entry
+		dir := filepath.Dir(path)
+		g.dirs[dir] = append(g.dirs[dir], entry)
+		if _, ok := g.entries[dir]; ok {
+			return
+		}
+		path, entry = dir, &gitEntry{mode: fs.ModeDir | 0755}
+	}
+}
+
+func (g *Revision) Stat(name string) (fs.FileInfo, error) {
+	entry, ok := g.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return entry, nil
+}
+
+func (g *Revision) ReadDir(name string) ([]fs.DirEntry, error) {
+	if entry, ok := g.entries[name]; !ok || !entry.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := g.dirs[name]
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (g *Revision) Open(name string) (fs.File, error) {
+	entry, ok := g.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.IsDir() {
+		return gitFile{bytes.NewReader(nil), entry}, nil
+	}
+	content, err := g.objects.read(entry.object)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return gitFile{bytes.NewReader(content), entry}, nil
+}
+
+// Close stops the blob reader.
+func (g *Revision) Close() error {
+	g.objects.in.Close()
+	return g.objects.cmd.Wait()
+}
+
+// read - fetch the contents of a blob
+func (o *gitObjects) read(object string) ([]byte, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if _, err := fmt.Fprintln(o.in, object); err != nil {
+		return nil, err
+	}
+	header, err := o.out.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	// <object> SP <type> SP <size> LF <contents> LF
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("git cat-file: %s", strings.TrimSpace(header))
+	}
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, size+1)
+	if _, err := io.ReadFull(o.out, content); err != nil {
+		return nil, err
+	}
+	return content[:size], nil
+}
+
+// Generic machinery for walking source text to count lines
+
+const stateNORMAL = 0        // in running text
+const stateINSTRING = 1      // in single-line string
+const stateINMULTISTRING = 2 // in multi-line string
+const stateINCOMMENT = 3     // in comment
+const stateINRAWSTRING = 4   // in raw or verbatim string
+
+// countContext is state corresoding to a single source file
+type countContext struct {
+	line       []byte
+	lineNumber uint
+	nonblank   bool   // Is current line nonblank?
+	wasNewline bool   // Was the last character seen a newline?
+	source     string // Path the buffered text was read from
+	text       []byte // Decoded file contents, shared by every pass
+	pos        int    // Scan position in text
+	unmap      func() // Releases text if it is memory-mapped
+	buf        []byte // Read buffer, kept for the next file
+	passed     string // Why a classifier passed the file over
+	nocode     string // Language whose counter found no code
+	run        *run   // The run the file is counted for
+
+	// Closed when the run is cancelled, so it stops inside a huge
+	// file too
+	done <-chan struct{}
+}
+
+// Contexts and their read buffers are recycled between files, so
+// counting a huge tree doesn't churn the garbage collector.
+var contextPool = sync.Pool{New: func() interface{} { return new(countContext) }}
+
+// newContext - get a fresh countContext
+func (r *run) newContext() *countContext {
+	ctx := contextPool.Get().(*countContext)
+	ctx.lineNumber, ctx.nonblank, ctx.wasNewline = 0, false, false
+	ctx.passed, ctx.nocode = "", ""
+	ctx.run, ctx.done = r, r.done
+	return ctx
+}
+
+// cancelled - has the run been cancelled?
+func (r *run) cancelled() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopped - has the run been cancelled?  If so, the rest of the text is
+// skipped, so counters finish at once with what they have.
+func (ctx *countContext) stopped() bool {
+	select {
+	case <-ctx.done:
+		ctx.pos = len(ctx.text)
+		return true
+	default:
+		return false
+	}
+}
+
+// recycle - give a countContext back when done with it
+func (ctx *countContext) recycle() {
+	ctx.release()
+	// Don't pin down the buffer of an outsized file
+	if cap(ctx.buf) > int(mmapThreshold) {
+		ctx.buf = nil
+	}
+	ctx.run = nil
+	contextPool.Put(ctx)
+}
+
+// Files at least this large are memory-mapped instead of read in,
+// where the platform allows it.  Generated sources of tens of
+// megabytes are not unusual.
+var mmapThreshold int64 = 4 << 20
+
+// readSource - get the raw contents of a source file, reading into
+// buf if it is big enough
+func (r *run) readSource(path string, buf []byte) ([]byte, func(), error) {
+	r.openFile()
+	defer r.closeFile()
+	if o, ok := r.source.(osFS); ok {
+		fi, err := o.Stat(path)
+		if err == nil && fi.Mode().IsRegular() && fi.Size() >= mmapThreshold {
+			if data, unmap, err := mapFile(o.path(path)); err == nil {
+				if r.Debug > 0 {
+					fmt.Fprintf(os.Stderr, "%s: memory-mapped\n", path)
+				}
+				return data, unmap, nil
+			}
+		}
+	}
+	f, err := r.source.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	raw := buf[:0]
+	if fi, err := f.Stat(); err == nil && int64(cap(raw)) <= fi.Size() {
+		raw = make([]byte, 0, fi.Size()+512)
+	}
+	for {
+		if len(raw) == cap(raw) {
+			raw = append(raw, 0)[:len(raw)]
+		}
+		n, err := f.Read(raw[len(raw):cap(raw)])
+		raw = raw[:len(raw)+n]
+		if err == io.EOF {
+			return raw, nil, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// setup - rewind to the start of a file.  The file is read and decoded
+// only once; verifiers, the generated-code check, hashbang sniffing and
+// the counter all rescan the same buffer.
+func (ctx *countContext) setup(path string) bool {
+	ctx.lineNumber = 1
+	ctx.wasNewline = false
+	ctx.pos = 0
+	if ctx.text == nil || ctx.source != path {
+		ctx.release()
+		raw, unmap, err := ctx.run.readSource(path, ctx.buf)
+		if err != nil {
+			log.Println(err)
+			return false
+		}
+		if unmap == nil {
+			ctx.buf = raw
+		}
+		enc, bom := detectEncoding(raw, ctx.run.encoding)
+		text := raw[bom:]
+		if enc != "utf-8" {
+			if ctx.run.Debug > 0 {
+				fmt.Fprintf(os.Stderr, "%s: transcoding from %s\n", path, enc)
+			}
+			text = transcode(text, enc)
+		}
+		text = NormalizeEOL(text, unmap == nil)
+		ctx.text, ctx.source, ctx.unmap = text, path, unmap
+	}
+	return true
+}
+
+func (ctx *countContext) teardown() {
+	ctx.pos = len(ctx.text)
+}
+
+// release - drop the buffered file, unmapping it if need be
+func (ctx *countContext) release() {
+	if ctx.unmap != nil {
+		ctx.unmap()
+	}
+	ctx.text, ctx.source, ctx.unmap, ctx.line = nil, "", nil, nil
+}
+
+// consume - conditionally consume an expected byte sequence
+func (ctx *countContext) consume(expect []byte) bool {
+	if ctx.run.Debug > 1 {
+		fmt.Fprintf(os.Stderr, "consume: %q\n", expect)
+	}
+	if len(expect) == 0 {
+		return true
+	}
+	if ctx.run.Debug > 1 {
+		end := ctx.pos + len(expect)
+		if end > len(ctx.text) {
+			end = len(ctx.text)
+		}
+		fmt.Fprintf(os.Stderr, "consume saw: %q\n", ctx.text[ctx.pos:end])
+	}
+	if bytes.HasPrefix(ctx.text[ctx.pos:], expect) {
+		ctx.pos += len(expect)
+		return true
+	}
+	return false
+}
+
+func (ctx *countContext) ispeek(c byte) bool {
+	return ctx.pos < len(ctx.text) && ctx.text[ctx.pos] == c
+}
+
+// getachar - Get one character, tracking line number
+func (ctx *countContext) getachar() (byte, error) {
+	var c byte
+	var err error
+	if ctx.pos < len(ctx.text) {
+		c = ctx.text[ctx.pos]
+		ctx.pos++
+	} else {
+		err = io.EOF
+	}
+	if ctx.wasNewline {
+		ctx.lineNumber++
+	}
+	ctx.wasNewline = c == '\n'
+	if ctx.wasNewline {
+		ctx.stopped()
+	}
+	return c, err
+}
+
+// skipuntil - advance over a run of bytes none of which is in stops,
+// without going through getachar for each one.  Reports whether any
+// of the skipped bytes was non-whitespace.
+func (ctx *countContext) skipuntil(stops string) bool {
+	rest := ctx.text[ctx.pos:]
+	n := bytes.IndexAny(rest, stops)
+	if n == -1 {
+		n = len(rest)
+	}
+	if n == 0 {
+		return false
+	}
+	if ctx.wasNewline {
+		ctx.lineNumber++
+	}
+	ctx.wasNewline = false
+	ctx.pos += n
+	return len(bytes.TrimSpace(rest[:n])) > 0
+}
+
+// rawstring - if one of the given raw-string forms begins with the
+// character just read, consume its introducer and return the form
+// with the terminator to look for.
+func (ctx *countContext) rawstring(forms []rawString) (rawString, bool) {
+	start := ctx.text[ctx.pos-1:]
+	for _, form := range forms {
+		if !bytes.HasPrefix(start, []byte(form.open)) {
+			continue
+		}
+		n := len(form.open)
+		closing := form.close
+		if form.hashes {
+			hashes := strings.Count(form.open, "#")
+			for n < len(start) && start[n] == '#' {
+				hashes++
+				n++
+			}
+			if n == len(start) || start[n] != '"' {
+				continue
+			}
+			n++
+			closing += strings.Repeat("#", hashes)
+		} else if form.fence != "" {
+			i := bytes.IndexByte(start[n:], form.fence[0])
+			// The standard caps delimiters at 16 characters
+			if i == -1 || i > 16 || bytes.ContainsAny(start[n:n+i], " \t\n\\)") {
+				continue
+			}
+			closing = form.fence[1:2] + string(start[n:n+i]) + closing
+			n += i + 1
+		}
+		for i := 1; i < n; i++ {
+			ctx.getachar()
+		}
+		form.close = closing
+		return form, true
+	}
+	return rawString{}, false
+}
+
+// heredocIntroducer - if a here-document introducer begins with the
+// character just read, consume it and set the terminator to look for
+func (ctx *countContext) heredocIntroducer(h *heredoc, terminator *string) bool {
+	rest := ctx.text[ctx.pos-1:]
+	if i := bytes.IndexByte(rest, '\n'); i > -1 {
+		rest = rest[:i]
+	}
+	m := h.introducer.FindSubmatchIndex(rest)
+	if m == nil || m[0] != 0 {
+		return false
+	}
+	*terminator = h.opens(rest[:m[1]])
+	for i := 1; i < m[1]; i++ {
+		ctx.getachar()
+	}
+	return true
+}
+
+// skipdisabled - if an #if 0 region starts here, consume it through
+// its #else, #elif or #endif and return how many nonblank lines it had
+func (ctx *countContext) skipdisabled() uint {
+	var nonblank uint
+	depth := 0
+	for ctx.pos < len(ctx.text) {
+		rest := ctx.text[ctx.pos:]
+		end := len(rest)
+		if i := bytes.IndexByte(rest, '\n'); i > -1 {
+			end = i + 1
+		}
+		line := bytes.TrimRight(rest[:end], "\r\n")
+		if depth == 0 {
+			if !disabledStart.Match(line) {
+				return 0
+			}
+			depth = 1
+		} else if conditionalStart.Match(line) {
+			depth++
+		} else if conditionalEnd.Match(line) {
+			depth--
+		} else if depth == 1 && conditionalElse.Match(line) {
+			depth = 0
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			nonblank++
+		}
+		ctx.pos += end
+		ctx.lineNumber++
+		if depth == 0 {
+			break
+		}
+	}
+	return nonblank
+}
+
+// skipheredoc - consume the text of a here-document, stopping before
+// its terminating line, and return how many nonblank lines it held
+func (ctx *countContext) skipheredoc(h *heredoc, terminator string) uint {
+	var nonblank uint
+	for ctx.pos < len(ctx.text) {
+		rest := ctx.text[ctx.pos:]
+		end := len(rest)
+		if i := bytes.IndexByte(rest, '\n'); i > -1 {
+			end = i + 1
+		}
+		if h.closes(rest[:end], terminator) {
+			break
+		}
+		if len(bytes.TrimSpace(rest[:end])) > 0 {
+			nonblank++
+		}
+		ctx.pos += end
+		ctx.lineNumber++
+	}
+	return nonblank
+}
+
+// readline - return the next line, including its newline.  Like
+// bufio's ReadBytes, the error is io.EOF if no newline ended the line.
+func (ctx *countContext) readline() ([]byte, error) {
+	if ctx.stopped() {
+		return nil, io.EOF
+	}
+	rest := ctx.text[ctx.pos:]
+	if i := bytes.IndexByte(rest, '\n'); i > -1 {
+		ctx.pos += i + 1
+		return rest[:i+1], nil
+	}
+	ctx.pos = len(ctx.text)
+	return rest, io.EOF
+}
+
+// Consume the remainder of a line, updating the line counter
+func (ctx *countContext) munchline() bool {
+	line, err := ctx.readline()
+	if err != nil {
+		return false
+	}
+	ctx.lineNumber++
+	ctx.line = line
+	return true
+}
+
+// Verifiers and the generated-file check match the same handful of
+// patterns against every file, so compile each one once and keep it.
+// Every line a verifier reads looks its pattern up, from all the
+// counting workers at once, so lookups take no lock.
+var compiledRegexps sync.Map // pattern -> *regexp.Regexp
+
+func compiled(pattern string) *regexp.Regexp {
+	if cre, ok := compiledRegexps.Load(pattern); ok {
+		return cre.(*regexp.Regexp)
+	}
+	cre, err := regexp.Compile(pattern)
+	if err != nil {
+		panic(fmt.Sprintf("unexpected failure %s while compiling %s", err, pattern))
+	}
+	// A worker that lost a race to compile it uses the winner's
+	actual, _ := compiledRegexps.LoadOrStore(pattern, cre)
+	return actual.(*regexp.Regexp)
+}
+
+// Consume the remainder of a line, updating the line counter
+func (ctx *countContext) drop(excise string) bool {
+	return compiled(excise).ReplaceAllLiteral(ctx.line, []byte("")) != nil
+}
+
+// matchline - does a given regexp match the last line read?
+func (ctx *countContext) matchline(re string) bool {
+	return compiled(re).Find(ctx.line) != nil
+}
+
+// Source encodings.  The scanners are byte-oriented and only care about
+// ASCII delimiters, so UTF-8 and single-byte encodings like Latin-1 are
+// safe as they stand.  UTF-16 has to be decoded, and the double-byte
+// CJK encodings can hide delimiter bytes in the second byte of a
+// character, so those characters get masked out.
+
+var encodingAliases = map[string]string{
+	"auto":       "auto",
+	"utf-8":      "utf-8",
+	"utf8":       "utf-8",
+	"ascii":      "utf-8",
+	"latin-1":    "latin-1",
+	"latin1":     "latin-1",
+	"iso-8859-1": "latin-1",
+	"utf-16le":   "utf-16le",
+	"utf-16be":   "utf-16be",
+	"shift-jis":  "shift-jis",
+	"shift_jis":  "shift-jis",
+	"sjis":       "shift-jis",
+	"cp932":      "shift-jis",
+	"euc-jp":     "euc",
+	"euc-kr":     "euc",
+	"gb2312":     "euc",
+	"gbk":        "gbk",
+	"cp936":      "gbk",
+	"big5":       "gbk",
+}
+
+// SetEncoding - read the sources as being in the named encoding, or
+// "auto" to work it out file by file
+func (o *Options) SetEncoding(name string) error {
+	enc, ok := encodingAliases[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown encoding %s", name)
+	}
+	if enc == "auto" {
+		enc = ""
+	}
+	o.encoding = enc
+	return nil
+}
+
+// Bytes at the start of a file that encoding detection looks at
+const encodingWindow = 4096
+
+// detectEncoding - work out the encoding of a file from its text,
+// returning the length of any byte-order mark to skip as well.
+func detectEncoding(text []byte, encoding string) (string, int) {
+	if bytes.HasPrefix(text, []byte{0xef, 0xbb, 0xbf}) {
+		return "utf-8", 3
+	} else if bytes.HasPrefix(text, []byte{0xff, 0xfe}) {
+		return "utf-16le", 2
+	} else if bytes.HasPrefix(text, []byte{0xfe, 0xff}) {
+		return "utf-16be", 2
+	}
+	if encoding != "" {
+		return encoding, 0
+	}
+	head := text
+	if len(head) > encodingWindow {
+		head = head[:encodingWindow]
+		// Don't let a character split at the window's end spoil the test
+		if i := bytes.LastIndexByte(head, '\n'); i > -1 {
+			head = head[:i]
+		}
+	}
+	if utf8.Valid(head) {
+		return "utf-8", 0
+	}
+	for i := 0; i < len(head); i++ {
+		if head[i] < 0x80 {
+			continue
+		} else if isSJISLead(head[i]) && i+1 < len(head) && isSJISTrail(head[i+1]) {
+			i++
+		} else if head[i] >= 0xa1 && head[i] <= 0xdf {
+			continue // half-width katakana
+		} else {
+			return "latin-1", 0
+		}
+	}
+	return "shift-jis", 0
+}
+
+func isSJISLead(c byte) bool {
+	return (c >= 0x81 && c <= 0x9f) || (c >= 0xe0 && c <= 0xfc)
+}
+
+func isSJISTrail(c byte) bool {
+	return c >= 0x40 && c <= 0xfc && c != 0x7f
+}
+
+// transcode - make source text in a given encoding safe for scanning
+func transcode(text []byte, enc string) []byte {
+	switch enc {
+	case "utf-16le", "utf-16be":
+		units := make([]uint16, len(text)/2)
+		for i := range units {
+			if enc == "utf-16le" {
+				units[i] = uint16(text[2*i]) | uint16(text[2*i+1])<<8
+			} else {
+				units[i] = uint16(text[2*i])<<8 | uint16(text[2*i+1])
+			}
+		}
+		var out bytes.Buffer
+		for _, r := range utf16.Decode(units) {
+			out.WriteRune(r)
+		}
+		return out.Bytes()
+	case "shift-jis", "gbk":
+		// Mask each double-byte character so its trail byte
+		// can't be mistaken for a quote or backslash.
+		out := make([]byte, 0, len(text))
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			lead := (enc == "shift-jis" && isSJISLead(c)) || (enc == "gbk" && c >= 0x81 && c <= 0xfe)
+			if lead && i+1 < len(text) && text[i+1] >= 0x40 && text[i+1] != 0x7f {
+				out = append(out, '?', '?')
+				i++
+			} else {
+				out = append(out, c)
+			}
+		}
+		return out
+	}
+	// Latin-1 and the EUC family never put ASCII bytes inside a character.
+	return text
+}
+
+// NormalizeEOL - turn CR-LF and lone CR line endings into LF, so that
+// every scanner sees the same lines whatever convention the file was
+// saved in.  Text that is memory-mapped is copied rather than written.
+func NormalizeEOL(text []byte, inplace bool) []byte {
+	i := bytes.IndexByte(text, '\r')
+	if i == -1 {
+		return text
+	}
+	out := text[:i]
+	if !inplace {
+		out = make([]byte, i, len(text))
+		copy(out, text[:i])
+	}
+	for ; i < len(text); i++ {
+		c := text[i]
+		if c == '\r' {
+			if i+1 < len(text) && text[i+1] == '\n' {
+				continue
+			}
+			c = '\n'
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// Diagnostics about malformed source go through here, so they can be
+// silenced and counted, or collected for machine-readable output.
+
+// Warning is a problem found while counting a file.
+type Warning struct {
+	File     string `json:"file"`
+	Line     uint   `json:"line"`
+	Kind     string `json:"kind"`
+	Language string `json:"language"`
+	Message  string `json:"-"` // For people, with the file and line
+}
+
+// warn - report a problem found while scanning a file
+func (r *run) warn(path string, language string, line uint, kind string, message string) {
+	if r.Warn != nil {
+		r.Warn(Warning{path, line, kind, language, message})
+		return
+	}
+	fmt.Fprintln(os.Stderr, message)
+}
+
+func isspace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f'
+}
+
+// blank - is c, the byte just read, the start of a whitespace character?
+// Multibyte characters are decoded and, if blank, consumed whole, so an
+// ideographic space or no-break space doesn't make a line count.
+func (ctx *countContext) blank(c byte) bool {
+	if c < utf8.RuneSelf {
+		return isspace(c)
+	}
+	r, size := utf8.DecodeRune(ctx.text[ctx.pos-1:])
+	if unicode.IsSpace(r) {
+		ctx.pos += size - 1
+		return true
+	}
+	return false
+}
+
+// Verifier functions for checking that files with disputed extensions
+// are actually of the types we think they are.
+//
+// A verifier looks at lines through scanLines, which stops as soon as
+// the verdict is certain, or after VerifyLines lines if that is set, so
+// no verifier reads further into a file than it must.
+
+// scanLines - hand the lines of a file, in ctx.line, to look until it
+// returns false or VerifyLines lines have been seen.
+func scanLines(ctx *countContext, path string, look func() bool) {
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	for n := 0; ctx.munchline(); n++ {
+		if ctx.run.VerifyLines > 0 && n >= ctx.run.VerifyLines {
+			break
+		}
+		if !look() {
+			break
+		}
+	}
+}
+
+// verdict - report a verifier's decision when debugging, and return it
+func verdict(ctx *countContext, lang string, path string, ok bool) bool {
+	if ctx.run.Debug > 0 {
+		fmt.Fprintf(os.Stderr, "%s verifier returned %t on %s\n", lang, ok, path)
+	}
+	return ok
+}
+
+// reallyObjectiveC - returns true if filename contents really are objective-C.
+func reallyObjectiveC(ctx *countContext, path string) bool {
+	special := false // Did we find a special Objective-C pattern?
+	isObjC := false  // Value to determine.
+	braceLines := 0  // Lines that begin/end with curly braces.
+	plusMinus := 0   // Lines that begin with + or -.
+	wordMain := 0    // Did we find "main("?
+
+	scanLines(ctx, path, func() bool {
+		if ctx.matchline("^\\s*[{}]") || ctx.matchline("[{}];?\\s*") {
+			braceLines++
+		}
+		if ctx.matchline("^\\s*[+-]") {
+			plusMinus++
+		}
+		if ctx.matchline("\\bmain\\s*\\(") { // "main" followed by "("?
+			wordMain++
+		}
+		// Handle /usr/src/redhat/BUILD/egcs-1.1.2/gcc/objc/linking.m:
+		if ctx.matchline("(?i)^\\s*\\[object name\\];\\s*") {
+			special = true
+		}
+
+		if (braceLines > 1) && ((plusMinus > 1) || wordMain > 0 || special) {
+			isObjC = true
+		}
+		return !isObjC
+	})
+
+	return verdict(ctx, "objc", path, isObjC)
+}
+
+func hasKeywords(ctx *countContext, path string, lang string, tells []string) bool {
+	matching := false // Value to determine.
+
+	scanLines(ctx, path, func() bool {
+		for i := range tells {
+			if ctx.matchline(tells[i]) {
+				matching = true
+				break
+			}
+		}
+		return !matching
+	})
+
+	return verdict(ctx, lang, path, matching)
+}
+
+// isMySQL - returns TRUE if SQL is to be read as MySQL.
+func isMySQL(ctx *countContext, path string) bool {
+	if d := ctx.run.SQLDialect; d == "mysql" || d == "ansi" {
+		return d == "mysql"
+	}
+	return hasKeywords(ctx, path, "mysql", mysqlTells)
+}
+
+// reallyOccam - returns TRUE if filename contents really are occam.
+func reallyOccam(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "occam", []string{"--", "PROC"})
+}
+
+// reallyLex - returns TRUE if filename contents really are lex.
+func reallyLex(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "lex", []string{"%{", "%%", "%}"})
+}
+
+// reallyReason - returns TRUE if filename contents really are Reason.
+// re2c scanner sources, which are C, use the same extension.
+func reallyReason(ctx *countContext, path string) bool {
+	return !hasKeywords(ctx, path, "re2c", []string{`/\*!re2c`, `^#include`})
+}
+
+// reallyPOP11 - returns TRUE if filename contents really are pop11.
+func reallyPOP11(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "pop11", []string{"define", "printf"})
+}
+
+// reallySather - returns TRUE if filename contents really are sather.
+func reallySather(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "sather", []string{"class"})
+}
+
+// reallyModelica - returns TRUE if filename contents really are Modelica.
+// Compiled gettext message catalogs use the same extension.
+func reallyModelica(ctx *countContext, path string) bool {
+	if ctx.setup(path) {
+		catalog := bytes.HasPrefix(ctx.text, []byte{0xde, 0x12, 0x04, 0x95}) ||
+			bytes.HasPrefix(ctx.text, []byte{0x95, 0x04, 0x12, 0xde})
+		ctx.teardown()
+		if catalog {
+			return false
+		}
+	}
+	return hasKeywords(ctx, path, "modelica", []string{
+		`^\s*within\b`,
+		`^\s*(?:(?:partial|encapsulated|replaceable)\s+)*(?:model|package|connector|block|record|function|class|type)\s+\w`,
+	})
+}
+
+// reallyWolfram - returns TRUE if filename contents really are Wolfram
+// Language.  MATLAB's check would take any file with "end" in it.
+func reallyWolfram(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "wolfram", []string{
+		`^\(\* ::Package:: \*\)`,
+		`\bBeginPackage\["`,
+		`^\w+\[(?:\w+_\w*,\s*)*\w+_\w*\]\s*:=`,
+	})
+}
+
+// reallyMercury - returns TRUE if filename contents really are Mercury.
+// This has to go before MATLAB, whose check would see end_module.
+func reallyMercury(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "mercury", []string{
+		`^:-\s*(?:module|interface|implementation|import_module|use_module|pred|func|type|mode)\b`,
+	})
+}
+
+// reallyV - returns TRUE if filename contents really are V.
+// A V module header has neither ports nor a semicolon, unlike Verilog's.
+func reallyV(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "v", []string{
+		`^module\s+\w+\s*$`,
+		`^(?:pub\s+)?fn\s+(?:\([^)]*\)\s*)?\w+\s*\(`,
+		`^import\s+[\w.]+\s*(?:\{.*\})?\s*$`,
+	})
+}
+
+// reallyCoq - returns TRUE if filename contents really are Coq.
+func reallyCoq(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "coq", []string{
+		`^\s*(?:Theorem|Lemma|Proof|Qed|Inductive|Fixpoint)\b`,
+		`^\s*(?:Require|From)\s.*\bImport\b`,
+	})
+}
+
+// reallyRed - returns TRUE if filename contents really are Red.
+// Without this check, Red would be taken for Rebol, whose header
+// it borrows.
+func reallyRed(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "red", []string{`^\s*Red(?:/System)?\s*\[`})
+}
+
+// reallyTextForm - returns TRUE if a Delphi or Lazarus form is stored
+// as text.  Binary forms are not source.
+func reallyTextForm(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "delphi-form", []string{`^(?:object|inherited|inline)\s+\w+\s*:\s*\w+`})
+}
+
+// reallyMatlab - returns TRUE if filename contents really are MATLAB.
+// We have to disambiguate against MUMPS and Objective-C
+func reallyMatlab(ctx *countContext, path string) bool {
+	return hasKeywords(ctx, path, "matlab", []string{"end"})
+}
+
+// reallyProlog - returns TRUE if filename contents really are prolog.
+// Without this check, Perl files will be falsely identified.
+func reallyProlog(ctx *countContext, path string) bool {
+	isProlog := true
+
+	scanLines(ctx, path, func() bool {
+		if bytes.HasPrefix(ctx.line, []byte("#")) {
+			isProlog = false
+		} else if ctx.matchline("\\$[[:alpha]]") {
+			isProlog = false
+		}
+		return isProlog
+	})
+
+	return isProlog
+}
+
+// reallyExpect - filename, returns true if its contents really are Expect.
+//
+// dwheeler had this to say:
+//
+// Many "exp" files (such as in Apache and Mesa) are just "export" data,
+// summarizing something else (e.g., its interface).
+// Sometimes (like in RPM) it's just misc. data.
+// Thus, we need to look at the file to determine
+// if it's really an "expect" file.
+// The heuristic is as follows: it's Expect _IF_ it:
+// 1. has "load_lib" command and either "#" comments or {}.
+// 2. {, }, and one of: proc, if, [...], expect
+func reallyExpect(ctx *countContext, path string) bool {
+	var isExpect = false // Value to determine.
+
+	var beginBrace bool // Lines that begin with curly braces.
+	var endBrace bool   // Lines that begin with curly braces.
+	var loadLib bool    // Lines with the LoadLib command.
+	var foundProc bool
+	var foundIf bool
+	var foundBrackets bool
+	var foundExpect bool
+	var foundPound bool
+
+	scanLines(ctx, path, func() bool {
+		if ctx.matchline("#") {
+			foundPound = true
+			// Delete trailing comments
+			i := bytes.IndexByte(ctx.line, '#')
+			if i > -1 {
+				ctx.line = ctx.line[:i]
+			}
+		}
+
+		if ctx.matchline("^\\s*\\{") {
+			beginBrace = true
+		}
+		if ctx.matchline("\\{\\s*$") {
+			beginBrace = true
+		}
+		if ctx.matchline("^\\s*}") {
+			endBrace = true
+		}
+		if ctx.matchline("};?\\s*$") {
+			endBrace = true
+		}
+		if ctx.matchline("^\\s*loadLib\\s+\\S") {
+			loadLib = true
+		}
+		if ctx.matchline("^\\s*proc\\s") {
+			foundProc = true
+		}
+		if ctx.matchline("^\\s*if\\s") {
+			foundIf = true
+		}
+		if ctx.matchline("\\[.*\\]") {
+			foundBrackets = true
+		}
+		if ctx.matchline("^\\s*expect\\s") {
+			foundExpect = true
+		}
+
+		if loadLib && (foundPound || (beginBrace && endBrace)) {
+			isExpect = true
+		}
+		if beginBrace && endBrace &&
+			(foundProc || foundIf || foundBrackets || foundExpect) {
+			isExpect = true
+		}
+		return !isExpect
+	})
+
+	return verdict(ctx, "expect", path, isExpect)
+}
+
+// reallyPascal - returns  true if filename contents really are Pascal.
+func reallyPascal(ctx *countContext, path string) bool {
+	//
+	// dwheeler had this to say:
+	//
+	// This isn't as obvious as it seems.
+	// Many ".p" files are Perl files
+	// (such as /usr/src/redhat/BUILD/ispell-3.1/dicts/czech/glob.p),
+	// others are C extractions
+	// (such as /usr/src/redhat/BUILD/linux/include/linux/umsdos_fs.p
+	// and some files in linuxconf).
+	// However, test files in "p2c" really are Pascal, for example.
+	//
+	// Note that /usr/src/redhat/BUILD/ucd-snmp-4.1.1/ov/bitmaps/UCD.20.p
+	// is actually C code.  The heuristics determine that they're not Pascal,
+	// but because it ends in ".p" it's not counted as C code either.
+	// I believe this is actually correct behavior, because frankly it
+	// looks like it's automatically generated (it's a bitmap expressed as code).
+	// Rather than guess otherwise, we don't include it in a list of
+	// source files.  Let's face it, someone who creates C files ending in ".p"
+	// and expects them to be counted by default as C files in SLOCCount needs
+	// their head examined.  I suggest examining their head
+	// with a sucker rod (see syslogd(8) for more on sucker rods).
+	//
+	// This heuristic counts as Pascal such files such as:
+	//  /usr/src/redhat/BUILD/teTeX-1.0/texk/web2c/tangleboot.p
+	// Which is hand-generated.  We don't count woven documents now anyway,
+	// so this is justifiable.
+
+	// The heuristic is as follows: it's Pascal _IF_ it has all of the following
+	// (ignoring {...} and (*...*) comments):
+	// 1. "^..program NAME" or "^..unit NAME",
+	// 2. "procedure", "function", "^..interface", or "^..implementation",
+	// 3. a "begin", and
+	// 4. it ends with "end.",
+	//
+	// Or it has all of the following:
+	// 1. "^..module NAME" and
+	// 2. it ends with "end.".
+	//
+	// Or it has all of the following:
+	// 1. "^..program NAME",
+	// 2. a "begin", and
+	// 3. it ends with "end.".
+	//
+	// The "end." requirements in particular filter out non-Pascal.
+	//
+	// Note (jgb): this does not detect Pascal main files in fpc, like
+	// fpc-1.0.4/api/test/testterminfo.pas, which does not have "program" in
+	// it
+	var isPascal bool // Value to determine.
+
+	var hasProgram bool
+	var hasUnit bool
+	var hasModule bool
+	var hasProcedureOrFunction bool
+	var hasBegin bool
+	var foundTerminatingEnd bool
+
+	scanLines(ctx, path, func() bool {
+		// Ignore {...} comments on this line; imperfect, but effective.
+		ctx.drop("\\{.*?\\}")
+		// Ignore (*...*) comments on this line; imperfect but effective.
+		ctx.drop("\\(\\*.*\\*\\)")
+
+		if ctx.matchline("(?i)\\bprogram\\s+[A-Za-z]") {
+			hasProgram = true
+		}
+		if ctx.matchline("(?i)\\bunit\\s+[A-Za-z]") {
+			hasUnit = true
+		}
+		if ctx.matchline("(?i)\\bmodule\\s+[A-Za-z]") {
+			hasModule = true
+		}
+		if ctx.matchline("(?i)\\bprocedure\\b") {
+			hasProcedureOrFunction = true
+		}
+		if ctx.matchline("(?i)\\bfunction\\b") {
+			hasProcedureOrFunction = true
+		}
+		if ctx.matchline("(?i)^\\s*interface\\s+") {
+			hasProcedureOrFunction = true
+		}
+		if ctx.matchline("(?i)^\\s*implementation\\s+") {
+			hasProcedureOrFunction = true
+		}
+		if ctx.matchline("(?i)\\bbegin\\b") {
+			hasBegin = true
+		}
+		// Originally dw said: "This heuristic fails if there
+		// are multi-line comments after "end."; I haven't
+		// seen that in real Pascal programs:"
+		// But jgb found there are a good quantity of them in
+		// Debian, specially in fpc (at the end of a lot of
+		// files there is a multiline comment with the
+		// changelog for the file).  Therefore, assume Pascal
+		// if "end." appears anywhere in the file.
+		if ctx.matchline("(?i)end\\.\\s*$") {
+			foundTerminatingEnd = true
+		}
+
+		// Every clue, once found, stays found, so we can stop
+		// as soon as they add up to Pascal.
+		isPascal = (((hasUnit || hasProgram) && hasProcedureOrFunction &&
+			hasBegin && foundTerminatingEnd) ||
+			(hasModule && foundTerminatingEnd) ||
+			(hasProgram && hasBegin && foundTerminatingEnd))
+		return !isPascal
+	})
+
+	return verdict(ctx, "pascal", path, isPascal)
+}
+
+func wasGeneratedAutomatically(ctx *countContext, path string, eolcomment string) bool {
+	// Determine if the file was generated automatically.
+	// Use a simple heuristic: check if first few lines have phrases like
+	// "generated automatically", "automatically generated", "Generated by",
+	// or "do not edit" as the first
+	// words in the line (after possible comment markers and spaces).
+	i := ctx.run.GeneratedLines // Look at first few lines.
+	if ctx.run.generated == "" {
+		return false
+	}
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	// Avoid blowing up if the comment leader is "*" (as in COBOL).
+	if eolcomment == "*" {
+		eolcomment = ""
+	} else {
+		eolcomment = "|" + eolcomment
+	}
+	cre := compiled("(\\*" + eolcomment + ").*(?i:" + ctx.run.generated + ")")
+
+	for ctx.munchline() && i > 0 {
+		//fmt.Fprintf(os.Stderr, "Matching %s against %s", ctx.line, re)
+		if cre.Find(ctx.line) != nil {
+			if ctx.run.Debug > 0 {
+				fmt.Fprintf(os.Stderr, "%s: is generated\n", path)
+			}
+			return true
+		}
+		i--
+	}
+
+	return false
+}
+
+// License scanning.  An SPDX-License-Identifier tag near the top of a
+// file is taken at its word; failing that, the opening lines of the
+// commonest license notices are recognized and given their SPDX names.
+
+// How far into a file to look for a license
+const licenseHead = 8192
+
+var spdxTag = regexp.MustCompile(`SPDX-License-Identifier:\s*([^\n]*)`)
+
+var licenseNotices = []struct {
+	license string
+	pattern *regexp.Regexp
+}{
+	{"Apache-2.0", regexp.MustCompile(`(?i)Licensed under the Apache License,?\s+Version 2\.0`)},
+	{"MIT", regexp.MustCompile(`(?i)Permission is hereby granted, free of charge, to any person obtaining`)},
+	{"BSD-3-Clause", regexp.MustCompile(`(?is)Redistribution and use in source and binary forms.*Neither the name`)},
+	{"BSD-2-Clause", regexp.MustCompile(`(?i)Redistribution and use in source and binary forms`)},
+	{"MPL-2.0", regexp.MustCompile(`(?i)Mozilla Public\s+License,?\s+v(?:ersion|\.)?\s*2\.0`)},
+	{"AGPL-3.0", regexp.MustCompile(`(?i)GNU Affero General\s+Public License`)},
+	{"LGPL-3.0", regexp.MustCompile(`(?is)GNU Lesser General\s+Public License.*version 3`)},
+	{"LGPL-2.1", regexp.MustCompile(`(?i)GNU (?:Lesser|Library) General\s+Public License`)},
+	{"GPL-3.0", regexp.MustCompile(`(?is)GNU General\s+Public License.*version 3`)},
+	{"GPL-2.0", regexp.MustCompile(`(?i)GNU General\s+Public License`)},
+}
+
+// licenseOf - the license a file declares, or ""
+func licenseOf(ctx *countContext, path string) string {
+	if !ctx.setup(path) {
+		return ""
+	}
+	defer ctx.teardown()
+	head := ctx.text
+	if len(head) > licenseHead {
+		head = head[:licenseHead]
+	}
+	if m := spdxTag.FindSubmatch(head); m != nil {
+		// Drop the closing of the comment the tag was in
+		tag := strings.TrimSpace(string(m[1]))
+		for _, closer := range []string{"*/", "-->", "*)", "-}", "\"\"\""} {
+			tag = strings.TrimSpace(strings.TrimSuffix(tag, closer))
+		}
+		return tag
+	}
+	for _, notice := range licenseNotices {
+		if notice.pattern.Match(head) {
+			return notice.license
+		}
+	}
+	return ""
+}
+
+// Identical files, such as vendored copies, are found by a digest of
+// their decoded contents.
+
+// tagDigest - label the code found in a file with a digest of the file
+func tagDigest(ctx *countContext, path string, stats []SourceStat) {
+	for i := range stats {
+		if stats[i].SLOC > 0 {
+			if !ctx.setup(path) {
+				return
+			}
+			sum := sha256.Sum256(ctx.text)
+			for j := range stats {
+				stats[j].Digest = hex.EncodeToString(sum[:])
+			}
+			return
+		}
+	}
+}
+
+// tagLicense - label the code found in a file with its license
+func tagLicense(ctx *countContext, path string, stats []SourceStat) {
+	for i := range stats {
+		if stats[i].SLOC > 0 {
+			license := licenseOf(ctx, path)
+			for j := range stats {
+				stats[j].License = license
+			}
+			return
+		}
+	}
+}
+
+// hashbang - hunt for a specified string in the first line of an executable
+func hashbang(ctx *countContext, path string, langname string) bool {
+	fi, err := fs.Stat(ctx.run.source, path)
+	// If it's not executable by somebody, don't read for hashbang
+	if err != nil || (fi.Mode()&01111) == 0 {
+		return false
+	}
+	ctx.setup(path)
+	defer ctx.teardown()
+	s, err := ctx.readline()
+	found := err == nil && bytes.HasPrefix(s, []byte("#!")) && bytes.Contains(s, []byte(langname))
+	ctx.run.explain("hashbang check for %s: %t", langname, found)
+	return found
+}
+
+// minified - does a file look like a minified or bundled asset rather
+// than something written by hand?  Returns the reason, or "" if not.
+// Without a context only the name is judged.
+func (r *run) minified(ctx *countContext, path string) string {
+	for _, suffix := range minifiedSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return "name ends in " + suffix
+		}
+	}
+	if ctx == nil || !ctx.setup(path) {
+		return ""
+	}
+	defer ctx.teardown()
+	first := ctx.text
+	if i := bytes.IndexByte(first, '\n'); i > -1 {
+		first = first[:i]
+	}
+	if r.MinifiedLine > 0 && len(first) >= r.MinifiedLine {
+		return fmt.Sprintf("first line is %d bytes or more", r.MinifiedLine)
+	}
+	if r.MinifiedAverage <= 0 || !hasAnySuffix(path, webAssetSuffixes) {
+		return ""
+	}
+	// Licence banners (/*! ... */) survive minification, so only
+	// ask that comments be a small part of the text.
+	var lines, commentBytes int
+	for text := ctx.text; len(text) > 0; {
+		line := text
+		if i := bytes.IndexByte(text, '\n'); i > -1 {
+			line, text = text[:i], text[i+1:]
+		} else {
+			text = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		lines++
+		if bytes.HasPrefix(line, []byte("//")) || bytes.HasPrefix(line, []byte("/*")) || line[0] == '*' {
+			commentBytes += len(line)
+		}
+	}
+	if lines > 0 && len(ctx.text)/lines >= r.MinifiedAverage && commentBytes*10 < len(ctx.text) {
+		return fmt.Sprintf("average line is %d bytes or more", r.MinifiedAverage)
+	}
+	return ""
+}
+
+// hasAnySuffix - does a path end with one of the given suffixes?
+func hasAnySuffix(path string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// countMinifiedLines - count a minified file's nonblank lines without
+// scanning for comments or strings
+func countMinifiedLines(ctx *countContext, path string) SourceStat {
+	stats := SourceStat{Path: path, Language: "minified"}
+	ctx.setup(path)
+	defer ctx.teardown()
+	for {
+		line, err := ctx.readline()
+		if len(bytes.TrimSpace(line)) > 0 {
+			stats.SLOC++
+		}
+		if err != nil {
+			break
+		}
+	}
+	return stats
+}
+
+// countEmptyFile - sort a file that holds no code by whether it has
+// anything but blank lines; the nonblank lines are all comments.
+func countEmptyFile(ctx *countContext, path string) SourceStat {
+	stats := countMinifiedLines(ctx, path)
+	if stats.SLOC > 0 {
+		stats.Language = "comment-only"
+	} else {
+		stats.Language = "blank-only"
+	}
+	return stats
+}
+
+// verified - run a table entry's verifier, if it has one
+func verified(ctx *countContext, path string, name string, verifier func(*countContext, string) bool) bool {
+	if verifier == nil {
+		return true
+	}
+	ok := verifier(ctx, path)
+	ctx.run.explain("%s verifier: %t", name, ok)
+	if !ok {
+		ctx.passed = "verifier"
+	}
+	return ok
+}
+
+// Classification tracing for --explain.
+
+func (r *run) explain(format string, args ...interface{}) {
+	if r.explaining != nil {
+		fmt.Fprintf(r.explaining, format+"\n", args...)
+	}
+}
+
+// Explain - show on w how a single path would be classified and counted
+func Explain(w io.Writer, path string, opts Options) {
+	r := newRun(context.Background(), opts)
+	r.explaining = w
+	if reason := r.rejectReason(path); reason != "" {
+		if reason == "exclusion" {
+			r.explain("excluded by -x %s", r.Exclude)
+		}
+		r.explain("decision: skipped by %s filter", reason)
+		return
+	}
+	r.explain("passed path filters")
+	for _, st := range r.countGeneric(path) {
+		if st.SLOC > 0 {
+			r.explain("decision: %s, SLOC=%d LLOC=%d", st.Language, st.SLOC, st.LLOC)
+		} else {
+			r.explain("decision: unclassified")
+		}
+	}
+}
+
+// cFamilyCounter - Count the SLOC in a C-family source file
+//
+// C++ headers get counted as C. This can only be fixed in postprocessing
+// by noticing that there are no files with a C extension in the tree.
+func cFamilyCounter(ctx *countContext, path string, syntax genericLanguage) []SourceStat {
+	/* Types of comments: */
+	const commentBLOCK = 0
+	const commentTRAILING = 1
+
+	var stats SourceStat
+	mode := stateNORMAL  /* stateNORMAL, stateINSTRING, stateINMULTISTRING, or stateINCOMMENT */
+	var commentType int  /* commentBLOCK or commentTRAILING */
+	var depth int        /* block comments open, where they nest */
+	var raw rawString    /* the raw string we are in */
+	var indoc bool       /* the raw string is documentation */
+	var heredoc string   /* terminator of a here-document begun on this line */
+	var indirective bool /* in a preprocessor directive, however many lines it spans */
+	var indefine bool    /* the directive is a #define */
+	var prev byte        /* the character before this one */
+	var startline uint
+
+	if syntax.property(lexyacc) {
+		return sectionCounter(ctx, path, syntax)
+	}
+	if syntax.property(literate) {
+		return literateCounter(ctx, path, syntax)
+	}
+	if !verified(ctx, path, syntax.name, syntax.verifier) {
+		return []SourceStat{stats}
+	}
+
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	stats.Path = path
+	stats.Language = syntax.name
+
+	// # at start of line - assume it's a cpp directive.  A directive
+	// is one logical line, continued lines and all, and so is the
+	// body of a #define: its terminators aren't statements of the
+	// file, whether it is written on one line or several.
+	directive := func() {
+		if syntax.property(cpp) && !indirective && ctx.consume([]byte("#")) {
+			indirective = true
+			indefine = bytes.HasPrefix(bytes.TrimLeft(ctx.text[ctx.pos:], " \t"), []byte("define"))
+			stats.LLOC++
+			if ctx.run.Debug > 1 {
+				fmt.Fprintf(os.Stderr, "cFamilyCounter: cpp lloc++\n")
+			}
+		}
+	}
+
+	var disabled uint
+	if ctx.run.SkipDisabled && syntax.property(cpp) {
+		disabled += ctx.skipdisabled()
+	}
+	directive()
+	for {
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
+		}
+
+		if ctx.run.Debug > 1 {
+			fmt.Fprintf(os.Stderr, "cFamilyCounter: top of loop %c\n", c)
+		}
+
+		if mode == stateNORMAL {
+			var isRaw bool
+			if syntax.rawstrings != nil {
+				raw, isRaw = ctx.rawstring(syntax.rawstrings)
+			}
+			if isRaw {
+				indoc = raw.doc && !ctx.nonblank
+				ctx.nonblank = !indoc
+				mode = stateINRAWSTRING
+				startline = ctx.lineNumber
+			} else if c == '<' && syntax.heredoc != nil && heredoc == "" && ctx.heredocIntroducer(syntax.heredoc, &heredoc) {
+				ctx.nonblank = true
+			} else if c == '"' {
+				ctx.nonblank = true
+				mode = stateINSTRING
+				startline = ctx.lineNumber
+			} else if syntax.property(cbs) && c == '\'' {
+				/* Consume single-character 'xxxx' values */
+				ctx.nonblank = true
+				c, err = ctx.getachar()
+				if c == '\\' {
+					c, err = ctx.getachar()
+				}
+				for {
+					c, err = ctx.getachar()
+					if (c == '\'') || (c == '\n') || (err == io.EOF) {
+						break
+					}
+				}
+			} else if syntax.property(mysql) && c == '/' && bytes.HasPrefix(ctx.text[ctx.pos-1:], []byte("/*!")) {
+				/* MySQL conditional comments hold code */
+				ctx.nonblank = true
+			} else if syntax.commentleader != "" && (c == syntax.commentleader[0]) && (ctx.ispeek(syntax.commentleader[1])) {
+				c, err = ctx.getachar()
+				mode = stateINCOMMENT
+				commentType = commentBLOCK
+				depth = 1
+				startline = ctx.lineNumber
+			} else if ((syntax.eolcomment != "") && c == syntax.eolcomment[0] && (len(syntax.eolcomment) == 1 || ctx.consume([]byte(syntax.eolcomment[1:])))) || (syntax.property(asm) && strings.IndexByte(assemblerLeaders, c) > -1) || (syntax.property(mysql) && c == '#') {
+				if ctx.run.Debug > 1 {
+					fmt.Fprintf(os.Stderr, "cFamilyCounter: saw winged-comment leader %s\n", syntax.eolcomment)
+				}
+				c, _ = ctx.getachar()
+				mode = stateINCOMMENT
+				commentType = commentTRAILING
+				startline = ctx.lineNumber
+			} else if (syntax.multistring != "") && (c == syntax.multistring[0]) {
+				mode = stateINMULTISTRING
+				startline = ctx.lineNumber
+			} else if syntax.property(gotick) && c == '`' {
+				startLine := ctx.lineNumber
+				for {
+					c, err = ctx.getachar()
+					if err != nil {
+						ctx.run.warn(path, syntax.name, startLine, "unterminated-backtick",
+							fmt.Sprintf("WARNING - unterminated backtick, line %d, file %s", startLine, path))
+						break
+					}
+					if c == '`' {
+						break
+					}
+				}
+			} else if !ctx.blank(c) {
+				ctx.nonblank = true
+			}
+		} else if mode == stateINSTRING {
+			// We only count string lines with non-whitespace --
+			// this is to gracefully handle syntactically invalid
+			// programs.  You could argue that multiline strings
+			// with whitespace are still executable and should be
+			// counted.
+			if !ctx.blank(c) {
+				ctx.nonblank = true
+			}
+			if c == '"' {
+				mode = stateNORMAL
+			} else if syntax.property(cbs) && (c == '\\') && (ctx.ispeek('"') || ctx.ispeek('\\')) {
+				c, _ = ctx.getachar()
+			} else if syntax.property(cbs) && (c == '\\') && ctx.ispeek('\n') {
+				c, _ = ctx.getachar()
+			} else if c == '\n' {
+				// We found a bare newline in a string without
+				// preceding backslash.
+				if syntax.property(eolwarn) {
+					ctx.run.warn(path, syntax.name, ctx.lineNumber, "newline-in-string",
+						fmt.Sprintf("WARNING - newline in string, line %d, file %s", ctx.lineNumber, path))
+				}
+
+				// We COULD warn & reset mode to
+				// "Normal", but lots of code does this,
+				// so we'll just depend on the warning
+				// for ending the program in a string to
+				// catch syntactically erroneous
+				// programs.
+			}
+		} else if mode == stateINMULTISTRING {
+			// We only count multi-string lines with non-whitespace.
+			if !ctx.blank(c) {
+				ctx.nonblank = true
+			}
+			if c == syntax.multistring[0] {
+				mode = stateNORMAL
+			}
+		} else if mode == stateINRAWSTRING {
+			if !indoc && !ctx.blank(c) {
+				ctx.nonblank = true
+			}
+			if raw.escapes && c == '\\' && !ctx.ispeek('\n') {
+				c, _ = ctx.getachar()
+			} else if c == raw.close[0] && bytes.HasPrefix(ctx.text[ctx.pos-1:], []byte(raw.close)) {
+				for i := 1; i < len(raw.close); i++ {
+					c, _ = ctx.getachar()
+				}
+				if raw.doubled && ctx.consume([]byte(raw.close)) {
+					// Doubled terminator stands for itself
+				} else {
+					mode = stateNORMAL
+				}
+			}
+		} else { /* stateINCOMMENT mode */
+			if (c == '\n') && (commentType == commentTRAILING) {
+				mode = stateNORMAL
+			}
+			if (commentType == commentBLOCK) && syntax.property(cnest) && (c == syntax.commentleader[0]) && ctx.ispeek(syntax.commentleader[1]) {
+				c, _ = ctx.getachar()
+				depth++
+			} else if (commentType == commentBLOCK) && (c == syntax.commenttrailer[0]) && ctx.ispeek(syntax.commenttrailer[1]) {
+				c, _ = ctx.getachar()
+				depth--
+				if depth == 0 || !syntax.property(cnest) {
+					mode = stateNORMAL
+				}
+			}
+		}
+		if c == '\n' {
+			if ctx.nonblank {
+				stats.SLOC++
+			}
+			ctx.nonblank = false
+			// A directive goes on as long as its lines are continued
+			if syntax.continuation == "" || prev != syntax.continuation[0] {
+				indirective, indefine = false, false
+			}
+			if heredoc != "" {
+				stats.SLOC += ctx.skipheredoc(syntax.heredoc, heredoc)
+				heredoc = ""
+			}
+			if ctx.run.SkipDisabled && syntax.property(cpp) && mode == stateNORMAL && !indirective {
+				disabled += ctx.skipdisabled()
+			}
+			if mode != stateINRAWSTRING {
+				directive()
+			}
+		}
+		if c != '\r' {
+			prev = c
+		}
+		if mode == stateNORMAL && !indefine && len(syntax.terminator) > 0 && c == syntax.terminator[0] {
+			stats.LLOC++
+			if ctx.run.Debug > 1 {
+				fmt.Fprintf(os.Stderr, "cFamilyCounter: eol lloc++\n")
+			}
+		}
+		// Fast-forward over the uninteresting interiors of
+		// comments and strings.
+		if mode == stateINCOMMENT && commentType == commentTRAILING {
+			ctx.skipuntil("\n")
+		} else if mode == stateINCOMMENT && syntax.property(cnest) {
+			ctx.skipuntil(syntax.commentleader[:1] + syntax.commenttrailer[:1] + "\n")
+		} else if mode == stateINCOMMENT {
+			ctx.skipuntil(syntax.commenttrailer[:1] + "\n")
+		} else if mode == stateINSTRING && ctx.skipuntil("\"\\\n") {
+			ctx.nonblank = true
+		}
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		stats.SLOC++
+	}
+	ctx.nonblank = false
+	if (mode == stateINCOMMENT) && (commentType == commentTRAILING) {
+		mode = stateNORMAL
+	}
+
+	if mode == stateINCOMMENT {
+		ctx.run.warn(path, syntax.name, startline, "unterminated-comment",
+			fmt.Sprintf("%q, line %d: ERROR - terminated in comment beginning here", path, startline))
+	} else if mode == stateINSTRING || mode == stateINRAWSTRING {
+		ctx.run.warn(path, syntax.name, startline, "unterminated-string",
+			fmt.Sprintf("%q, line %d: ERROR - terminated in string beginning here", path, startline))
+	}
+
+	if disabled > 0 {
+		return []SourceStat{stats, {Path: path, Language: "disabled", SLOC: disabled}}
+	}
+	return []SourceStat{stats}
+}
+
+// Kinds of text in a lex or yacc file
+const (
+	lexGrammar = iota // yacc declarations and rules
+	lexPattern        // lex definitions and patterns, which aren't C
+	lexC              // C code copied into the generated program
+)
+
+// lexSections - classify each byte of a lex or yacc file.  Both have
+// three sections split by %% lines: declarations, rules, and C code.
+// In the first two, %{ ... %} blocks and braced actions are C too, and
+// lex also copies indented lines and comments through as C.
+func lexSections(text []byte, lex bool) []byte {
+	kinds := make([]byte, len(text))
+	mark := func(from, to int, kind byte) {
+		for ; from < to && from < len(text); from++ {
+			kinds[from] = kind
+		}
+	}
+	section := 1
+	for i := 0; i < len(text); {
+		end := bytes.IndexByte(text[i:], '\n') + i + 1
+		if end == i {
+			end = len(text)
+		}
+		line := text[i:end]
+		switch {
+		case section == 3:
+			mark(i, len(text), lexC)
+			return kinds
+		case bytes.HasPrefix(line, []byte("%%")):
+			section++
+		case bytes.HasPrefix(line, []byte("%{")):
+			// Literal C, up to a line beginning %}
+			close := bytes.Index(text[end-1:], []byte("\n%}"))
+			if close == -1 {
+				mark(end, len(text), lexC)
+				return kinds
+			}
+			mark(end, end+close, lexC)
+			i = end + close
+			continue
+		case lex && (line[0] == ' ' || line[0] == '\t'):
+			mark(i, end, lexC)
+		case lex && section == 1 && bytes.HasPrefix(line, []byte("/*")):
+			stop := bytes.Index(text[i:], []byte("*/"))
+			if stop == -1 {
+				stop = len(text) - i
+			}
+			stop = bytes.IndexByte(text[i+stop:], '\n') + i + stop + 1
+			if stop == i+stop {
+				stop = len(text)
+			}
+			mark(i, stop, lexC)
+			end = stop
+		case lex && section == 1:
+			mark(i, end, lexPattern)
+		case lex:
+			// A rule: the pattern, then an action running to the
+			// end of the line or of the braces it opens
+			p := patternEnd(text, i)
+			mark(i, p, lexPattern)
+			end = skipC(text, p, false)
+			mark(p, end, lexC)
+		default:
+			// yacc declarations and rules, with braced C in
+			// %union, %code and the actions
+			j := i
+			for ; j < len(text) && text[j] != '\n'; j++ {
+				switch c := text[j]; {
+				case c == '\'' || c == '"':
+					for j++; j < len(text) && text[j] != c && text[j] != '\n'; j++ {
+						if text[j] == '\\' {
+							j++
+						}
+					}
+				case c == '/' && j+1 < len(text) && text[j+1] == '*':
+					if n := bytes.Index(text[j+2:], []byte("*/")); n > -1 {
+						j += n + 3
+					} else {
+						j = len(text) - 1
+					}
+				case c == '/' && j+1 < len(text) && text[j+1] == '/':
+					for j+1 < len(text) && text[j+1] != '\n' {
+						j++
+					}
+				case c == '{':
+					stop := skipC(text, j, true)
+					mark(j, stop, lexC)
+					j = stop - 1
+				}
+			}
+			end = j + 1
+		}
+		i = end
+	}
+	return kinds
+}
+
+// patternEnd - find the end of the lex pattern starting at i, which is
+// the first blank not quoted, escaped, or in a character class
+func patternEnd(text []byte, i int) int {
+	inQuote, inClass := false, false
+	for ; i < len(text) && text[i] != '\n'; i++ {
+		c := text[i]
+		switch {
+		case c == '\\':
+			if i+1 < len(text) && text[i+1] != '\n' {
+				i++
+			}
+		case inQuote:
+			inQuote = c != '"'
+		case inClass:
+			inClass = c != ']'
+		case c == '"':
+			inQuote = true
+		case c == '[':
+			inClass = true
+			// A ] first in the class stands for itself
+			if i+1 < len(text) && text[i+1] == '^' {
+				i++
+			}
+			if i+1 < len(text) && text[i+1] == ']' {
+				i++
+			}
+		case c == ' ' || c == '\t':
+			return i
+		}
+	}
+	return i
+}
+
+// skipC - scan C from i, stepping over comments, strings and character
+// constants, to just past the newline that ends the code, or if braced
+// to just past the brace matching the one at i.  A brace left open at
+// the end of a line carries the code on to the next.
+func skipC(text []byte, i int, braced bool) int {
+	depth := 0
+	for i < len(text) {
+		c := text[i]
+		i++
+		switch {
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if braced && depth == 0 {
+				return i
+			}
+		case c == '\n':
+			if !braced && depth <= 0 {
+				return i
+			}
+		case c == '"' || c == '\'':
+			for i < len(text) && text[i] != c && text[i] != '\n' {
+				if text[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i < len(text) && text[i] == c {
+				i++
+			}
+		case c == '/' && i < len(text) && text[i] == '*':
+			if n := bytes.Index(text[i+1:], []byte("*/")); n > -1 {
+				i += n + 3
+			} else {
+				i = len(text)
+			}
+		case c == '/' && i < len(text) && text[i] == '/':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		}
+	}
+	return len(text)
+}
+
+// lexView - a lex or yacc file's text with the grammar or the C blanked
+// out, line breaks kept so line numbers still hold.  Lex patterns become
+// placeholders, so quotes and slashes in them can't start strings or
+// comments.
+func lexView(text []byte, kinds []byte, grammar bool, embedded bool) []byte {
+	view := make([]byte, len(text))
+	for i, c := range text {
+		switch {
+		case c == '\n' || c == ' ' || c == '\t':
+			view[i] = c
+		case (kinds[i] == lexC && !embedded) || (kinds[i] != lexC && !grammar):
+			view[i] = ' '
+		case kinds[i] == lexPattern:
+			view[i] = 'x'
+		default:
+			view[i] = c
+		}
+	}
+	return view
+}
+
+// recipeSplit - take the recipe lines of a makefile out of its count
+// and report them as shell.  A recipe line starts with a tab, unless it
+// continues a recipe line; a tab-indented line continuing a variable
+// definition is not a recipe.
+func recipeSplit(ctx *countContext, path string, stats SourceStat) []SourceStat {
+	recipes := SourceStat{Path: path, Language: "shell"}
+	if !ctx.setup(path) {
+		return []SourceStat{stats}
+	}
+	defer ctx.teardown()
+	inRecipe, continued := false, false
+	for _, line := range bytes.Split(ctx.text, []byte("\n")) {
+		if !continued {
+			inRecipe = len(line) > 0 && line[0] == '\t'
+		}
+		trimmed := bytes.TrimSpace(line)
+		continued = bytes.HasSuffix(trimmed, []byte("\\"))
+		if inRecipe && len(trimmed) > 0 && trimmed[0] != '#' {
+			recipes.SLOC++
+		}
+	}
+	if recipes.SLOC > stats.SLOC {
+		recipes.SLOC = stats.SLOC
+	}
+	stats.SLOC -= recipes.SLOC
+	return []SourceStat{stats, recipes}
+}
+
+// sectionCounter - count a lex or yacc file with its C code scanned by
+// the C rules and its patterns kept away from them.  With SplitEmbedded
+// the C is reported as "embedded-c"; lines holding both a rule and its
+// action go to the C.
+func sectionCounter(ctx *countContext, path string, syntax genericLanguage) []SourceStat {
+	if !verified(ctx, path, syntax.name, syntax.verifier) || !ctx.setup(path) {
+		return []SourceStat{{}}
+	}
+	text := ctx.text
+	defer func() { ctx.text = text }()
+	kinds := lexSections(text, syntax.name == "lex")
+	syntax.verifier = nil
+	syntax.flags &^= lexyacc
+	count := func(grammar, embedded bool) []SourceStat {
+		ctx.text = lexView(text, kinds, grammar, embedded)
+		return cFamilyCounter(ctx, path, syntax)
+	}
+	stats := count(true, true)
+	if !ctx.run.SplitEmbedded {
+		return stats
+	}
+	c := count(false, true)[0]
+	stats[0].SLOC -= c.SLOC
+	stats[0].LLOC = count(true, false)[0].LLOC
+	c.Language = "embedded-c"
+	return append(stats, c)
+}
+
+// literateCounter - count the indented code blocks of a literate
+// source by the rules of its language, leaving out the prose.
+func literateCounter(ctx *countContext, path string, syntax genericLanguage) []SourceStat {
+	if !verified(ctx, path, syntax.name, syntax.verifier) || !ctx.setup(path) {
+		return []SourceStat{{}}
+	}
+	text := ctx.text
+	defer func() { ctx.text = text }()
+	view := make([]byte, 0, len(text))
+	for _, line := range bytes.SplitAfter(text, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("    ")) || bytes.HasPrefix(line, []byte("\t")) {
+			view = append(view, line...)
+		} else if bytes.HasSuffix(line, []byte("\n")) {
+			view = append(view, '\n')
+		}
+	}
+	ctx.text = view
+	syntax.verifier = nil
+	syntax.flags &^= literate
+	return cFamilyCounter(ctx, path, syntax)
+}
+
+// genericCounter - count SLOC in a generic language.
+func genericCounter(ctx *countContext, path string,
+	syntax genericLanguage) SourceStat {
+	var stats SourceStat
+
+	if !verified(ctx, path, syntax.name, syntax.verifier) {
+		return stats
+	}
+
+	ctx.setup(path)
+	defer ctx.teardown()
+
+	stats.Path = path
+	stats.Language = syntax.name
+
+	eolcomment := []byte(syntax.eolcomment)
+	terminator := []byte(syntax.terminator)
+	continuation := []byte(syntax.continuation)
+	var heredoc string  // terminator of the here-document we are in
+	var terminated bool // does the logical line so far hold a terminator?
+	for ctx.munchline() {
+		if heredoc != "" {
+			if !syntax.heredoc.closes(ctx.line, heredoc) {
+				if len(bytes.TrimSpace(ctx.line)) > 0 {
+					stats.SLOC++
+				}
+				continue
+			}
+			heredoc = ""
+		}
+		if len(eolcomment) > 0 {
+			if i := bytes.Index(ctx.line, eolcomment); i > -1 {
+				ctx.line = ctx.line[:i]
+			}
+		}
+		ctx.line = bytes.TrimSpace(ctx.line)
+		heredoc = syntax.heredoc.opens(ctx.line)
+		if len(ctx.line) > 0 {
+			stats.SLOC++
+			// Continued lines are joined before looking for
+			// the terminator.  A newline terminator makes each
+			// logical line a statement.
+			terminated = terminated || syntax.terminator == "\n" || (len(terminator) > 0 && bytes.Contains(ctx.line, terminator))
+			if syntax.continuation != "" && bytes.HasSuffix(ctx.line, continuation) {
+				continue
+			}
+			if terminated {
+				stats.LLOC++
+			}
+			terminated = false
+		}
+	}
+
+	return stats
+}
+
+func goCounter(ctx *countContext, path string) uint {
+	var lloc uint
+
+	if !ctx.setup(path) {
+		return 0
+	}
+	content := ctx.text
+	ctx.teardown()
+
+	fset := token.NewFileSet() // positions are relative to fset
+	f, err2 := parser.ParseFile(fset, path, content, 0)
+	if err2 != nil {
+		return 0
+	}
+
+	// Inspect the AST and print all identifiers and literals.
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.AssignStmt: // sssignment or short variable declaration
+			lloc++
+		case *ast.BranchStmt: // break, continue, goto, or fallthrough
+			lloc++
+		case *ast.DeclStmt: // declaration in a statement list.
+			lloc++
+		case *ast.DeferStmt: // a defer statement.
+			lloc++
+		case *ast.ExprStmt: // stand-alone expression in a statement list.
+			lloc++
+		case *ast.GenDecl: // an import, constant, type or variable declaration
+			lloc++
+		case *ast.GoStmt: // go xxxx
+			lloc++
+		//case *ast.IfStmt:	// an if statement
+		//	lloc++
+		case *ast.ImportSpec: // package import line
+			lloc++
+		case *ast.IncDecStmt: // incement or decrement statement
+			lloc++
+		//case *ast.RangeStmt:	// for statement with a range clause.
+		//	lloc++
+		case *ast.ReturnStmt: // a return statement.
+			lloc++
+		//case *ast.SelectStmt:	// a select statement.
+		//	lloc++
+		case *ast.SendStmt: // a send statement.
+			lloc++
+			//case *ast.SwitchStmt:	// a switch statement.
+			//	lloc++
+		}
+		// Not counted: BlockStmt, FuncDecl
+		// Including IfStmt, RangeStmt, SelectStmt, SwitchStmt
+		// is probably a better complexity metric, but no longer
+		// strictly comparable with counting semis in C.
+		return true
+	})
+	return lloc
+}
+
+// Byte-slice forms of constants the line counters use, so the inner
+// loops don't allocate converting them.
+var dtBytes, stBytes, placeholder = []byte(dt), []byte(st), []byte("x")
+
+// excise - replace matches of a regexp in a line, without allocating
+// when there is nothing to replace, as on most lines
+func excise(re *regexp.Regexp, line []byte, repl []byte) []byte {
+	if !re.Match(line) {
+		return line
+	}
+	return re.ReplaceAllLiteral(line, repl)
+}
+
+func pythonCounter(ctx *countContext, path string) SourceStat {
+	var isintriple bool  // A triple-quote is in effect.
+	var isincomment bool // We are in a multiline (triple-quoted) comment.
+	var stats SourceStat
+
+	ctx.setup(path)
+	stats.Path = path
+	defer ctx.teardown()
+
+	tripleBoundary := func(line []byte) bool { return bytes.Contains(line, dtBytes) || bytes.Contains(line, stBytes) }
+	for ctx.munchline() {
+		// Delete trailing comments
+		i := bytes.IndexByte(ctx.line, '#')
+		if i > -1 {
+			ctx.line = ctx.line[:i]
+		}
+
+		if !isintriple { // Normal case:
+			// Ignore triple-quotes that begin & end on the ctx.line.
+			ctx.line = excise(dtriple, ctx.line, nil)
+			ctx.line = excise(striple, ctx.line, nil)
+			// Delete lonely strings starting on BOL.
+			ctx.line = excise(dlonely, ctx.line, nil)
+			ctx.line = excise(slonely, ctx.line, nil)
+			// Delete trailing comments
+			i := bytes.IndexByte(ctx.line, '#')
+			if i > -1 {
+				ctx.line = ctx.line[:i]
+			}
+			// Does multi-line triple-quote begin here?
+			if tripleBoundary(ctx.line) {
+				isintriple = true
+				ctx.line = bytes.TrimSpace(ctx.line)
+				// It's a comment if at BOL.
+				if bytes.HasPrefix(ctx.line, dtBytes) || bytes.HasPrefix(ctx.line, stBytes) {
+					isincomment = true
+				}
+			}
+		} else { // we ARE in a triple.
+			if tripleBoundary(ctx.line) {
+				if isincomment {
+					// Delete text if it's a comment (not if data)
+					ctx.line = excise(dtrailer, ctx.line, nil)
+					ctx.line = excise(strailer, ctx.line, nil)
+				} else {
+					// Leave something there to count.
+					ctx.line = excise(dtrailer, ctx.line, placeholder)
+					ctx.line = excise(strailer, ctx.line, placeholder)
+				}
+				// But wait!  Another triple might
+				// start on this ctx.line!  (see
+				// Python-1.5.2/Tools/freeze/makefreeze.py
+				// for an example)
+				if tripleBoundary(ctx.line) {
+					// It did!  No change in state!
+				} else {
+					isintriple = false
+					isincomment = false
+				}
+			}
+		}
+		ctx.line = bytes.TrimSpace(ctx.line)
+		if !isincomment && len(ctx.line) > 0 {
+			stats.SLOC++
+			if ctx.line[len(ctx.line)-1] != '\\' {
+				stats.LLOC++
+			}
+		}
+	}
+
+	return stats
+}
+
+// perlCounter - count SLOC in Perl
+//
+// Physical lines of Perl are MUCH HARDER to count than you'd think.
+// Comments begin with "#".
+// Also, anything in a "perlpod" is a comment.
+// See perlpod(1) for more info; a perlpod starts with
+// \s*=command, can have more commands, and ends with \s*=cut.
+// Note that = followed by space is NOT a perlpod.
+// Although we ignore everything after __END__ in a file,
+// we will count everything after __DATA__; there's arguments for counting
+// and for not counting __DATA__.
+//
+// What's worse, "here" documents must be COUNTED AS CODE, even if
+// they're FORMATTED AS A PERLPOD.  Surely no one would do this, right?
+// Sigh... it can happen. See perl5.005_03/pod/splitpod.
+func perlCounter(ctx *countContext, path string) SourceStat {
+	var heredoc string
+	var isinpod bool
+	var stats SourceStat
+
+	ctx.setup(path)
+	stats.Path = path
+	defer ctx.teardown()
+
+	for ctx.munchline() {
+		if heredoc != "" {
+			if !perlHeredoc.closes(ctx.line, heredoc) {
+				// Here-document text, even if it looks like
+				// a comment or POD
+				if len(bytes.TrimSpace(ctx.line)) > 0 {
+					stats.SLOC++
+				}
+				continue
+			}
+			heredoc = "" //finished here doc.
+		}
+
+		// Delete trailing comments
+		i := bytes.Index(ctx.line, []byte("#"))
+		if i > -1 {
+			ctx.line = ctx.line[:i]
+		}
+
+		ctx.line = bytes.TrimSpace(ctx.line)
+
+		if bytes.HasPrefix(ctx.line, []byte("=cut")) {
+			// Ending a POD?
+			if !isinpod {
+				ctx.run.warn(path, "perl", ctx.lineNumber, "cut-without-pod",
+					fmt.Sprintf("%q, %d: cut without pod start", path, ctx.lineNumber))
+			}
+			isinpod = false
+			continue // Don't count the cut command.
+		} else if podheader.Match(ctx.line) {
+			// Starting or continuing a POD?
+			// Perlpods can have multiple contents, so
+			// it's okay if isinpod == true.  Note that
+			// =(space) isn't a POD; library file
+			// perl5db.pl does this!
+			isinpod = true
+		} else if bytes.HasPrefix(ctx.line, []byte("__END__")) {
+			// Stop processing this file on __END__.
+			break
+		} else if !isinpod {
+			// Beginning of a here document?
+			heredoc = perlHeredoc.opens(ctx.line)
+		}
+		if !isinpod && len(ctx.line) > 0 {
+			stats.SLOC++
+			if strings.Contains(string(ctx.line), ";") {
+				stats.LLOC++
+			}
+		}
+	}
+
+	return stats
+}
+
+// pascalCounter - Handle lanuages like Pascal and Modula 3
+func pascalCounter(ctx *countContext, path string, syntax pascalLike) SourceStat {
+	mode := stateNORMAL /* stateNORMAL, or stateINCOMMENT */
+	var stats SourceStat
+	var depth int /* (* *) comments open, where they nest */
+	var startline uint
+
+	if !verified(ctx, path, syntax.name, syntax.verifier) {
+		return stats
+	}
+
+	ctx.setup(path)
+	stats.Path = path
+	defer ctx.teardown()
+
+	for {
+		c, err := ctx.getachar()
+		if err == io.EOF {
+			break
+		}
+
+		if mode == stateNORMAL {
+			if syntax.bracketcomments && c == '{' {
+				mode = stateINCOMMENT
+				depth = 0
+				startline = ctx.lineNumber
+			} else if (c == '(') && ctx.ispeek('*') {
+				c, _ = ctx.getachar()
+				mode = stateINCOMMENT
+				depth = 1
+				startline = ctx.lineNumber
+			} else if !ctx.blank(c) {
+				ctx.nonblank = true
+			} else if c == '\n' {
+				if ctx.nonblank {
+					stats.SLOC++
+				}
+				ctx.nonblank = false
+			}
+			if len(syntax.terminator) > 0 && c == syntax.terminator[0] {
+				stats.LLOC++
+			}
+		} else { /* stateINCOMMENT mode */
+			if syntax.bracketcomments && c == '}' && depth == 0 {
+				mode = stateNORMAL
+			} else if syntax.nestcomments && (c == '(') && ctx.ispeek('*') {
+				_, _ = ctx.getachar()
+				depth++
+			} else if (c == '*') && ctx.ispeek(')') {
+				_, _ = ctx.getachar()
+				depth--
+				if depth <= 0 || !syntax.nestcomments {
+					mode = stateNORMAL
+				}
+			}
+			// Newlines are read one by one to keep the line number
+			if mode == stateINCOMMENT && syntax.bracketcomments {
+				ctx.skipuntil("(*}\n")
+			} else if mode == stateINCOMMENT {
+				ctx.skipuntil("(*\n")
+			}
+		}
+	}
+	/* We're done with the file.  Handle EOF-without-EOL. */
+	if ctx.nonblank {
+		stats.SLOC++
+	}
+	ctx.nonblank = false
+
+	if mode == stateINCOMMENT {
+		ctx.run.warn(path, syntax.name, startline, "unterminated-comment",
+			fmt.Sprintf("%q, line %d: ERROR - terminated in comment beginning here.", path, startline))
+	} else if mode == stateINSTRING {
+		ctx.run.warn(path, syntax.name, startline, "unterminated-string",
+			fmt.Sprintf("%q, line %d: ERROR - terminated in string beginning here.", path, startline))
+	}
+
+	return stats
+}
+
+func fortranCounter(ctx *countContext, path string, syntax fortranLike) SourceStat {
+	var stats SourceStat
+
+	ctx.setup(path)
+	stats.Path = path
+	defer ctx.teardown()
+
+	continued := false
+	for ctx.munchline() {
+		if syntax.comment.Match(ctx.line) && !syntax.nocomment.Match(ctx.line) {
+			continue
+		}
+		stats.SLOC++
+		// A statement begins on every line that doesn't continue
+		// the one before.
+		if syntax.continuation == "" {
+			if len(ctx.line) < 6 || ctx.line[5] == ' ' || ctx.line[5] == '0' || ctx.line[0] == '\t' {
+				stats.LLOC++
+			}
+			continue
+		}
+		if !continued {
+			stats.LLOC++
+		}
+		code := ctx.line
+		if i := bytes.IndexByte(code, '!'); i > -1 {
+			code = code[:i]
+		}
+		continued = bytes.HasSuffix(bytes.TrimRightFunc(code, unicode.IsSpace), []byte(syntax.continuation))
+	}
+	return stats
+}
+
+// Per-path language rules from --lang-for or [[path]] tables in a
+// language-definition file.  Each maps a glob to a language name, or
+// to "ignore" to drop matching files and directories.  The first
+// matching rule wins.
+
+type pathRule struct {
+	glob     string
+	pattern  *regexp.Regexp
+	basename bool // glob has no slash, so match against the basename
+	language string
+}
+
+// globToRegexp - translate a shell-style glob in which ** matches
+// across directories.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			re.WriteString("(/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[' && globClass(glob[i:]) > 0:
+			n := globClass(glob[i:])
+			class := glob[i+1 : i+n-1]
+			re.WriteString("[")
+			if class[0] == '!' {
+				re.WriteString("^/")
+				class = class[1:]
+			}
+			for j := 0; j < len(class); j++ {
+				if strings.IndexByte(`\[]^`, class[j]) >= 0 {
+					re.WriteByte('\\')
+				}
+				re.WriteByte(class[j])
+			}
+			re.WriteString("]")
+			i += n - 1
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
+
+// globClass - the length of the character class that starts a glob,
+// or 0 if the [ opening it is never closed and so stands for itself.
+// As in the shell, ! negates a class, and a ] just after the [ or the
+// ! is a member rather than the end.
+func globClass(glob string) int {
+	i := 1
+	if i < len(glob) && glob[i] == '!' {
+		i++
+	}
+	if i < len(glob) && glob[i] == ']' {
+		i++
+	}
+	end := strings.IndexByte(glob[i:], ']')
+	if end < 0 {
+		return 0
+	}
+	return i + end + 1
+}
+
+// parseIgnoreRules - read the rules of a .gitignore file
+func parseIgnoreRules(text []byte) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(string(NormalizeEOL(text, false)), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var rule ignoreRule
+		if line[0] == '!' {
+			rule.negate, line = true, line[1:]
+		} else if line[0] == '\\' {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		rule.basename = !strings.Contains(line, "/")
+		pattern, err := globToRegexp(strings.TrimPrefix(line, "/"))
+		if err != nil || line == "" {
+			continue
+		}
+		rule.pattern = pattern
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// findIgnoreTop - decide how ignore rules apply to the root about to
+// be walked, finding the top of the git work tree it is in, if any
+func (r *run) findIgnoreTop() {
+	r.ignoreCache = map[string][]ignoreRule{}
+	r.ignoreActive, r.ignoreTop, r.ignorePrefix = false, "", ""
+	if r.KeepIgnored {
+		return
+	}
+	if _, ok := r.source.(osFS); ok {
+		root, err := filepath.Abs(r.sourcePath("."))
+		if err != nil {
+			return
+		}
+		for dir := root; ; dir = filepath.Dir(dir) {
+			if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+				r.ignoreActive, r.ignoreTop = true, dir
+				if rel, _ := filepath.Rel(dir, root); rel != "." {
+					r.ignorePrefix = filepath.ToSlash(rel)
+				}
+				return
+			}
+			if filepath.Dir(dir) == dir {
+				break
+			}
+		}
+	}
+	r.ignoreActive = r.IgnoreAnywhere
+}
+
+// readIgnoreFile - read a file of ignore rules, by its path from the
+// top of the work tree
+func (r *run) readIgnoreFile(name string) ([]byte, error) {
+	if r.ignoreTop == "" {
+		return fs.ReadFile(r.source, name)
+	}
+	return ioutil.ReadFile(filepath.Join(r.ignoreTop, filepath.FromSlash(name)))
+}
+
+// gitDir - where the repository of the work tree is kept; a .git file,
+// as in linked work trees and submodules, points to it
+func (r *run) gitDir() string {
+	if text, err := r.readIgnoreFile(".git"); err == nil {
+		if dir := strings.TrimSpace(strings.TrimPrefix(string(text), "gitdir:")); dir != "" {
+			if !filepath.IsAbs(dir) && r.ignoreTop != "" {
+				dir = filepath.Join(r.ignoreTop, dir)
+			}
+			return dir
+		}
+	}
+	return ".git"
+}
+
+// ignoreRules - the rules of a directory's .gitignore
+func (r *run) ignoreRules(dir string) []ignoreRule {
+	r.ignoreLock.Lock()
+	defer r.ignoreLock.Unlock()
+	rules, ok := r.ignoreCache[dir]
+	if ok {
+		return rules
+	}
+	if dir == "." {
+		exclude := filepath.Join(r.gitDir(), "info", "exclude")
+		if filepath.IsAbs(exclude) {
+			if text, err := ioutil.ReadFile(exclude); err == nil {
+				rules = parseIgnoreRules(text)
+			}
+		} else if text, err := r.readIgnoreFile(filepath.ToSlash(exclude)); err == nil {
+			rules = parseIgnoreRules(text)
+		}
+	}
+	if text, err := r.readIgnoreFile(filepath.ToSlash(filepath.Join(dir, ".gitignore"))); err == nil {
+		rules = append(rules, parseIgnoreRules(text)...)
+	}
+	r.ignoreCache[dir] = rules
+	return rules
+}
+
+// gitignored - do the .gitignore files above a path rule it out?  The
+// last matching rule decides, and those of deeper directories come
+// later.  The path is taken from the top of the work tree, so the files
+// of directories above the root count, but only the path itself is
+// matched: a directory given as an argument is walked even if ignored.
+func (r *run) gitignored(name string, dir bool) bool {
+	name = filepath.ToSlash(filepath.Clean(name))
+	if name == "." || filepath.IsAbs(name) || strings.HasPrefix(name, "../") {
+		return false
+	}
+	if r.ignorePrefix != "" {
+		name = r.ignorePrefix + "/" + name
+	}
+	parts := strings.Split(name, "/")
+	ignored := false
+	for i := range parts {
+		base := strings.Join(parts[:i], "/")
+		if base == "" {
+			base = "."
+		}
+		for _, rule := range r.ignoreRules(base) {
+			subject := strings.Join(parts[i:], "/")
+			if rule.basename {
+				subject = parts[len(parts)-1]
+			}
+			if (dir || !rule.dirOnly) && rule.pattern.MatchString(subject) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// AddPathRule - add a glob:language rule
+func (o *Options) AddPathRule(rule string) error {
+	colon := strings.LastIndexByte(rule, ':')
+	if colon < 1 || colon == len(rule)-1 {
+		return fmt.Errorf("--lang-for wants glob:language, not %s", rule)
+	}
+	glob, name := rule[:colon], rule[colon+1:]
+	if name != "ignore" && !knownLanguage(name) {
+		return fmt.Errorf("--lang-for: unknown language %s", name)
+	}
+	pattern, err := globToRegexp(strings.TrimPrefix(glob, "./"))
+	if err != nil {
+		return err
+	}
+	o.pathRules = append(o.pathRules, pathRule{glob, pattern, !strings.Contains(glob, "/"), name})
+	return nil
+}
+
+// pathLanguage - the language a path rule assigns to a path, if any
+func (r *run) pathLanguage(path string) string {
+	path = strings.TrimPrefix(filepath.ToSlash(path), "./")
+	for _, rule := range r.pathRules {
+		subject := path
+		if rule.basename {
+			subject = filepath.Base(path)
+		}
+		if rule.pattern.MatchString(subject) {
+			if r.Debug > 0 {
+				fmt.Printf("path rule %s:%s matched: %s\n", rule.glob, rule.language, path)
+			}
+			return rule.language
+		}
+	}
+	return ""
+}
+
+// Test code is told from production code by where it lives and how it
+// is named, following the conventions of the common test frameworks.
+// Globs without a slash are matched against the basename.
+
+var testPatterns = []string{
+	"**/test/**", "**/tests/**", "**/spec/**", "**/specs/**",
+	"**/__tests__/**", "**/testdata/**",
+	"*_test.go",
+	"*Test.java", "*Tests.java", "*Test.kt", "*Tests.kt", "*Test.scala", "*Spec.scala",
+	"*Tests.cs", "*Test.cs",
+	"test_*.py", "*_test.py",
+	"*_spec.rb", "*_test.rb",
+	"*.spec.js", "*.test.js", "*.spec.jsx", "*.test.jsx",
+	"*.spec.ts", "*.test.ts", "*.spec.tsx", "*.test.tsx",
+	"*_test.c", "*_test.cc", "*_test.cpp", "*_unittest.cc",
+	"*_test.exs", "*_test.rs", "*Tests.swift",
+}
+
+var defaultTestRules []pathRule
+
+func init() {
+	rules, err := compileTestPatterns(testPatterns)
+	if err != nil {
+		panic(err)
+	}
+	defaultTestRules = rules
+}
+
+// compileTestPatterns - turn globs marking test code into path rules
+func compileTestPatterns(globs []string) ([]pathRule, error) {
+	var rules []pathRule
+	for _, glob := range globs {
+		pattern, err := globToRegexp(strings.TrimPrefix(glob, "./"))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, pathRule{glob, pattern, !strings.Contains(glob, "/"), "tests"})
+	}
+	return rules, nil
+}
+
+// AddTestPatterns - add globs marking test code, to the built-in ones
+// or in place of them
+func (o *Options) AddTestPatterns(globs []string, replace bool) error {
+	rules, err := compileTestPatterns(globs)
+	if err != nil {
+		return err
+	}
+	if !o.testsSet && !replace {
+		o.testRules = append([]pathRule(nil), defaultTestRules...)
+	}
+	o.testRules = append(o.testRules, rules...)
+	o.testsSet = true
+	return nil
+}
+
+// IsTest - does a path look like test code?
+func (o *Options) IsTest(path string) bool {
+	rules := o.testRules
+	if !o.testsSet {
+		rules = defaultTestRules
+	}
+	path = strings.TrimPrefix(filepath.ToSlash(path), "./")
+	for _, rule := range rules {
+		subject := path
+		if rule.basename {
+			subject = filepath.Base(path)
+		}
+		if rule.pattern.MatchString(subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// knownLanguage - is there a counter for the named language?
+func knownLanguage(name string) bool {
+	switch name {
+	case "python", "waf", "scons", "perl":
+		return true
+	}
+	for _, lang := range registeredLanguages {
+		if lang.Name == name {
+			return true
+		}
+	}
+	for _, lang := range genericLanguages {
+		if lang.name == name {
+			return true
+		}
+	}
+	for _, lang := range scriptingLanguages {
+		if lang.name == name {
+			return true
+		}
+	}
+	for _, lang := range pascalLikes {
+		if lang.name == name {
+			return true
+		}
+	}
+	for _, lang := range fortranLikes {
+		if lang.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// countAs - count a file as a given language, bypassing recognition
+func (r *run) countAs(path string, name string) []SourceStat {
+	for _, lang := range registeredLanguages {
+		if lang.Name == name {
+			singleStat := lang.Counter.Count(r.sourcePath(path))
+			singleStat.Path = path
+			if singleStat.Language == "" {
+				singleStat.Language = name
+			}
+			return []SourceStat{singleStat}
+		}
+	}
+	ctx := r.newContext()
+	defer ctx.recycle()
+	return countTextAs(ctx, path, name)
+}
+
+// countTextAs - count the text of a context as a given language by the
+// built-in rules.  Languages registered in code read their files
+// themselves and can't be counted this way.
+func countTextAs(ctx *countContext, path string, name string) []SourceStat {
+	var singleStat SourceStat
+	singleStat.Path = path
+
+	switch name {
+	case "python", "waf", "scons":
+		singleStat = pythonCounter(ctx, path)
+		singleStat.Language = name
+		return []SourceStat{singleStat}
+	case "perl":
+		singleStat = perlCounter(ctx, path)
+		singleStat.Language = name
+		return []SourceStat{singleStat}
+	}
+	for _, lang := range genericLanguages {
+		if lang.name == name {
+			lang.verifier = nil
+			if len(lang.commentleader) > 0 || lang.rawstrings != nil {
+				if name == "sql" && isMySQL(ctx, path) {
+					lang.flags |= mysql
+				}
+				stats := cFamilyCounter(ctx, path, lang)
+				if name == "go" {
+					stats[0].LLOC = goCounter(ctx, path)
+				}
+				return stats
+			}
+			return []SourceStat{genericCounter(ctx, path, lang)}
+		}
+	}
+	for _, lang := range scriptingLanguages {
+		if lang.name == name {
+			singleStat = genericCounter(ctx, path,
+				genericLanguage{
+					name:       lang.name,
+					eolcomment: "#",
+					heredoc:    lang.heredoc,
+				})
+			return []SourceStat{singleStat}
+		}
+	}
+	for _, lang := range pascalLikes {
+		if lang.name == name {
+			lang.verifier = nil
+			singleStat = pascalCounter(ctx, path, lang)
+			singleStat.Language = name
+			return []SourceStat{singleStat}
+		}
+	}
+	for _, lang := range fortranLikes {
+		if lang.name == name {
+			singleStat = fortranCounter(ctx, path, lang)
+			singleStat.Language = name
+			return []SourceStat{singleStat}
+		}
+	}
+	return []SourceStat{singleStat}
+}
+
+// Embedded languages.  A host file may hold regions written in another
+// language: the scripts and style sheets of a web page, the fenced code
+// of Markdown, SQL in a here-document.  A region runs from a line
+// matching its opening pattern to one matching its closing pattern;
+// both of those lines stay with the host, and the lines between are
+// counted by the rules of the embedded language and reported under it,
+// with the host named.  The host's own lines are counted by its rules
+// with the regions left out.  Markup hosts, found by suffix, have no
+// rules of their own; the rest are found by the language a file was
+// counted as.
+
+type embeddedRegion struct {
+	open     *regexp.Regexp // The line beginning the region
+	close    string         // Pattern of the line ending it; ${1} and so on come from open
+	language string         // Language of the region, expanded like close
+}
+
+type embeddingHost struct {
+	name     string   // Language, or markup, the regions are embedded in
+	suffixes []string // Of markup files
+	regions  []embeddedRegion
+}
+
+var webRegions = []embeddedRegion{
+	{regexp.MustCompile(`(?i)<script\b[^>]*>`), `(?i)</script\s*>`, "javascript"},
+	{regexp.MustCompile(`(?i)<style\b[^>]*>`), `(?i)</style\s*>`, "css"},
+}
+
+var fenceRegions = []embeddedRegion{
+	{regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*\\{?\\.?([A-Za-z0-9_+#.-]*)"), "^ {0,3}${1}[ \t]*$", "${2}"},
+}
+
+var sqlHeredocs = []embeddedRegion{
+	{regexp.MustCompile(`<<<?[-~]?[ \t]*['"]?((?i:SQL|EOSQL|PSQL|MYSQL))\b['"]?`), `^[ \t]*${1}\b`, "sql"},
+}
+
+var embeddingHosts = []embeddingHost{
+	{"html", []string{".html", ".htm", ".xhtml"}, webRegions},
+	{"markdown", []string{".md", ".markdown"}, fenceRegions},
+	{"vue", []string{".vue"}, webRegions},
+	{"svelte", []string{".svelte"}, webRegions},
+	{"php", nil, append(append([]embeddedRegion{}, webRegions...), sqlHeredocs...)},
+	{"shell", nil, sqlHeredocs},
+	{"perl", nil, sqlHeredocs},
+	{"ruby", nil, sqlHeredocs},
+}
+
+// Info strings of Markdown fences that aren't loccount's names
+var embeddedAliases = map[string]string{
+	"js": "javascript", "mjs": "javascript", "py": "python",
+	"python3": "python", "sh": "shell", "bash": "shell", "rb": "ruby",
+	"rs": "rust", "cpp": "c++", "cc": "c++", "cxx": "c++", "h": "c",
+	"objc": "objective-c", "cs": "c#", "csharp": "c#", "fsharp": "f#",
+	"golang": "go", "hs": "haskell", "kt": "kotlin", "pl": "perl",
+	"el": "elisp", "emacs-lisp": "elisp", "clj": "clojure",
+	"cljs": "clojurescript", "make": "makefile", "mk": "makefile",
+	"ocaml": "ml", "coffee": "coffeescript", "jl": "julia",
+	"mysql": "sql", "psql": "sql", "postgresql": "sql", "f90": "fortran90",
+}
+
+// embeddingHostOf - the host whose regions a file may hold, if any
+func embeddingHostOf(path string, result []SourceStat) *embeddingHost {
+	for i := range embeddingHosts {
+		host := &embeddingHosts[i]
+		for _, suffix := range host.suffixes {
+			if strings.HasSuffix(strings.ToLower(path), suffix) && (len(result) == 0 || !result[0].nonEmpty()) {
+				return host
+			}
+		}
+		if host.suffixes == nil && len(result) == 1 && result[0].nonEmpty() {
+			name := result[0].Language
+			if group, ok := languageGroups[name]; ok {
+				name = group
+			}
+			if name == host.name {
+				return host
+			}
+		}
+	}
+	return nil
+}
+
+// embedRegions - count the regions of embedded code in a file apart
+// from its host's code
+func embedRegions(ctx *countContext, path string, result []SourceStat) []SourceStat {
+	host := embeddingHostOf(path, result)
+	if host == nil || !ctx.setup(path) {
+		return result
+	}
+	text := ctx.text
+	defer func() { ctx.text = text }()
+
+	lines := bytes.SplitAfter(text, []byte("\n"))
+	owners := make([]string, len(lines)) // embedded language of each line
+	var languages []string
+	var close *regexp.Regexp
+	var language string
+	for i, line := range lines {
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if close != nil {
+			if close.Match(line) {
+				close = nil
+			} else {
+				owners[i] = language
+			}
+			continue
+		}
+		for _, region := range host.regions {
+			match := region.open.FindSubmatchIndex(line)
+			if match == nil {
+				continue
+			}
+			pattern := region.open.Expand(nil, []byte(region.close), line, match)
+			// A region closed on the line that opens it is left
+			// to the host.
+			if close = regexp.MustCompile(string(pattern)); close.Match(line[match[1]:]) {
+				close = nil
+				break
+			}
+			language = strings.ToLower(string(region.open.Expand(nil, []byte(region.language), line, match)))
+			if alias, ok := embeddedAliases[language]; ok {
+				language = alias
+			}
+			if !knownLanguage(language) {
+				language = ""
+			}
+			seen := language == ""
+			for _, known := range languages {
+				seen = seen || known == language
+			}
+			if !seen {
+				languages = append(languages, language)
+			}
+			break
+		}
+	}
+	if len(languages) == 0 {
+		return result
+	}
+
+	// view - the text with all but the lines of one language blanked
+	view := func(language string) []byte {
+		out := make([]byte, 0, len(text))
+		for i, line := range lines {
+			if owners[i] == language {
+				out = append(out, line...)
+			} else if bytes.HasSuffix(line, []byte("\n")) {
+				out = append(out, '\n')
+			}
+		}
+		return out
+	}
+	var stats []SourceStat
+	if host.suffixes == nil {
+		ctx.text = view("")
+		stats = countTextAs(ctx, path, result[0].Language)
+	}
+	for _, language := range languages {
+		ctx.text = view(language)
+		for _, st := range countTextAs(ctx, path, language) {
+			if st.Language != "" {
+				st.Host = host.name
+				stats = append(stats, st)
+			}
+		}
+	}
+	if len(stats) == 0 {
+		return result
+	}
+	return stats
+}
+
+// Registration API.  Code that wants to add a language, or replace the
+// counting of one the tables already handle, can call RegisterLanguage
+// instead of editing the tables in init().  Registered languages are
+// tried before the tables, in registration order.
+
+// Counter counts the lines of a file known to be in its language.  The
+// path it is given is one that can be opened from the current
+// directory, wherever the tree being walked is.
+type Counter interface {
+	Count(path string) SourceStat
+}
+
+// CounterFunc adapts an ordinary function to the Counter interface.
+type CounterFunc func(path string) SourceStat
+
+// Count calls f(path).
+func (f CounterFunc) Count(path string) SourceStat {
+	return f(path)
+}
+
+// Language describes a language to be registered.
+type Language struct {
+	Name     string
+	Suffixes []string               // filename suffixes claimed
+	Hashbang string                 // interpreter name claimed in #! lines, if any
+	Comment  string                 // winged-comment leader, for the generated-code filter
+	Logical  bool                   // does the counter report LLOC?
+	Verifier func(path string) bool // optional content check, given a path as Count is
+	Counter  Counter
+}
+
+var registeredLanguages []Language
+
+// RegisterLanguage adds a language, taking precedence over the tables.
+func RegisterLanguage(lang Language) error {
+	if lang.Name == "" || lang.Counter == nil {
+		return fmt.Errorf("a registered language needs a name and a counter")
+	}
+	if len(lang.Suffixes) == 0 && lang.Hashbang == "" {
+		return fmt.Errorf("language %s claims no suffixes or hashbang", lang.Name)
+	}
+	registeredLanguages = append(registeredLanguages, lang)
+	return nil
+}
+
+// claims - does a registered language claim a path by its name?
+func (lang Language) claims(path string) bool {
+	for _, suffix := range lang.Suffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scons recipes are Python, found by name: the SConstruct at the top of
+// a tree and the SConscript files it reads from subdirectories.
+var sconsScripts = map[string]bool{
+	"SConstruct": true, "Sconstruct": true, "sconstruct": true,
+	"SConscript": true, "Sconscript": true, "sconscript": true,
+}
+
+// Generic - recognize lots of languages with generic syntax
+func (r *run) countGeneric(path string) []SourceStat {
+	ctx := r.newContext()
+	defer ctx.recycle()
+	return countContents(ctx, path)
+}
+
+// countContents - recognize and count a file, which may already have
+// been read into ctx
+func countContents(ctx *countContext, path string) (result []SourceStat) {
+	r := ctx.run
+	if r.Licenses {
+		defer func() { tagLicense(ctx, path, result) }()
+	}
+	if r.Digests {
+		defer func() { tagDigest(ctx, path, result) }()
+	}
+	defer func() {
+		if ctx.passed != "" && len(result) == 1 && result[0].SLOC == 0 {
+			result[0].Reason = ctx.passed
+		}
+	}()
+	if r.EmptyFiles {
+		defer func() {
+			if len(result) == 1 && result[0].SLOC == 0 && (result[0].Language != "" || ctx.nocode != "") {
+				result[0] = countEmptyFile(ctx, path)
+				ctx.passed = ""
+			}
+		}()
+	}
+	if r.CountEmbedded {
+		defer func() { result = embedRegions(ctx, path, result) }()
+	}
+
+	var singleStat SourceStat
+	singleStat.Path = path
+
+	// Generated files are normally dropped; on request they are
+	// counted, possibly under a bucket of their own.
+	isGenerated := false
+	defer func() {
+		if isGenerated && r.GeneratedBucket {
+			for i := range result {
+				if result[i].SLOC > 0 {
+					result[i].Language = "generated"
+				}
+			}
+		}
+	}()
+
+	autofilter := func(eolcomment string) bool {
+		if wasGeneratedAutomatically(ctx, path, eolcomment) {
+			if r.CountGenerated || r.GeneratedBucket {
+				if r.Debug > 0 {
+					fmt.Printf("automatic generation filter overridden: %s\n", path)
+				}
+				r.explain("generated-file filter: matched, but counting anyway")
+				isGenerated = true
+				return false
+			}
+			if r.Debug > 0 {
+				fmt.Printf("automatic generation filter failed: %s\n", path)
+			}
+			r.explain("generated-file filter: matched, skipping")
+			ctx.passed = "generated"
+			return true
+		}
+		r.explain("generated-file filter: passed")
+		if r.Debug > 0 {
+			fmt.Printf("automatic generation filter passed: %s\n", path)
+		}
+		return false
+	}
+
+	r.detect(ctx, path, func(c candidate) bool {
+		if c.verify != nil && !c.verify() {
+			return false
+		}
+		if c.filter && autofilter(c.comment) {
+			result = []SourceStat{singleStat}
+			return true
+		}
+		stats := c.count()
+		if c.settles || anyNonEmpty(stats) {
+			result = stats
+			return true
+		}
+		if stats[0].Language != "" {
+			ctx.nocode = c.name
+		}
+		singleStat = stats[0]
+		singleStat.Path = path
+		r.explain("%s counter found no code", c.name)
+		return false
+	})
+	if result != nil {
+		return result
+	}
+	if autofilter("#") {
+		return []SourceStat{{Path: path}}
+	}
+
+	// Without this fallthrough to returning an empty stat block,
+	// we'd get no report on unclassifiables.
+	return []SourceStat{singleStat}
+}
+
+// anyNonEmpty - did any of a file's results find code?
+func anyNonEmpty(stats []SourceStat) bool {
+	for _, st := range stats {
+		if st.nonEmpty() {
+			return true
+		}
+	}
+	return false
+}
+
+// A candidate is a language the recognition cascade offers for a file,
+// with what it takes to confirm and count it.
+type candidate struct {
+	name    string
+	verify  func() bool         // content check, where the name isn't enough
+	filter  bool                // apply the generated-code filter...
+	comment string              // ...with this winged-comment leader
+	count   func() []SourceStat // count the file as this language
+	settles bool                // later candidates aren't tried, whatever count finds
+}
+
+// detect - offer the languages a file might be, in the order they are
+// tried, until offer accepts one.  Counting and identify both recognize
+// files this way.  With a nil context only the path is consulted: no
+// hashbang lines, modelines or minified text are looked for, and
+// nothing can be verified or counted.
+func (r *run) detect(ctx *countContext, path string, offer func(candidate) bool) {
+	if name := r.pathLanguage(path); name != "" {
+		r.explain("path rule assigns %s", name)
+		if offer(candidate{name: name, settles: true,
+			count: func() []SourceStat { return r.countAs(path, name) }}) {
+			return
+		}
+	}
+
+	if why := r.minified(ctx, path); why != "" {
+		r.explain("%s: minified", why)
+		if offer(candidate{name: "minified", settles: true,
+			count: func() []SourceStat { return []SourceStat{countMinifiedLines(ctx, path)} }}) {
+			return
+		}
+	}
+
+	for _, lang := range registeredLanguages {
+		if !lang.claims(path) && (ctx == nil || lang.Hashbang == "" || !hashbang(ctx, path, lang.Hashbang)) {
+			continue
+		}
+		r.explain("registered language %s claims the file", lang.Name)
+		c := candidate{name: lang.Name, filter: lang.Comment != "", comment: lang.Comment}
+		if lang.Verifier != nil {
+			c.verify = func() bool {
+				ok := lang.Verifier(r.sourcePath(path))
+				r.explain("%s verifier: %t", lang.Name, ok)
+				if !ok {
+					ctx.passed = "verifier"
+				}
+				return ok
+			}
+		}
+		c.count = func() []SourceStat {
+			st := lang.Counter.Count(r.sourcePath(path))
+			st.Path = path
+			if st.Language == "" {
+				st.Language = lang.Name
+			}
+			return []SourceStat{st}
+		}
+		if offer(c) {
+			return
+		}
+	}
+
+	for i := range genericLanguages {
+		lang := genericLanguages[i]
+		if !strings.HasSuffix(path, lang.suffix) {
+			continue
+		}
+		r.explain("suffix %s matches %s", lang.suffix, lang.name)
+		c := candidate{name: lang.name, filter: true, comment: lang.eolcomment}
+		if verifier := lang.verifier; verifier != nil {
+			// Verified here, so the counter needn't do it again
+			c.verify = func() bool { return verified(ctx, path, lang.name, verifier) }
+			lang.verifier = nil
+		}
+		c.count = func() []SourceStat {
+			if len(lang.commentleader) > 0 || lang.rawstrings != nil {
+				if lang.name == "sql" && isMySQL(ctx, path) {
+					lang.flags |= mysql
+				}
+				stats := cFamilyCounter(ctx, path, lang)
+				if strings.HasSuffix(path, ".go") {
+					stats[0].LLOC = goCounter(ctx, path)
+				}
+				return stats
+			}
+			st := genericCounter(ctx, path, lang)
+			if st.nonEmpty() && r.SplitRecipes && lang.name == "makefile" {
+				return recipeSplit(ctx, path, st)
+			}
+			return []SourceStat{st}
+		}
+		if offer(c) {
+			return
+		}
+	}
+
+	if dedicatedMatch(ctx, path, "python") {
+		r.explain("suffix or hashbang matches python")
+		if offer(candidate{name: "python", filter: true, comment: "#", settles: true,
+			count: func() []SourceStat { return countDedicated(ctx, path, "python") }}) {
+			return
+		}
+	}
+
+	if dedicatedMatch(ctx, path, "perl") {
+		r.explain("suffix or hashbang matches perl")
+		if offer(candidate{name: "perl", filter: true, comment: "#", settles: true,
+			count: func() []SourceStat { return countDedicated(ctx, path, "perl") }}) {
+			return
+		}
+	}
+
+	if filepath.Base(path) == "wscript" {
+		r.explain("basename wscript matches waf")
+		if offer(candidate{name: "waf", filter: true, comment: "#", settles: true,
+			count: func() []SourceStat { return countDedicated(ctx, path, "waf") }}) {
+			return
+		}
+	}
+
+	if sconsScripts[filepath.Base(path)] {
+		r.explain("basename %s matches scons", filepath.Base(path))
+		if offer(candidate{name: "scons", filter: true, comment: "#", settles: true,
+			count: func() []SourceStat { return countDedicated(ctx, path, "scons") }}) {
+			return
+		}
+	}
+
+	for i := range scriptingLanguages {
+		lang := scriptingLanguages[i]
+		if !strings.HasSuffix(path, lang.suffix) && (ctx == nil || !hashbang(ctx, path, lang.hashbang)) {
+			continue
+		}
+		r.explain("suffix %s or hashbang %s matches %s", lang.suffix, lang.hashbang, lang.name)
+		c := candidate{name: lang.name, filter: true, comment: "#", settles: true}
+		c.count = func() []SourceStat {
+			st := genericCounter(ctx, path,
+				genericLanguage{
+					name:       lang.name,
+					eolcomment: "#",
+					heredoc:    lang.heredoc,
+				})
+			st.Language = lang.name
+			return []SourceStat{st}
+		}
+		if offer(c) {
+			return
+		}
+	}
+
+	for i := range pascalLikes {
+		lang := pascalLikes[i]
+		if !strings.HasSuffix(path, lang.suffix) {
+			continue
+		}
+		r.explain("suffix %s matches %s", lang.suffix, lang.name)
+		c := candidate{name: lang.name, filter: true, comment: "#"}
+		if verifier := lang.verifier; verifier != nil {
+			c.verify = func() bool { return verified(ctx, path, lang.name, verifier) }
+			lang.verifier = nil
+		}
+		c.count = func() []SourceStat {
+			st := pascalCounter(ctx, path, lang)
+			st.Language = lang.name
+			return []SourceStat{st}
+		}
+		if offer(c) {
+			return
+		}
+	}
+
+	for i := range fortranLikes {
+		lang := fortranLikes[i]
+		if !strings.HasSuffix(path, lang.suffix) {
+			continue
+		}
+		r.explain("suffix %s matches %s", lang.suffix, lang.name)
+		c := candidate{name: lang.name, filter: true, comment: "#"}
+		c.count = func() []SourceStat {
+			st := fortranCounter(ctx, path, lang)
+			st.Language = lang.name
+			return []SourceStat{st}
+		}
+		if offer(c) {
+			return
+		}
+	}
+
+	if ctx == nil {
+		return
+	}
+	if name := modelineLanguage(ctx, path); name != "" {
+		offer(candidate{name: name, filter: true, comment: "#", settles: true,
+			count: func() []SourceStat { return r.countAs(path, name) }})
+	}
+}
+
+// countDedicated - count a file with the Python or Perl counter
+func countDedicated(ctx *countContext, path string, name string) []SourceStat {
+	var st SourceStat
+	if name == "perl" {
+		st = perlCounter(ctx, path)
+	} else {
+		st = pythonCounter(ctx, path)
+	}
+	st.Language = name
+	return []SourceStat{st}
+}
+
+// Editor modelines.  A file that nothing else identifies may still
+// say what it is in an Emacs "-*- mode: foo -*-" line or a vim
+// "vim: set ft=foo" line within its first or last five lines.
+
+var emacsModeline = regexp.MustCompile(`-\*-\s*(?:.*;\s*)?(?i:mode)\s*:\s*([\w+#-]+)\s*;?.*-\*-|-\*-\s*([\w+#-]+)\s*-\*-`)
+var vimModeline = regexp.MustCompile(`\b(?:vi|vim|ex):.*\b(?:ft|filetype|syntax)=([\w+#-]+)`)
+
+// modelineAliases - editor mode names that differ from ours
+var modelineAliases = map[string]string{
+	"sh":         "shell",
+	"bash":       "shell",
+	"ksh":        "shell",
+	"cpp":        "c++",
+	"js":         "javascript",
+	"js2":        "javascript",
+	"objc":       "objective-c",
+	"make":       "makefile",
+	"python3":    "python",
+	"cperl":      "perl",
+	"emacs-lisp": "elisp",
+	"fortran":    "fortran90",
+	"f90":        "fortran90",
+	"tcl":        "tcl",
+	"octave":     "matlab",
+	"cs":         "c#",
+	"csharp":     "c#",
+}
+
+// modelineLanguage - the language named by a modeline in a file, if any
+func modelineLanguage(ctx *countContext, path string) string {
+	if !ctx.setup(path) {
+		return ""
+	}
+	defer ctx.teardown()
+	var lines [][]byte
+	for {
+		line, err := ctx.readline()
+		if len(line) > 0 {
+			lines = append(lines, line)
+			// Keep the first five lines and a window of the last five
+			if len(lines) > 10 {
+				lines = append(lines[:5], lines[6:]...)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	for _, line := range lines {
+		var mode string
+		if m := emacsModeline.FindSubmatch(line); m != nil {
+			mode = string(m[1]) + string(m[2])
+		} else if m := vimModeline.FindSubmatch(line); m != nil {
+			mode = string(m[1])
+		} else {
+			continue
+		}
+		mode = strings.ToLower(mode)
+		if alias, ok := modelineAliases[mode]; ok {
+			mode = alias
+		}
+		if knownLanguage(mode) {
+			ctx.run.explain("modeline names %s", mode)
+			return mode
+		}
+	}
+	return ""
+}
+
+// Identify - detect the language of a file without counting it
+func Identify(path string, opts Options) string {
+	r := newRun(context.Background(), opts)
+	ctx := r.newContext()
+	defer ctx.recycle()
+	name := "unknown"
+	r.detect(ctx, path, func(c candidate) bool {
+		if c.verify != nil && !c.verify() {
+			return false
+		}
+		name = c.name
+		return true
+	})
+	return name
+}
+
+// guessLanguages - the languages a path might be counted as, judging by
+// its name alone.  Where verifiers would have to read the file to
+// decide, all the candidates are listed in the order they are tried.
+func (r *run) guessLanguages(path string) []string {
+	var candidates []string
+	r.detect(nil, path, func(c candidate) bool {
+		seen := false
+		for _, name := range candidates {
+			seen = seen || name == c.name
+		}
+		if !seen {
+			candidates = append(candidates, c.name)
+		}
+		return c.verify == nil
+	})
+	if candidates == nil {
+		// Might yet be claimed by a hashbang line
+		candidates = []string{"unknown"}
+	}
+	return candidates
+}
+
+func (r *run) isDirectory(path string) bool {
+	fileInfo, err := fs.Stat(r.source, path)
+	return err == nil && fileInfo.Mode().IsDir()
+}
+
+func (r *run) isRegular(path string) bool {
+	fileInfo, err := fs.Stat(r.source, path)
+	return err == nil && fileInfo.Mode().IsRegular()
+}
+
+// rejectReason - name the filter that would winnow out a path, or ""
+func (r *run) rejectReason(path string) string {
+	suffix := filepath.Ext(path)
+	if suffix != "" && neverInterestingBySuffix[suffix] && forcedLanguages[suffix] == "" {
+		switch {
+		case r.CountPostScript && (suffix == ".ps" || suffix == ".eps"):
+		case r.CountEmbedded && (suffix == ".html" || suffix == ".htm"):
+		default:
+			return "suffix"
+		}
+	}
+	for i := range neverInterestingByPrefix {
+		if strings.HasPrefix(path, neverInterestingByPrefix[i]) {
+			return "prefix"
+		}
+	}
+	for i := range neverInterestingByInfix {
+		if strings.Contains(path, neverInterestingByInfix[i]) {
+			return "infix"
+		}
+	}
+	basename := filepath.Base(path)
+	if neverInterestingByBasename[strings.ToLower(basename)] {
+		return "basename"
+	}
+	if r.Exclude != nil && r.Exclude.MatchString(path) {
+		return "exclusion"
+	}
+	if r.pathLanguage(path) == "ignore" {
+		return "path-rule"
+	}
+	if r.tracked != nil && !r.tracked[path] {
+		return "untracked"
+	}
+	if r.Submodules == "exclude" && r.submoduleOf(path) == path {
+		return "submodule"
+	}
+	if r.ignoreActive && r.gitignored(path, r.isDirectory(path)) {
+		return "gitignore"
+	}
+
+	/* has to come after the infix check for directory */
+	if r.isDirectory(path) {
+		return "directory"
+	} else if !r.isRegular(path) {
+		return "regular-file"
+	}
+
+	/* toss generated Makefiles */
+	if basename == "Makefile" {
+		if _, err := fs.Stat(r.source, path+".in"); err == nil {
+			return "generated-makefile"
+		}
+	}
+	return ""
+}
+
+// A file reached by more than one hard link, or a directory reached
+// again through a bind mount, is counted only the first time the walk
+// comes to it unless CountHardlinks is set.  Files are known by device
+// and inode number, where the platform has them.
+
+type fileID struct {
+	device uint64
+	inode  uint64
+}
+
+// seenBefore - has the walk already come to this file by another path?
+func (r *run) seenBefore(info fs.FileInfo) bool {
+	if r.CountHardlinks || info == nil || !(info.Mode().IsRegular() || info.IsDir()) {
+		return false
+	}
+	id, ok := fileIdentity(info)
+	if !ok {
+		return false
+	}
+	r.seenLock.Lock()
+	defer r.seenLock.Unlock()
+	if r.seenFiles[id] {
+		return true
+	}
+	r.seenFiles[id] = true
+	return false
+}
+
+// skip - tell the caller about a path the filters leave out
+func (r *run) skip(path string, reason string, dir bool) {
+	if r.Skipped != nil {
+		r.Skipped(path, reason, dir)
+	}
+	if r.DryRun && !dir {
+		r.pipeline <- SourceStat{Path: path, Reason: reason}
+	}
+}
+
+// filter - winnows out uninteresting paths before handing them to process
+func (r *run) filter(path string, info fs.FileInfo, err error) error {
+	if r.Debug > 0 {
+		fmt.Printf("entering filter: %s\n", path)
+	}
+	if err == nil && r.seenBefore(info) {
+		if r.Debug > 0 {
+			fmt.Printf("already seen: %s\n", path)
+		}
+		if info.IsDir() {
+			r.skip(path, "bind-mount", true)
+			return filepath.SkipDir
+		}
+		r.skip(path, "hardlink", false)
+		return nil
+	}
+	if reason := r.rejectReason(path); reason != "" {
+		if r.Debug > 0 {
+			fmt.Printf("%s filter failed: %s\n", reason, path)
+		}
+		if r.isDirectory(path) {
+			if reason == "infix" || reason == "path-rule" || reason == "untracked" || reason == "submodule" || reason == "gitignore" {
+				if r.Debug > 0 {
+					fmt.Printf("directory skipped: %s\n", path)
+				}
+				r.skip(path, reason, true)
+				return filepath.SkipDir
+			}
+			return err
+		}
+		r.skip(path, reason, false)
+		return err
+	}
+
+	if r.Debug > 0 {
+		fmt.Printf("passed filter: %s\n", path)
+	}
+
+	if r.DryRun {
+		guesses := r.guessLanguages(path)
+		if r.LinguistNames {
+			for i := range guesses {
+				guesses[i] = LinguistName(guesses[i])
+			}
+		}
+		r.pipeline <- SourceStat{Path: path, Language: strings.Join(guesses, "/")}
+		return err
+	}
+
+	// Hand the file to the counting workers, so the walk can go on
+	// while it is read.
+	if r.countQueue != nil {
+		r.countQueue <- visitData{path, info}
+	} else {
+		r.count(path, info)
+	}
+
+	return err
+}
+
+// count - the real work: count a file and pass on its statistics
+func (r *run) count(path string, info fs.FileInfo) {
+	began := time.Now()
+	results := r.countCached(path, info)
+	var section string
+	if r.PerRoot {
+		section = r.root
+	}
+	if r.Submodules == "separate" {
+		if sub := r.submoduleOf(path); sub != "" {
+			section = filepath.Join(r.submoduleRoot, sub)
+		}
+	}
+	if r.SplitTests && r.IsTest(path) {
+		section = strings.TrimSpace(section + " tests")
+	}
+	// Copy, as the results may be shared with a cache
+	results = append([]SourceStat(nil), results...)
+	for i := range results {
+		results[i].Section = section
+		if r.LinguistNames {
+			results[i].Language = LinguistName(results[i].Language)
+		}
+		if info != nil {
+			results[i].Size = info.Size()
+		}
+		if r.Digests {
+			// Paths are relative to their root, so two
+			// roots can hold the same one
+			results[i].Origin = r.sourcePath(path)
+		}
+	}
+	for _, st := range results {
+		r.pipeline <- st
+	}
+	if r.Counted != nil {
+		r.Counted(path, info, results, time.Since(began))
+	}
+}
+
+// Counting is done by a pool of workers fed by the walker, so that
+// directory traversal doesn't stall behind slow files.  Reads of files
+// and directories share a cap on how many may be open at once.
+
+// countTree - walk a tree, counting its files on a pool of workers
+func (r *run) countTree(ctx context.Context, root string) error {
+	r.countQueue = make(chan visitData, r.Jobs)
+	var workers sync.WaitGroup
+	for i := 0; i < r.Jobs; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for file := range r.countQueue {
+				if ctx.Err() == nil {
+					r.count(file.path, file.info)
+				}
+			}
+		}()
+	}
+	err := r.walk(ctx, root, r.filter)
+	close(r.countQueue)
+	workers.Wait()
+	r.countQueue = nil
+	return err
+}
+
+// openFile, closeFile - bracket any time a file descriptor is held
+func (r *run) openFile() {
+	if r.openFiles != nil {
+		r.openFiles <- struct{}{}
+	}
+}
+
+func (r *run) closeFile() {
+	if r.openFiles != nil {
+		<-r.openFiles
+	}
+}
+
+// Incremental counting.  Results are remembered per absolute path,
+// keyed by size and modification time, so that a rerun only has to
+// rescan files that changed.  Each entry also carries the salt of the
+// run that made it, so a new version or different counting options
+// rescan everything.
+
+type cacheEntry struct {
+	Size     int64
+	Mtime    int64
+	Stats    []SourceStat
+	Licenses bool   `json:",omitempty"` // Stats carry licenses
+	Digests  bool   `json:",omitempty"` // Stats carry digests
+	Salt     string `json:",omitempty"` // Options.Salt when counted
+}
+
+// A Cache holds counts to be reused, from earlier runs or this one.
+// It may be shared by runs going on at once.
+type Cache struct {
+	lock  sync.Mutex
+	old   map[string]cacheEntry // entries loaded from the cache file
+	fresh map[string]cacheEntry // entries seen on this run
+
+	blobLock sync.Mutex
+	blobs    map[string][]SourceStat
+}
+
+// NewCache - make an empty cache
+func NewCache() *Cache {
+	return &Cache{
+		old:   make(map[string]cacheEntry),
+		fresh: make(map[string]cacheEntry),
+		blobs: make(map[string][]SourceStat),
+	}
+}
+
+// LoadCache - read a cache file; a missing or unreadable one is empty
+func LoadCache(path string) *Cache {
+	c := NewCache()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c
+	}
+	if err = encjson.Unmarshal(content, &c.old); err != nil {
+		fmt.Fprintf(os.Stderr, "loccount: ignoring malformed cache %s: %v\n", path, err)
+		c.old = make(map[string]cacheEntry)
+	}
+	return c
+}
+
+// Advance - make the entries for files seen since the cache was loaded,
+// or last advanced, those reused by later counts
+func (c *Cache) Advance() {
+	c.lock.Lock()
+	for key, entry := range c.fresh {
+		c.old[key] = entry
+	}
+	c.lock.Unlock()
+}
+
+// Save - write out the entries for files seen on this run
+func (c *Cache) Save(path string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	content, err := encjson.Marshal(c.fresh)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// countCached - count a file, reusing a cached result if it is unchanged
+func (r *run) countCached(path string, info fs.FileInfo) []SourceStat {
+	if blob, ok := info.(*gitEntry); ok {
+		return r.countBlob(path, blob)
+	}
+	cache := r.Cache
+	if _, ok := r.source.(osFS); cache == nil || !ok {
+		return r.countHashed(path)
+	}
+	key, err := filepath.Abs(r.sourcePath(path))
+	if err != nil || info == nil {
+		return r.countHashed(path)
+	}
+	cache.lock.Lock()
+	entry, ok := cache.old[key]
+	cache.lock.Unlock()
+	if ok && entry.Salt == r.Salt && entry.Size == info.Size() && entry.Mtime == info.ModTime().UnixNano() && (entry.Licenses || !r.Licenses) && (entry.Digests || !r.Digests) {
+		if r.Debug > 0 {
+			fmt.Printf("cache hit: %s\n", path)
+		}
+		// Paths are stored as seen on the run that made the entry
+		for i := range entry.Stats {
+			entry.Stats[i].Path = path
+		}
+	} else {
+		entry = cacheEntry{info.Size(), info.ModTime().UnixNano(), r.countHashed(path), r.Licenses, r.Digests, r.Salt}
+		if r.cancelled() {
+			// Counted only in part
+			return entry.Stats
+		}
+	}
+	cache.lock.Lock()
+	cache.fresh[key] = entry
+	cache.lock.Unlock()
+	return entry.Stats
+}
+
+// The content cache keeps per-file results in a directory, keyed by a
+// hash of each file's contents and its path within the tree, so fresh
+// checkouts on CI runners can share results through a common directory.
+// Entries of both caches are salted with the version and the options
+// that affect classification, so a change to either invalidates them.
+
+// countHashed - count a file, reusing a result stored under its hash
+func (r *run) countHashed(path string) []SourceStat {
+	if r.CacheDir == "" {
+		return r.countGeneric(path)
+	}
+	// The text read for the hash is the one counted
+	ctx := r.newContext()
+	defer ctx.recycle()
+	if !ctx.setup(path) {
+		return []SourceStat{{Path: path}}
+	}
+	sum := sha256.New()
+	io.WriteString(sum, r.Salt+"\x00"+filepath.ToSlash(path)+"\x00")
+	sum.Write(ctx.text)
+	key := hex.EncodeToString(sum.Sum(nil))
+	entry := filepath.Join(r.CacheDir, key[:2], key[2:]+".json")
+
+	var stats []SourceStat
+	if cached, err := ioutil.ReadFile(entry); err == nil && encjson.Unmarshal(cached, &stats) == nil {
+		if r.Debug > 0 {
+			fmt.Printf("content cache hit: %s\n", path)
+		}
+		for i := range stats {
+			stats[i].Path = path
+		}
+		return stats
+	}
+	stats = countContents(ctx, path)
+	if r.cancelled() {
+		// Counted only in part
+		return stats
+	}
+	if encoded, err := encjson.Marshal(stats); err == nil {
+		// Write then rename, so runners sharing the directory
+		// never see a partial entry.
+		os.MkdirAll(filepath.Dir(entry), 0755)
+		if tmp, err := ioutil.TempFile(filepath.Dir(entry), "tmp"); err == nil {
+			_, err = tmp.Write(encoded)
+			tmp.Close()
+			if err == nil {
+				err = os.Rename(tmp.Name(), entry)
+			}
+			if err != nil {
+				os.Remove(tmp.Name())
+			}
+		}
+	}
+	return stats
+}
+
+// StreamPaths starts counting the given files and directories and
+// returns a channel on which a SourceStat arrives for each file as it
+// is counted, unclassified files included.  The channel, buffered to
+// the given depth, is closed when the walk is done or ctx is cancelled;
+// files being counted at cancellation are cut short, and their counts
+// are partial.
+//
+// With DryRun set nothing is counted: each file that would be gets a
+// SourceStat giving the languages it might be, slash-separated, and
+// each file filtered out one giving the reason.
+func StreamPaths(ctx context.Context, roots []string, depth int, opts Options) <-chan SourceStat {
+	r := newRun(ctx, opts)
+	r.pipeline = make(chan SourceStat, depth)
+	r.openFiles = make(chan struct{}, r.MaxOpenFiles)
+	base := r.source
+	go func() {
+		for i := range roots {
+			if ctx.Err() != nil {
+				break
+			}
+			r.root = roots[i]
+			r.ignoreActive = false
+			fi, err := fs.Stat(base, roots[i])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				break
+			}
+			if fi.Mode().IsDir() {
+				// Walk from inside the directory, so reported
+				// paths are relative to it.
+				if r.source, err = subSource(base, roots[i]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					break
+				}
+				r.findIgnoreTop()
+				if _, ok := r.source.(osFS); ok && r.Submodules != "" {
+					r.submoduleRoot, r.submodules = roots[i], findSubmodules(r.sourcePath("."))
+				}
+				if _, ok := r.source.(osFS); ok && r.VCSOnly {
+					if r.tracked, err = trackedFiles(r.sourcePath(".")); err != nil {
+						fmt.Fprintf(os.Stderr, "loccount: %v\n", err)
+						r.source = base
+						continue
+					}
+				}
+				// The system filepath.Walk() works here,
+				// but is slower.
+				r.countTree(ctx, ".")
+				r.source, r.tracked, r.submodules = base, nil, nil
+			} else {
+				r.filter(roots[i], fi, nil)
+			}
+		}
+		close(r.pipeline)
+	}()
+	return r.pipeline
+}
+
+// CountFile counts one file, whatever its name, with the default
+// settings and without the filters that keep paths out of a tree walk.
+// A file in no recognized language yields a SourceStat with no
+// language.  Where a file is reported in parts, the first is returned.
+func CountFile(path string) (SourceStat, error) {
+	parts, err := CountFileParts(path, Options{})
+	if err != nil {
+		return SourceStat{}, err
+	}
+	return parts[0], nil
+}
+
+// CountFileParts counts one file as CountFile does, but with the given
+// settings, returning every part the file is reported in: there is
+// more than one where embedded code or makefile recipes are split out.
+func CountFileParts(path string, opts Options) ([]SourceStat, error) {
+	r := newRun(context.Background(), opts)
+	if _, err := fs.Stat(r.source, path); err != nil {
+		return nil, err
+	}
+	return r.countGeneric(path), nil
+}
+
+// CountTree counts the files under root, returning a SourceStat for each
+// as CountPaths would emit it.  Runs share no state, so any number may
+// go on at once.
+func CountTree(root string, opts Options) ([]SourceStat, error) {
+	source := opts.Source
+	if source == nil {
+		source = osFS{}
+	}
+	if _, err := fs.Stat(source, root); err != nil {
+		return nil, err
+	}
+	var stats []SourceStat
+	err := CountPaths(context.Background(), []string{root}, opts, func(st SourceStat) {
+		stats = append(stats, st)
+	})
+	return stats, err
+}
+
+// CountPaths counts the given files and directories, calling emit with
+// each SourceStat as it is produced.  Calls to emit are serialized.
+// The error is that of ctx if the run was cut short.
+func CountPaths(ctx context.Context, roots []string, opts Options, emit func(SourceStat)) error {
+	for st := range StreamPaths(ctx, roots, opts.Jobs, opts) {
+		emit(st)
+	}
+	return ctx.Err()
+}
+
+// trackedFiles - the files git tracks under a directory, submodules
+// included, with the directories that hold them
+func trackedFiles(dir string) (map[string]bool, error) {
+	listing, err := exec.Command("git", "-C", dir, "ls-files", "-z", "--recurse-submodules").Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("%s", bytes.TrimSpace(exit.Stderr))
+		}
+		return nil, fmt.Errorf("git ls-files in %s: %v", dir, err)
+	}
+	files := map[string]bool{".": true}
+	for _, path := range strings.Split(string(listing), "\x00") {
+		for path != "" && path != "." && !files[path] {
+			files[path] = true
+			path = filepath.Dir(path)
+		}
+	}
+	return files, nil
+}
+
+// findSubmodules - list the checked-out submodules of the git working
+// tree at dir, innermost first, warning about any not checked out
+func findSubmodules(dir string) []string {
+	listing, err := exec.Command("git", "-C", dir, "submodule", "status", "--recursive").Output()
+	if err != nil {
+		return nil
+	}
+	var found []string
+	for _, line := range strings.Split(string(listing), "\n") {
+		// <state><object> <path> (<description>)
+		if len(line) < 2 {
+			continue
+		}
+		sp := strings.IndexByte(line[1:], ' ')
+		if sp < 0 {
+			continue
+		}
+		path := line[sp+2:]
+		if i := strings.LastIndex(path, " ("); i > -1 {
+			path = path[:i]
+		}
+		if line[0] == '-' {
+			fmt.Fprintf(os.Stderr, "loccount: submodule %s is not checked out\n", filepath.Join(dir, path))
+			continue
+		}
+		found = append(found, path)
+	}
+	sort.Slice(found, func(i, j int) bool { return len(found[i]) > len(found[j]) })
+	return found
+}
+
+// submoduleOf - the submodule of the root being walked that a path is
+// in, or ""
+func (r *run) submoduleOf(path string) string {
+	for _, sub := range r.submodules {
+		if path == sub || strings.HasPrefix(path, sub+"/") {
+			return sub
+		}
+	}
+	return ""
+}
+
+// Results for git blobs are kept in the Cache, if there is one, keyed by
+// path and object name, so a file unchanged between revisions is counted
+// only once.
+
+// countBlob - count a file of a git revision, if not already counted
+func (r *run) countBlob(path string, blob *gitEntry) []SourceStat {
+	c := r.Cache
+	if c == nil {
+		return r.countHashed(path)
+	}
+	key := path + "\x00" + blob.object
+	c.blobLock.Lock()
+	stats, ok := c.blobs[key]
+	c.blobLock.Unlock()
+	if !ok {
+		stats = r.countHashed(path)
+		c.blobLock.Lock()
+		c.blobs[key] = stats
+		c.blobLock.Unlock()
+	}
+	return stats
+}
+
+// Languages lists all languages for which we can extract line counts,
+// or with lloc those for which logical lines are counted too.  It also
+// performs a sanity check on identifying file extemsions and
+// interpreter names, returning an error for each duplicate.
+func Languages(lloc bool) ([]string, []error) {
+	names := []string{"python", "waf", "scons", "perl", "go"}
+	var lastlang string
+	counts := make(map[string]int)
+	var duplicates []error
+	for _, lang := range registeredLanguages {
+		if lang.Name != lastlang && (!lloc || lang.Logical) {
+			names = append(names, lang.Name)
+			lastlang = lang.Name
+		}
+	}
+	for i := range genericLanguages {
+		lang := genericLanguages[i]
+		if lang.verifier == nil {
+			counts[lang.suffix]++
+		}
+		if counts[lang.suffix] > 1 {
+			duplicates = append(duplicates, fmt.Errorf("extension %s duplicated", lang.suffix))
+		}
+		if lang.name != lastlang {
+			if !lloc || len(genericLanguages[i].terminator) > 0 {
+				names = append(names, lang.name)
+				lastlang = lang.name
+			}
+		}
+	}
+
+	for i := range pascalLikes {
+		lang := pascalLikes[i]
+		if lang.verifier == nil {
+			counts[lang.suffix]++
+		}
+		if counts[lang.suffix] > 1 {
+			duplicates = append(duplicates, fmt.Errorf("extension %s duplicated", lang.suffix))
+		}
+		if lang.name != lastlang {
+			if !lloc || len(pascalLikes[i].terminator) > 0 {
+				names = append(names, lang.name)
+				lastlang = lang.name
+			}
+		}
+	}
+
+	if !lloc {
+		for i := range scriptingLanguages {
+			lang := scriptingLanguages[i]
+			if lang.verifier == nil {
+				counts[lang.hashbang]++
+			}
+			if counts[lang.hashbang] > 1 {
+				duplicates = append(duplicates, fmt.Errorf("hashbang %s duplicated", lang.suffix))
+			}
+			if lang.name != lastlang {
+				names = append(names, lang.name)
+				lastlang = lang.name
+			}
+		}
+
+	}
+	for i := range fortranLikes {
+		lang := fortranLikes[i]
+		if counts[lang.suffix] > 1 {
+			duplicates = append(duplicates, fmt.Errorf("extension %s duplicated", lang.suffix))
+		}
+		counts[lang.suffix]++
+		if lang.name != lastlang {
+			names = append(names, lang.name)
+			lastlang = lang.name
+		}
+	}
+	// A language may be in more than one table
+	sort.Strings(names)
+	unique := names[:0]
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			unique = append(unique, name)
+		}
+	}
+	return unique, duplicates
+}
+
+// Extensions - the suffixes and file names that mark each language
+func Extensions() map[string][]string {
+	extensions := map[string][]string{
+		"python": {".py"},
+		"waf":    {"waf"},
+		"scons":  {"SConstruct", "SConscript"},
+		"perl":   {"pl", "pm"},
+	}
+	for _, lang := range registeredLanguages {
+		extensions[lang.Name] = append(extensions[lang.Name], lang.Suffixes...)
+	}
+	for i := range genericLanguages {
+		lang := genericLanguages[i]
+		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	}
+
+	for i := range scriptingLanguages {
+		lang := scriptingLanguages[i]
+		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	}
+
+	for i := range pascalLikes {
+		lang := pascalLikes[i]
+		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	}
+
+	for i := range fortranLikes {
+		lang := fortranLikes[i]
+		extensions[lang.name] = append(extensions[lang.name], lang.suffix)
+	}
+	return extensions
+}
+
+// LanguageRecord - one row of the machine-readable language table
+type LanguageRecord struct {
+	Name         string   `json:"name"`
+	Class        string   `json:"class"`
+	Extensions   []string `json:"extensions,omitempty"`
+	Hashbang     string   `json:"hashbang,omitempty"`
+	BlockComment []string `json:"block_comment,omitempty"`
+	LineComment  string   `json:"line_comment,omitempty"`
+	CommentRE    string   `json:"comment_pattern,omitempty"`
+	MultiString  string   `json:"multistring,omitempty"`
+	Terminator   string   `json:"terminator,omitempty"`
+	Flags        []string `json:"flags,omitempty"`
+	LLOC         bool     `json:"lloc"`
+	Verifier     bool     `json:"verifier"`
+}
+
+// LanguageTable - the language tables, ready to ship as a JSON array.
+// Entries differing only in extension are merged.
+func LanguageTable() []LanguageRecord {
+	var records []LanguageRecord
+	add := func(r LanguageRecord, suffix string) {
+		if n := len(records); n > 0 {
+			last := records[n-1]
+			last.Extensions = r.Extensions
+			if reflect.DeepEqual(last, r) {
+				records[n-1].Extensions = append(records[n-1].Extensions, suffix)
+				return
+			}
+		}
+		r.Extensions = []string{suffix}
+		records = append(records, r)
+	}
+	for _, lang := range registeredLanguages {
+		records = append(records, LanguageRecord{Name: lang.Name, Class: "registered",
+			Extensions: lang.Suffixes, Hashbang: lang.Hashbang, LineComment: lang.Comment,
+			LLOC: lang.Logical, Verifier: lang.Verifier != nil})
+	}
+	flagnames := make([]string, 0, len(syntaxFlags))
+	for name := range syntaxFlags {
+		flagnames = append(flagnames, name)
+	}
+	sort.Strings(flagnames)
+	for _, lang := range genericLanguages {
+		r := LanguageRecord{Name: lang.name, Class: "generic",
+			LineComment: lang.eolcomment, MultiString: lang.multistring,
+			Terminator: lang.terminator, Verifier: lang.verifier != nil}
+		if lang.commentleader != "" {
+			r.BlockComment = []string{lang.commentleader, lang.commenttrailer}
+		}
+		for _, name := range flagnames {
+			if lang.property(syntaxFlags[name]) {
+				r.Flags = append(r.Flags, name)
+			}
+		}
+		r.LLOC = r.Terminator != "" || lang.name == "go"
+		add(r, lang.suffix)
+	}
+	records = append(records,
+		LanguageRecord{Name: "python", Class: "builtin", Extensions: []string{".py"},
+			Hashbang: "python", LineComment: "#", MultiString: dt, LLOC: true},
+		LanguageRecord{Name: "waf", Class: "builtin", Extensions: []string{"wscript"},
+			LineComment: "#", MultiString: dt, LLOC: true},
+		LanguageRecord{Name: "scons", Class: "builtin", Extensions: []string{"SConstruct", "SConscript"},
+			LineComment: "#", MultiString: dt, LLOC: true},
+		LanguageRecord{Name: "perl", Class: "builtin", Extensions: []string{".pl", ".pm", ".ph"},
+			Hashbang: "perl", LineComment: "#", Terminator: ";", LLOC: true})
+	for _, lang := range scriptingLanguages {
+		r := LanguageRecord{Name: lang.name, Class: "scripting", Hashbang: lang.hashbang,
+			LineComment: "#", Verifier: lang.verifier != nil}
+		add(r, lang.suffix)
+	}
+	for _, lang := range pascalLikes {
+		r := LanguageRecord{Name: lang.name, Class: "pascal",
+			BlockComment: []string{"(*", "*)"}, Terminator: lang.terminator,
+			LLOC: lang.terminator != "", Verifier: lang.verifier != nil}
+		if lang.bracketcomments {
+			r.Flags = []string{"bracketcomments"}
+		}
+		add(r, lang.suffix)
+	}
+	for _, lang := range fortranLikes {
+		r := LanguageRecord{Name: lang.name, Class: "fortran",
+			CommentRE: lang.comment.String()}
+		add(r, lang.suffix)
+	}
+	return records
+}
+
+var languageGroups = map[string]string{
+	"php3": "php", "php4": "php", "php5": "php", "php6": "php", "php7": "php",
+	"fortran90": "fortran", "fortran95": "fortran", "fortran03": "fortran",
+	"oberon2": "oberon",
+}
+
+// BaseLanguage - the language of which a name is a version or dialect,
+// or the name itself
+func BaseLanguage(name string) string {
+	if group, ok := languageGroups[name]; ok {
+		return group
+	}
+	return name
+}
+
+// With LinguistNames, languages go by the names GitHub's Linguist gives
+// them, so reports can be set beside its language bar.  Versions and
+// dialects all take the one name, and C headers count as C, as Linguist
+// counts them.  Languages Linguist doesn't know keep their own names.
+
+var linguistTable = map[string]string{
+	"ada": "Ada", "arduino": "C++", "asm": "Assembly", "awk": "Awk",
+	"ballerina": "Ballerina", "c": "C", "c#": "C#", "c++": "C++",
+	"c-header": "C", "chapel": "Chapel", "clojure": "Clojure",
+	"clojurescript": "Clojure", "cobol": "COBOL",
+	"coffeescript": "CoffeeScript", "coq": "Coq", "csh": "Tcsh",
+	"css": "CSS", "d": "D", "dart": "Dart", "eiffel": "Eiffel",
+	"elisp": "Emacs Lisp", "erlang": "Erlang", "f#": "F#",
+	"fennel": "Fennel", "fish": "fish", "fortran": "Fortran",
+	"fortran90": "Fortran Free Form", "fortran95": "Fortran Free Form",
+	"fortran03": "Fortran Free Form", "go": "Go", "hare": "Hare",
+	"haskell": "Haskell", "janet": "Janet", "java": "Java",
+	"javascript": "JavaScript", "julia": "Julia", "kotlin": "Kotlin",
+	"lex": "Lex", "lisp": "Common Lisp", "lua": "Lua", "m4": "M4",
+	"makefile": "Makefile", "matlab": "MATLAB", "mercury": "Mercury",
+	"ml": "OCaml", "modelica": "Modelica", "modula": "Modula-2",
+	"modula2": "Modula-2", "modula3": "Modula-3", "mumps": "M",
+	"nim": "Nim", "objective-c": "Objective-C", "pascal": "Pascal",
+	"perl": "Perl", "php": "PHP", "php3": "PHP", "php4": "PHP",
+	"php5": "PHP", "php6": "PHP", "php7": "PHP", "pony": "Pony",
+	"postscript": "PostScript", "prolog": "Prolog",
+	"purescript": "PureScript", "python": "Python", "reason": "Reason",
+	"rebol": "Rebol", "red": "Red", "rescript": "ReScript",
+	"ruby": "Ruby", "rust": "Rust", "scheme": "Scheme",
+	"scons": "Python", "sed": "sed", "shell": "Shell",
+	"sml": "Standard ML", "sql": "SQL", "swift": "Swift", "tcl": "Tcl",
+	"v": "V", "vb": "Visual Basic .NET", "verilog": "Verilog",
+	"vhdl": "VHDL", "waf": "Python",
+	"wolfram": "Mathematica", "yacc": "Yacc", "zsh": "Shell",
+}
+
+// LinguistName - the name Linguist gives a language, or the name itself
+func LinguistName(name string) string {
+	if mapped, ok := linguistTable[name]; ok {
+		return mapped
+	}
+	return name
+}
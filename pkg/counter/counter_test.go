@@ -0,0 +1,177 @@
+package counter
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// Registered counters and verifiers must be able to open the paths they
+// are given, even when the tree counted is not the current directory.
+func TestRegisteredLanguageOutsideCwd(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "x.zzz"), []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := registeredLanguages
+	defer func() { registeredLanguages = saved }()
+	err := RegisterLanguage(Language{
+		Name:     "zzz",
+		Suffixes: []string{".zzz"},
+		Verifier: func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		},
+		Counter: CounterFunc(func(path string) SourceStat {
+			text, err := os.ReadFile(path)
+			if err != nil {
+				t.Errorf("counter can't read %s: %v", path, err)
+			}
+			return SourceStat{SLOC: uint(bytes.Count(text, []byte("\n")))}
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := CountTree(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range stats {
+		if filepath.Base(st.Path) == "x.zzz" {
+			if st.Path != filepath.Join("sub", "x.zzz") {
+				t.Errorf("reported path is %s, not relative to the root", st.Path)
+			}
+			if st.Language != "zzz" || st.SLOC != 2 {
+				t.Errorf("counted as %s with %d SLOC, want zzz with 2", st.Language, st.SLOC)
+			}
+			return
+		}
+	}
+	t.Errorf("x.zzz was not counted: %v", stats)
+}
+
+// Overlapping CountTree calls must each see their own options.
+func TestCountTreeConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.c", "b.py"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x = 1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan []SourceStat)
+	for _, skip := range []string{`\.c$`, `\.py$`} {
+		go func(skip string) {
+			stats, err := CountTree(dir, Options{Exclude: regexp.MustCompile(skip)})
+			if err != nil {
+				t.Error(err)
+			}
+			done <- stats
+		}(skip)
+	}
+	for i := 0; i < 2; i++ {
+		if stats := <-done; len(stats) != 1 {
+			t.Errorf("got %d files, want 1: %v", len(stats), stats)
+		}
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, c := range []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*.c", "a.c", true},
+		{"*.c", "d/a.c", false},
+		{"**/*.c", "d/e/a.c", true},
+		{"gen/**", "gen/x/y", true},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+		{"[ab].c", "a.c", true},
+		{"[ab].c", "c.c", false},
+		{"[a-c]x", "bx", true},
+		{"[!a-c]x", "dx", true},
+		{"[!a-c]x", "bx", false},
+		{"a[!x]b", "a/b", false},
+		{"[]]", "]", true},
+		{"[]a]", "a", true},
+		{"[!]]", "]", false},
+		{"[!]]", "x", true},
+		{"[]", "[]", true},
+		{"[!]", "[!]", true},
+		{"[ab", "[ab", true},
+		{"[\\]", "\\", true},
+		{"[^]", "^", true},
+		{"[[]", "[", true},
+	} {
+		re, err := globToRegexp(c.glob)
+		if err != nil {
+			t.Errorf("%s: %v", c.glob, err)
+			continue
+		}
+		if got := re.MatchString(c.path); got != c.want {
+			t.Errorf("%s against %s: got %t, want %t (regexp %s)", c.glob, c.path, got, c.want, re)
+		}
+	}
+}
+
+// A warning about a comment left open must say where it began.
+func TestPascalWarningLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.pas")
+	text := "program a;\n(* one\n   two *)\nbegin\n{ open\nend.\n"
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings []Warning
+	opts := Options{Warn: func(w Warning) { warnings = append(warnings, w) }}
+	if _, err := CountFileParts(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || warnings[0].Kind != "unterminated-comment" || warnings[0].Line != 5 {
+		t.Errorf("got %+v, want an unterminated-comment warning at line 5", warnings)
+	}
+}
+
+// Inside a git work tree, the ignore rules of the directories above a
+// root apply to it, and so does the repository's info/exclude.
+func TestGitignoreAboveRoot(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{
+		".gitignore":        "build/\n",
+		".git/info/exclude": "z.c\n",
+		"sub/build/x.c":     "int a;\n",
+		"sub/src/y.c":       "int b;\n",
+		"sub/src/z.c":       "int c;\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := CountTree(filepath.Join(dir, "sub"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, st := range stats {
+		if st.SLOC > 0 {
+			paths = append(paths, filepath.ToSlash(st.Path))
+		}
+	}
+	if len(paths) != 1 || paths[0] != "src/y.c" {
+		t.Errorf("counted %v, want only src/y.c", paths)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package counter
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// mapFile - memory mapping is not available here; callers fall back
+// to reading the file.
+func mapFile(path string) ([]byte, func(), error) {
+	return nil, nil, errors.New("memory mapping not supported")
+}
+
+// fileIdentity - files can't be told apart by inode here
+func fileIdentity(info fs.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
@@ -0,0 +1,14 @@
+(* ::Package:: *)
+
+(* A greeting package.
+   (* Comments nest in the Wolfram Language. *)
+   Still a comment. *)
+BeginPackage["Greeting`"]
+
+greet::usage = "greet[name] says hello to name."
+
+Begin["`Private`"]
+greet[name_String] := "Hello, " <> name
+End[]
+
+EndPackage[]
@@ -0,0 +1,8 @@
+/* Say hello from ReScript */
+type person = {name: string}
+
+// Build the greeting
+let greet = (p: person) =>
+  `Hello, ${p.name}`
+
+Js.log(greet({name: "world"}))
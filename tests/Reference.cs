@@ -0,0 +1,15 @@
+//------------------------------------------------------------------------------
+// <auto-generated>
+//     This code was produced by a tool.
+//     Runtime Version:4.0.30319.42000
+// </auto-generated>
+//------------------------------------------------------------------------------
+
+namespace Sample.Properties {
+    internal sealed partial class Settings {
+        private static Settings defaultInstance = new Settings();
+        public static Settings Default {
+            get { return defaultInstance; }
+        }
+    }
+}
@@ -0,0 +1,18 @@
+/*
+ * Blink the on-board LED once a second.
+ */
+#define LED_PIN LED_BUILTIN
+
+const unsigned long interval = 1000;  // milliseconds
+
+void setup() {
+  pinMode(LED_PIN, OUTPUT);
+}
+
+// Toggle the LED, then wait
+void loop() {
+  digitalWrite(LED_PIN, HIGH);
+  delay(interval);
+  digitalWrite(LED_PIN, LOW);
+  delay(interval);
+}
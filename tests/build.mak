@@ -0,0 +1,11 @@
+# Build the demo
+CFLAGS = -O2 \
+	-Wall
+
+demo: demo.o util.o
+	$(CC) $(CFLAGS) -o $@ $^ \
+  -lm
+	# nothing else to do
+
+clean:
+	rm -f demo *.o
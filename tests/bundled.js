@@ -0,0 +1,3 @@
+/*! widgets 0.1 | MIT licence */
+var w0=function(e){return e.map(function(t){return t*0})};var w1=function(e){return e.map(function(t){return t*1})};var w2=function(e){return e.map(function(t){return t*2})};var w3=function(e){return e.map(function(t){return t*3})};var w4=function(e){return e.map(function(t){return t*4})};var w5=function(e){return e.map(function(t){return t*5})};var w6=function(e){return e.map(function(t){return t*6})};var w7=function(e){return e.map(function(t){return t*7})};var w8=function(e){return e.map(function(t){return t*8})};var w9=function(e){return e.map(function(t){return t*9})};var w10=function(e){return e.map(function(t){return t*10})};var w11=function(e){return e.map(function(t){return t*11})};
+function h0(n){return n&&h0(n-1)}function h1(n){return n&&h1(n-1)}function h2(n){return n&&h2(n-1)}function h3(n){return n&&h3(n-1)}function h4(n){return n&&h4(n-1)}function h5(n){return n&&h5(n-1)}function h6(n){return n&&h6(n-1)}function h7(n){return n&&h7(n-1)}function h8(n){return n&&h8(n-1)}function h9(n){return n&&h9(n-1)}function h10(n){return n&&h10(n-1)}function h11(n){return n&&h11(n-1)}
@@ -0,0 +1,46 @@
+%{
+/* A desk calculator */
+#include <stdio.h>
+int yylex(void);
+void yyerror(const char *s);
+%}
+
+%union {
+    double value;
+    char *name;
+}
+
+%token <value> NUMBER
+%left '+' '-'
+%left '*' '/'
+
+%%
+
+input:	/* empty */
+	| input line
+	;
+
+line:	'\n'
+	| expr '\n'	{ printf("%g\n", $1); }
+	;
+
+expr:	NUMBER
+	| expr '+' expr	{ $$ = $1 + $3; }
+	| expr '-' expr	{ $$ = $1 - $3; }
+	| expr '*' expr	{ $$ = $1 * $3; }
+	| expr '/' expr	{
+		if ($3 == 0) {
+			yyerror("division by zero");
+			YYERROR;
+		}
+		$$ = $1 / $3;
+	}
+	| '(' expr ')'	{ $$ = $2; }
+	;
+
+%%
+
+void yyerror(const char *s)
+{
+    fprintf(stderr, "%s\n", s);
+}
@@ -0,0 +1,8 @@
+c     A statement continued in column 6 is one logical line
+      program cont
+      real total
+      total = 1.0 +
+     &        2.0 +
+     1        3.0
+      print *, total
+      end
@@ -0,0 +1,9 @@
+! A statement continued over three lines is one logical line
+program continued
+  implicit none
+  real :: total
+  total = 1.0 + &   ! first part
+          2.0 + &
+          3.0
+  print *, total
+end program continued
@@ -0,0 +1,17 @@
+#include <stdio.h>
+
+#if 0
+/* Old implementation, kept for reference */
+int old_main(void) { return 1; }
+#if defined(DEBUG)
+int debug;
+#endif
+#endif
+
+#if 0
+int also_old;
+#else
+int current;
+#endif
+
+int main(void) { printf("hello\n"); return 0; }
@@ -0,0 +1 @@
+# The same script with LF, CR-LF and lone-CR line endingsdef greet(who):    """Say hello.    Docstrings span lines too.    """    print("Hello, %s!" % who)greet("World")
\ No newline at end of file
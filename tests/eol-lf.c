@@ -0,0 +1,12 @@
+/* The same program is saved here with LF, CR-LF and lone-CR
+   line endings; all three should count alike. */
+#include <stdio.h>
+#define GREET(who) \
+    printf("Hello, %s!\n", who)
+
+int main(void)
+{
+    // Statements end at semicolons whatever ends the line
+    GREET("World");
+    return 0;
+}
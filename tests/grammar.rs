@@ -0,0 +1,5 @@
+// @generated
+// Parser tables; regenerate with the grammar tool instead of editing.
+
+pub const ACTIONS: [i16; 8] = [0, 3, -1, 4, 2, -2, 0, 1];
+pub const GOTOS: [i16; 4] = [1, 0, 2, 0];
@@ -0,0 +1,9 @@
+# Greet the user by name
+function greet --argument-names name
+    if test -z "$name"
+        set name $USER
+    end
+    echo "Hello, $name"
+end
+
+greet $argv
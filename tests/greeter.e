@@ -0,0 +1,20 @@
+note
+	description: "[
+		Greets whoever is named on the command line.
+		-- This line is part of the string, not a comment.
+	]"
+
+class GREETER
+
+create
+	make
+
+feature -- Initialization
+
+	make
+			-- Say hello.
+		do
+			io.put_string ("Hello, world%N")
+		end
+
+end
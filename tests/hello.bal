@@ -0,0 +1,8 @@
+import ballerina/io;
+
+// Greet whoever is named, in a template.
+public function main(string name = "world") {
+    string greeting = string `Hello, ${name}!
+// Not a comment: templates run across lines.`;
+    io:println(greeting); // trailing comment
+}
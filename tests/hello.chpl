@@ -0,0 +1,7 @@
+/* Parallel hello world.
+   /* Block comments nest in Chapel. */ */
+config const numMessages = 4;
+
+// One message per task
+forall msg in 1..numMessages do
+  writeln("Hello, world! (from iteration ", msg, ")");
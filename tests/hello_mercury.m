@@ -0,0 +1,14 @@
+% The classic greeting, in Mercury.
+:- module hello_mercury.
+:- interface.
+:- import_module io.
+
+:- pred main(io::di, io::uo) is det.
+
+:- implementation.
+
+/* Write the greeting and stop. */
+main(!IO) :-
+    io.write_string("Hello, world\n", !IO).
+
+:- end_module hello_mercury.
@@ -0,0 +1,15 @@
+module main
+
+import os
+
+/* Greet whoever is named on the command line.
+   /* Block comments nest in V. */ */
+fn greeting(name string) string {
+	return 'Hello, ${name}'
+}
+
+fn main() {
+	// Default to the world
+	name := if os.args.len > 1 { os.args[1] } else { 'world' }
+	println(greeting(name))
+}
@@ -0,0 +1,8 @@
+<?php
+// A heredoc body is data
+$text = <<<EOT
+/* not a comment */
+// nor this
+EOT;
+echo $text;
+?>
@@ -0,0 +1,6 @@
+# Here-document in Perl
+print <<"EOF";
+# not a comment
+=pod not pod either
+EOF
+print "done\n";
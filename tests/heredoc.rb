@@ -0,0 +1,8 @@
+# Squiggly heredoc
+text = <<~EOS
+  # not a comment
+  text
+EOS
+list = []
+list << text
+puts text
@@ -0,0 +1,10 @@
+#!/bin/sh
+# Here-documents hold data, not comments
+cat <<EOF
+# this is not a comment
+
+EOF
+cat <<-'END'
+	# nor is this
+	END
+echo done
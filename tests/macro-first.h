@@ -0,0 +1,6 @@
+#define SWAP(a, b) do { \
+	int t = (a); (a) = (b); (b) = t; \
+} while (0)
+#define CLEAR(a) do { (a) = 0; } while (0)
+
+void swap(int *x, int *y);
@@ -0,0 +1,16 @@
+/* A #define is one logical line, on one line or continued */
+#define SWAP(a, b) do { \
+	int t = (a); (a) = (b); (b) = t; \
+} while (0)
+#define CLEAR(a) do { (a) = 0; } while (0)
+#include <stdio.h>
+
+int main(void)
+{
+	int x = 1, y = 2;
+	SWAP(x, y);
+	CLEAR(x);
+	printf("%d %d\n", \
+	       x, y);
+	return 0;
+}
@@ -0,0 +1,5 @@
+{- outer comment
+   {- inner comment -}
+   still a comment
+-}
+main = putStrLn "hello"
@@ -0,0 +1,7 @@
+/* outer comment
+   /* inner comment */
+   still a comment
+*/
+fn main() {
+    println!("hello");
+}
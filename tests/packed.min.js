@@ -0,0 +1 @@
+var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};var a=function(b){return b+1};
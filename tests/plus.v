@@ -0,0 +1,13 @@
+(* Addition on naturals, with a proof. *)
+Require Import Arith.
+
+Fixpoint plus (n m : nat) : nat :=
+  match n with
+  | O => m
+  | S p => S (plus p m)
+  end.
+
+Theorem plus_O_n : forall n : nat, plus O n = n.
+Proof.
+  intros n. reflexivity.
+Qed.
@@ -0,0 +1,4 @@
+(* List the first primes *)
+primesUpTo[n_Integer] := Select[Range[n], PrimeQ]
+
+Print[primesUpTo[30]]
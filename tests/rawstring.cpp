@@ -0,0 +1,7 @@
+#include <string>
+// A raw string can hold comment leaders and quotes
+const char *text = R"delim(
+/* not a comment */
+"still inside" )" ;
+)delim";
+int main() { return 0; }
@@ -0,0 +1,5 @@
+fn main() {
+    let s = r#"a "quoted" /* not a comment */"#;
+    let t = r"C:\path";
+    println!("{} {}", s, t);
+}
@@ -0,0 +1,13 @@
+# Schema for the greeting service (MySQL)
+-- Written for MySQL 5.7 and up
+
+/*!40101 SET NAMES utf8mb4 */;
+
+/* The table of greetings.
+   One row per language. */
+CREATE TABLE `greeting` (
+  `id` INT NOT NULL AUTO_INCREMENT,  # surrogate key
+  `lang` CHAR(2) NOT NULL,
+  `text` VARCHAR(80) NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB;
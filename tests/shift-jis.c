@@ -0,0 +1,8 @@
+/* \tg - Shift-JIS sample, the trail bytes include backslashes */
+#include <stdio.h>
+
+int main(void)
+{
+    puts("\");
+    return 0;
+}
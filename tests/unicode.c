@@ -0,0 +1,14 @@
+/* 統計のためのテスト。漢字のコメント */
+#include <stdio.h>
+
+/* 漢字の識別子 (C99 以降は認められている) */
+static int 合計 = 0;
+　　
+int main(void)
+{
+    // 行末コメント：値を加える
+    合計 += 1;　/* 全角スペースの後のコメント */
+  
+    printf("結果 /* コメントではない */ %d\n", 合計);
+    return 0;
+}
@@ -0,0 +1,9 @@
+#!/usr/bin/perl
+# ヒアドキュメントの終端が漢字
+my $文 = "挨拶";
+print <<終わり;
+こんにちは、世界
+# これはコメントではない
+終わり
+　
+print "$文\n";
@@ -0,0 +1,7 @@
+class Program {
+    static string path = @"C:\temp\"" /* not a comment";
+    static string raw = """
+        // not a comment
+        """;
+    static void Main() { }
+}